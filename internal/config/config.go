@@ -1,27 +1,1194 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"gopkg.in/yaml.v3"
+)
+
+// Config agrupa toda la configuración del servidor. Se construye en este orden
+// de precedencia: valores por defecto < fichero YAML < variables de entorno < flags.
 type Config struct {
-	Port      int
-	UDPBindIP string
+	ListenAddr string           `yaml:"listen_addr"`
+	LogPath    string           `yaml:"log_path"`
+	Log        LogConfig        `yaml:"log"`
+	StaticDir  string           `yaml:"static_dir"`
+	TLS        TLSConfig        `yaml:"tls"`
+	ICEServers []ICEServer      `yaml:"ice_servers"`
+	UDPPorts   PortRange        `yaml:"udp_ports"`
+	ICEMux     ICEMuxConfig     `yaml:"ice_mux"`
+	Media      MediaConfig      `yaml:"media"`
+	Simulcast  SimulcastConfig  `yaml:"simulcast"`
+	Jitter     JitterConfig     `yaml:"jitter"`
+	Congestion CongestionConfig `yaml:"congestion"`
+	Transcoder TranscoderConfig `yaml:"transcoder"`
+	FFmpeg     FFmpegConfig     `yaml:"ffmpeg"`
+	GStreamer  GStreamerConfig  `yaml:"gstreamer"`
+	Tunnel     TunnelConfig     `yaml:"tunnel"`
+	Share      ShareConfig      `yaml:"share"`
+	Chat       ChatConfig       `yaml:"chat"`
+	Control    ControlConfig    `yaml:"control"`
+	Edge       EdgeConfig       `yaml:"edge"`
+	Store      StoreConfig      `yaml:"store"`
+	DVR        DVRConfig        `yaml:"dvr"`
+	Clips      ClipsConfig      `yaml:"clips"`
+	Motion     MotionConfig     `yaml:"motion"`
+	TURN       TURNConfig       `yaml:"turn"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Debug      bool             `yaml:"debug"`
+	// ShutdownTimeout es el tiempo máximo de espera a las peticiones en curso al apagar
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	configPath      string
+}
+
+// LogConfig controla el logging estructurado (log/slog) que se escribe en
+// log_path: formato, niveles por componente, rotación y copia en stderr.
+type LogConfig struct {
+	// Format es text o json
+	Format string `yaml:"format"`
+	// Level es el nivel por defecto (debug, info, warn o error)
+	Level string `yaml:"level"`
+	// Components fija el nivel de componentes concretos (relay, webrtc,
+	// viewer, jitter, pipeline, transcoder, rtcp, signaling, ice, turn, tls...)
+	Components map[string]string `yaml:"components"`
+	// Stderr duplica los logs en la salida de error estándar
+	Stderr bool `yaml:"stderr"`
+	// MaxSizeMB rota el fichero al superar ese tamaño (0 = sin límite)
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// RotateEvery rota el fichero periódicamente (0 = nunca)
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	// MaxBackups y MaxAge limitan los ficheros rotados que se conservan (0 = sin límite)
+	MaxBackups int           `yaml:"max_backups"`
+	MaxAge     time.Duration `yaml:"max_age"`
+}
+
+// TLSConfig activa HTTPS, necesario para que los navegadores permitan
+// getUserMedia fuera de localhost. Sin cert_file/key_file se usa una CA
+// autofirmada guardada en cert_dir que emite el certificado de servidor.
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CertDir  string `yaml:"cert_dir"`
+	// Hosts añade nombres DNS o IPs al certificado autofirmado, además de
+	// localhost y las IPs de las interfaces locales. La CA sólo puede
+	// certificar la red local y los nombres que había al crearla: si se añaden
+	// otros después hay que borrarla para que se cree de nuevo.
+	Hosts []string `yaml:"hosts"`
+}
+
+// ICEServer describe un servidor STUN/TURN que se ofrece a los PeerConnections.
+type ICEServer struct {
+	URLs       []string `yaml:"urls"`
+	Username   string   `yaml:"username"`
+	Credential string   `yaml:"credential"`
+}
+
+// PortRange limita los puertos UDP efímeros que usa ICE. 0/0 significa sin límite.
+type PortRange struct {
+	Min uint16 `yaml:"min"`
+	Max uint16 `yaml:"max"`
+}
+
+// ICEMuxConfig permite que todas las sesiones compartan un único puerto UDP y
+// un único puerto TCP para ICE, y controla qué direcciones se anuncian.
+type ICEMuxConfig struct {
+	UDPPort int `yaml:"udp_port"`
+	TCPPort int `yaml:"tcp_port"`
+	// NAT1To1IPs sustituye las IPs de los candidatos por las IPs públicas indicadas
+	NAT1To1IPs []string `yaml:"nat_1to1_ips"`
+	// NAT1To1CandidateType indica cómo se anuncian esas IPs: "host" o "srflx"
+	NAT1To1CandidateType string `yaml:"nat_1to1_candidate_type"`
+	// Interfaces limita las interfaces de red usadas (patrones glob, vacío = todas)
+	Interfaces []string `yaml:"interfaces"`
+	// ExcludeInterfaces descarta interfaces (p. ej. docker0, br-*, veth*)
+	ExcludeInterfaces []string `yaml:"exclude_interfaces"`
+	// ExcludeCIDRs descarta direcciones locales concretas (p. ej. 172.17.0.0/16)
+	ExcludeCIDRs []string `yaml:"exclude_cidrs"`
+}
+
+// MediaConfig define la política de medios por defecto de los canales que no
+// indican una propia al registrarse.
+type MediaConfig struct {
+	// CodecPreference ordena los códecs de vídeo (vp8, h264, vp9, av1)
+	CodecPreference []string `yaml:"codec_preference"`
+	// MaxBitrateKbps limita el bitrate que se pide al publicador (0 = sin límite)
+	MaxBitrateKbps int `yaml:"max_bitrate_kbps"`
+}
+
+// SimulcastConfig controla el uso de las capas simulcast del publicador.
+type SimulcastConfig struct {
+	// MJPEGLayer es el RID que alimenta el pipeline MJPEG; si el publicador no
+	// lo envía se usa la capa intermedia.
+	MJPEGLayer string `yaml:"mjpeg_layer"`
+}
+
+// JitterConfig controla el jitter buffer por track y la recuperación de
+// paquetes mediante NACK/RTX. Latency debe superar el RTT hacia el publicador
+// para que las retransmisiones lleguen a tiempo; 0 desactiva la reordenación.
+type JitterConfig struct {
+	Latency time.Duration `yaml:"latency"`
+	// NACKInterval es cada cuánto se envían NACKs de los huecos pendientes
+	NACKInterval time.Duration `yaml:"nack_interval"`
+	// NACKBufferSize es el número de paquetes recordados para generar NACKs
+	// (potencia de 2 entre 64 y 32768)
+	NACKBufferSize int `yaml:"nack_buffer_size"`
+}
+
+// CongestionConfig controla el bitrate que se pide a los publicadores. Además
+// del feedback TWCC, cada Interval se envía un REMB con el tope del canal
+// (media.max_bitrate_kbps o el del registro; MaxKbps si no hay ninguno), que
+// se reduce hasta MinKbps mientras el pipeline MJPEG no da abasto: cola de
+// entrada por encima de LagQueue (fracción de su capacidad), paquetes
+// descartados o pipeline atascado.
+type CongestionConfig struct {
+	REMB     bool          `yaml:"remb"`
+	Interval time.Duration `yaml:"interval"`
+	MaxKbps  int           `yaml:"max_kbps"`
+	MinKbps  int           `yaml:"min_kbps"`
+	LagQueue float64       `yaml:"lag_queue"`
+}
+
+// TranscoderConfig elige el backend que convierte el vídeo en MJPEG (ffmpeg,
+// gstreamer o fake) y controla la supervisión del proceso.
+type TranscoderConfig struct {
+	Backend string `yaml:"backend"`
+	// RestartBackoff es la espera inicial antes de relanzar el transcodificador;
+	// se duplica en cada fallo consecutivo hasta MaxRestartBackoff
+	RestartBackoff    time.Duration `yaml:"restart_backoff"`
+	MaxRestartBackoff time.Duration `yaml:"max_restart_backoff"`
+	// StallTimeout marca el pipeline como atascado y lo relanza si no produce
+	// ningún JPEG durante ese tiempo
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+	// StderrLines es el número de líneas recientes de stderr que se guardan por canal
+	StderrLines int `yaml:"stderr_lines"`
 }
 
-// Global variable to store the ngrok public URL
-var NgrokPublicURL string
+// FFmpegConfig define el binario de ffmpeg y los argumentos de codificación
+// que se insertan entre la entrada y la salida MJPEG por stdout.
+type FFmpegConfig struct {
+	Binary string   `yaml:"binary"`
+	Args   []string `yaml:"args"`
+}
+
+// GStreamerConfig define el binario gst-launch y el codificador JPEG que se
+// coloca tras el decodificador.
+type GStreamerConfig struct {
+	Binary  string `yaml:"binary"`
+	Encoder string `yaml:"encoder"`
+}
+
+// TunnelConfig elige el proveedor que publica el servidor en Internet (none,
+// ngrok, cloudflared o static) y controla su supervisión: el proceso se
+// relanza con espera exponencial si termina o si falla la comprobación de salud.
+type TunnelConfig struct {
+	Provider string `yaml:"provider"`
+	// PublicURL es la URL base del proveedor static (p. ej. un proxy inverso propio)
+	PublicURL string `yaml:"public_url"`
+	// StartupDelay, Retries y RetryDelay controlan la obtención de la URL pública
+	// tras arrancar el túnel; RetryDelay se duplica en cada intento
+	StartupDelay time.Duration `yaml:"startup_delay"`
+	Retries      int           `yaml:"retries"`
+	RetryDelay   time.Duration `yaml:"retry_delay"`
+	// HealthInterval es cada cuánto se comprueba que el túnel sigue activo
+	HealthInterval    time.Duration     `yaml:"health_interval"`
+	RestartBackoff    time.Duration     `yaml:"restart_backoff"`
+	MaxRestartBackoff time.Duration     `yaml:"max_restart_backoff"`
+	Ngrok             NgrokConfig       `yaml:"ngrok"`
+	Cloudflared       CloudflaredConfig `yaml:"cloudflared"`
+}
+
+// NgrokConfig define el binario de ngrok y su API local. Sin binario se busca
+// ngrok (o ngrok.exe) en el directorio de trabajo y después en el PATH.
+type NgrokConfig struct {
+	Binary string `yaml:"binary"`
+	APIURL string `yaml:"api_url"`
+}
+
+// CloudflaredConfig define el binario de cloudflared. Sin Token se abre un
+// quick tunnel (URL aleatoria en trycloudflare.com); con Token se ejecuta el
+// túnel con nombre y la URL pública es https://Hostname.
+type CloudflaredConfig struct {
+	Binary string `yaml:"binary"`
+	// MetricsAddr es la dirección del servidor de métricas, usado para obtener
+	// la URL del quick tunnel y como comprobación de salud
+	MetricsAddr string `yaml:"metrics_addr"`
+	Token       string `yaml:"token"`
+	Hostname    string `yaml:"hostname"`
+}
+
+// ShareConfig controla los enlaces para compartir con token (/share y /s/).
+// Los tokens se firman con Secret; sin secreto se genera uno aleatorio y los
+// enlaces dejan de valer al reiniciar el servidor.
+//
+// Sin Required un enlace compartido sólo es un atajo con caducidad a la página
+// del canal: quien conozca el código puede verlo igual. Con Required sólo el
+// operador crea enlaces, los viewers necesitan uno vigente para registrarse y
+// /watch, /watchrtc y las descargas de frames exigen el token de cliente.
+type ShareConfig struct {
+	Secret string `yaml:"secret"`
+	// TTL es la validez por defecto de un enlace; MaxTTL la máxima que se puede pedir
+	TTL      time.Duration `yaml:"ttl"`
+	MaxTTL   time.Duration `yaml:"max_ttl"`
+	Required bool          `yaml:"required"`
+}
 
-// Getter for the ngrok public URL
-func GetNgrokPublicURL() string {
-	return NgrokPublicURL
+// ChatConfig controla el chat de texto de cada canal: cuántos mensajes se
+// guardan para quien llega tarde, su longitud máxima y el ritmo permitido a
+// cada participante (Rate mensajes por segundo con ráfagas de hasta Burst).
+type ChatConfig struct {
+	History   int     `yaml:"history"`
+	MaxLength int     `yaml:"max_length"`
+	Rate      float64 `yaml:"rate"`
+	Burst     int     `yaml:"burst"`
 }
 
-func Load() Config {
-	udpIP := os.Getenv("UDP_BIND_IP")
-	if udpIP == "" {
-		udpIP = "127.0.0.1"
+// ControlCommands son las órdenes que un viewer puede enviar al publicador.
+var ControlCommands = []string{"camera", "torch", "resolution", "mute", "keyframe"}
+
+// ControlConfig controla las órdenes de los viewers al publicador (/control).
+// El operador del canal (quien lo creó) puede enviarlas todas; el resto de
+// viewers sólo las de ViewerCommands. Timeout es la espera máxima a la
+// confirmación del publicador.
+type ControlConfig struct {
+	ViewerCommands []string      `yaml:"viewer_commands"`
+	Timeout        time.Duration `yaml:"timeout"`
+}
+
+// EdgeConfig convierte este servidor en edge de otras instancias (orígenes):
+// cada canal de Channels se pide a su origen como un viewer WebRTC más y se
+// sirve aquí como si tuviera un publicador local. Si la conexión se pierde, o
+// no llega vídeo durante IdleTimeout, se reconecta con espera exponencial
+// entre RetryBackoff y MaxRetryBackoff.
+//
+// POST y DELETE /edge exigen AdminToken (sin él están desactivados) y POST
+// sólo acepta los orígenes de AllowedOrigins o de Channels, para que nadie
+// pueda usar el servidor para pedir URLs arbitrarias de la LAN.
+type EdgeConfig struct {
+	Channels        []EdgeChannel `yaml:"channels"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	// AdminToken autoriza POST y DELETE /edge (cabecera X-Admin-Token)
+	AdminToken string `yaml:"admin_token"`
+	// AllowedOrigins son las URL base que se pueden pedir con POST /edge
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// CAFile añade una CA para verificar el HTTPS de los orígenes (p. ej. su /ca.crt)
+	CAFile string `yaml:"ca_file"`
+	// InsecureSkipVerify no verifica el certificado de los orígenes
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// EdgeChannel es un canal que se tira de un origen.
+type EdgeChannel struct {
+	// Code es el código del canal en este servidor
+	Code string `yaml:"code"`
+	// Origin es la URL base del origen (p. ej. https://origen:8080)
+	Origin string `yaml:"origin"`
+	// RemoteCode es el código del canal en el origen (vacío = el mismo que Code)
+	RemoteCode string `yaml:"remote_code"`
+	// Share es el token de un enlace compartido del canal, necesario si el
+	// origen tiene share.required
+	Share string `yaml:"share"`
+}
+
+// StoreConfig controla la persistencia de las definiciones de canal. Los
+// canales guardados en Path (fichero bbolt) se recrean al arrancar a la
+// espera de su publicador. Sin Path no se guarda nada.
+type StoreConfig struct {
+	Path string `yaml:"path"`
+}
+
+// DVRConfig controla el buffer de time-shift de los canales MJPEG: se
+// guardan los JPEG de los últimos Window con su hora para que los viewers
+// puedan rebobinar. Cada canal usa hasta MemoryMB de memoria y los frames más
+// antiguos pasan a ficheros en Dir (vacío = sólo memoria).
+type DVRConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Window   time.Duration `yaml:"window"`
+	MemoryMB int           `yaml:"memory_mb"`
+	Dir      string        `yaml:"dir"`
+}
+
+// ClipsConfig controla la exportación de clips desde el buffer DVR (/clips).
+// Los ficheros se guardan en Dir y se borran pasado Retention. MaxDuration
+// limita la duración de cada clip y MaxJobs las exportaciones simultáneas;
+// como mucho MaxQueued más esperan hueco y el resto se rechaza con 429.
+type ClipsConfig struct {
+	Dir         string        `yaml:"dir"`
+	Retention   time.Duration `yaml:"retention"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+	MaxJobs     int           `yaml:"max_jobs"`
+	MaxQueued   int           `yaml:"max_queued"`
+}
+
+// MotionConfig controla la detección de movimiento sobre los JPEG de cada
+// canal: se analizan hasta FPS frames por segundo reducidos a una rejilla de
+// luminancia y se comparan con el anterior. Sensitivity (1-100) fija cuánto
+// tiene que cambiar cada punto y qué parte de la zona. El movimiento empieza
+// con dos frames seguidos con cambios y termina tras Cooldown sin ellos.
+type MotionConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	FPS         float64       `yaml:"fps"`
+	Sensitivity int           `yaml:"sensitivity"`
+	Cooldown    time.Duration `yaml:"cooldown"`
+	// Zones limita el análisis a esas zonas del frame (vacío = el frame entero)
+	Zones []MotionZone `yaml:"zones"`
+	// History es el número de eventos, con su captura, que se guardan por canal
+	History int `yaml:"history"`
+	// Webhooks reciben cada evento por POST en JSON con la captura en base64,
+	// firmado con HMAC-SHA256 de WebhookSecret si no está vacío
+	Webhooks       []string      `yaml:"webhooks"`
+	WebhookSecret  string        `yaml:"webhook_secret"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+}
+
+// MotionZone es un rectángulo del frame en fracciones de su ancho y alto (0 a 1)
+type MotionZone struct {
+	Name string  `yaml:"name" json:"name"`
+	X    float64 `yaml:"x" json:"x"`
+	Y    float64 `yaml:"y" json:"y"`
+	W    float64 `yaml:"w" json:"w"`
+	H    float64 `yaml:"h" json:"h"`
+}
+
+// Validate comprueba que la zona tiene área y cae dentro del frame
+func (z MotionZone) Validate() error {
+	if z.W <= 0 || z.H <= 0 || z.X < 0 || z.Y < 0 || z.X+z.W > 1 || z.Y+z.H > 1 {
+		return fmt.Errorf("zona %q fuera del frame (x, y, w y h son fracciones de 0 a 1)", z.Name)
 	}
+	return nil
+}
+
+// TURNConfig configura el servidor TURN embebido. Las credenciales que se
+// entregan a los navegadores se derivan de Secret y caducan tras CredentialTTL.
+type TURNConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	ListenAddr    string        `yaml:"listen_addr"`
+	PublicIP      string        `yaml:"public_ip"`
+	Realm         string        `yaml:"realm"`
+	Secret        string        `yaml:"secret"`
+	CredentialTTL time.Duration `yaml:"credential_ttl"`
+	RelayPorts    PortRange     `yaml:"relay_ports"`
+}
+
+// LimitsConfig agrupa los límites de recursos. 0 significa sin límite.
+type LimitsConfig struct {
+	MaxChannels             int `yaml:"max_channels"`
+	MaxViewersPerChannel    int `yaml:"max_viewers_per_channel"`
+	MaxPublishersPerChannel int `yaml:"max_publishers_per_channel"`
+	// MaxPipelines limita los transcodificadores MJPEG simultáneos
+	MaxPipelines int `yaml:"max_pipelines"`
+	// PerIP y Global limitan el ritmo de peticiones a /register, /stream,
+	// /watch, /watchrtc, /ice, /edge y POST /clips de cada IP y del conjunto
+	// de clientes
+	PerIP  RateLimit `yaml:"per_ip"`
+	Global RateLimit `yaml:"global"`
+}
+
+// RateLimit permite Rate peticiones por segundo con ráfagas de hasta Burst.
+// Rate 0 significa sin límite.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// publicURL es la URL base pública del servidor (túnel o static). Puede
+// cambiar en tiempo de ejecución si el túnel se relanza.
+var publicURL struct {
+	sync.RWMutex
+	url string
+}
+
+// PublicURL devuelve la URL base pública actual, o "" si no hay ninguna
+func PublicURL() string {
+	publicURL.RLock()
+	defer publicURL.RUnlock()
+	return publicURL.url
+}
+
+// SetPublicURL actualiza la URL base pública (sin barra final)
+func SetPublicURL(u string) {
+	publicURL.Lock()
+	defer publicURL.Unlock()
+	publicURL.url = strings.TrimSuffix(u, "/")
+}
+
+// Default devuelve la configuración por defecto, equivalente al comportamiento histórico.
+func Default() Config {
 	return Config{
-		Port:      8080,
-		UDPBindIP: udpIP,
+		ListenAddr:      ":8080",
+		LogPath:         "webrtc_server.log",
+		ShutdownTimeout: 10 * time.Second,
+		Log: LogConfig{
+			Format:     "text",
+			Level:      "info",
+			MaxSizeMB:  50,
+			MaxBackups: 5,
+			MaxAge:     7 * 24 * time.Hour,
+		},
+		TLS: TLSConfig{
+			CertDir: "certs",
+		},
+		ICEServers: []ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
+		ICEMux: ICEMuxConfig{
+			NAT1To1CandidateType: "host",
+		},
+		Simulcast: SimulcastConfig{
+			MJPEGLayer: "h",
+		},
+		Jitter: JitterConfig{
+			Latency:        150 * time.Millisecond,
+			NACKInterval:   100 * time.Millisecond,
+			NACKBufferSize: 512,
+		},
+		Congestion: CongestionConfig{
+			REMB:     true,
+			Interval: time.Second,
+			MaxKbps:  2500,
+			MinKbps:  150,
+			LagQueue: 0.25,
+		},
+		Transcoder: TranscoderConfig{
+			Backend:           "ffmpeg",
+			RestartBackoff:    time.Second,
+			MaxRestartBackoff: 30 * time.Second,
+			StallTimeout:      10 * time.Second,
+			StderrLines:       200,
+		},
+		FFmpeg: FFmpegConfig{
+			Binary: "ffmpeg",
+			Args:   []string{"-an", "-vf", "scale=-1:-1", "-c:v", "mjpeg", "-q:v", "8"},
+		},
+		GStreamer: GStreamerConfig{
+			Binary:  "gst-launch-1.0",
+			Encoder: "jpegenc quality=75",
+		},
+		Tunnel: TunnelConfig{
+			Provider:          "ngrok",
+			StartupDelay:      2 * time.Second,
+			Retries:           5,
+			RetryDelay:        2 * time.Second,
+			HealthInterval:    30 * time.Second,
+			RestartBackoff:    2 * time.Second,
+			MaxRestartBackoff: 2 * time.Minute,
+			Ngrok: NgrokConfig{
+				APIURL: "http://127.0.0.1:4040/api/tunnels",
+			},
+			Cloudflared: CloudflaredConfig{
+				Binary:      "cloudflared",
+				MetricsAddr: "127.0.0.1:20241",
+			},
+		},
+		Share: ShareConfig{
+			TTL:    24 * time.Hour,
+			MaxTTL: 7 * 24 * time.Hour,
+		},
+		Chat: ChatConfig{
+			History:   50,
+			MaxLength: 500,
+			Rate:      1,
+			Burst:     5,
+		},
+		Control: ControlConfig{
+			ViewerCommands: []string{"keyframe"},
+			Timeout:        5 * time.Second,
+		},
+		Edge: EdgeConfig{
+			RetryBackoff:    time.Second,
+			MaxRetryBackoff: 30 * time.Second,
+			IdleTimeout:     10 * time.Second,
+		},
+		DVR: DVRConfig{
+			Window:   10 * time.Minute,
+			MemoryMB: 64,
+			Dir:      "dvr",
+		},
+		Clips: ClipsConfig{
+			Dir:         "clips",
+			Retention:   24 * time.Hour,
+			MaxDuration: 5 * time.Minute,
+			MaxJobs:     2,
+			MaxQueued:   8,
+		},
+		Motion: MotionConfig{
+			FPS:            5,
+			Sensitivity:    50,
+			Cooldown:       5 * time.Second,
+			History:        50,
+			WebhookTimeout: 5 * time.Second,
+		},
+		TURN: TURNConfig{
+			ListenAddr:    "0.0.0.0:3478",
+			Realm:         "go-pion-stream",
+			CredentialTTL: time.Hour,
+		},
+		Limits: LimitsConfig{
+			PerIP: RateLimit{Rate: 10, Burst: 40},
+		},
+	}
+}
+
+// Load construye la configuración a partir de los argumentos de línea de comandos
+// (sin el nombre del programa), el fichero indicado con -config o GOPION_CONFIG y
+// las variables de entorno. La configuración resultante ya está validada.
+func Load(args []string) (Config, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("go-pion-stream", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("GOPION_CONFIG"), "ruta al fichero de configuración YAML")
+	listen := fs.String("listen", "", "dirección de escucha HTTP (p. ej. :8080)")
+	logPath := fs.String("log", "", "ruta del fichero de log")
+	logFormat := fs.String("log-format", "", "formato de log (text o json)")
+	logLevel := fs.String("log-level", "", "nivel de log por defecto (debug, info, warn o error)")
+	logStderr := fs.Bool("log-stderr", false, "duplicar los logs en stderr")
+	staticDir := fs.String("static", "", "directorio que sustituye a los ficheros estáticos embebidos")
+	iceServers := fs.String("ice", "", "URLs de servidores ICE separadas por comas")
+	udpPorts := fs.String("udp-ports", "", "rango de puertos UDP para ICE (min-max)")
+	iceUDPPort := fs.Int("ice-udp-port", 0, "puerto UDP único compartido por todas las sesiones ICE (0 = desactivado)")
+	iceTCPPort := fs.Int("ice-tcp-port", 0, "puerto TCP único para ICE-TCP (0 = desactivado)")
+	natIPs := fs.String("nat-ip", "", "IPs públicas 1:1 anunciadas en los candidatos, separadas por comas")
+	codecs := fs.String("codecs", "", "preferencia de códecs de vídeo por defecto (p. ej. h264,vp8)")
+	jitterLatency := fs.Duration("jitter-latency", 0, "latencia del jitter buffer por track (0 = sin reordenación)")
+	transcoderBackend := fs.String("transcoder", "", "backend de transcodificación por defecto (ffmpeg, gstreamer o fake)")
+	ffmpegBin := fs.String("ffmpeg", "", "ruta del binario de ffmpeg")
+	tunnelProvider := fs.String("tunnel", "", "proveedor de túnel (none, ngrok, cloudflared o static)")
+	publicURLFlag := fs.String("public-url", "", "URL pública fija (proveedor static)")
+	ngrokEnabled := fs.Bool("ngrok", true, "usar ngrok como túnel (-ngrok=false equivale a -tunnel none)")
+	ngrokBin := fs.String("ngrok-bin", "", "ruta del binario de ngrok")
+	cloudflaredBin := fs.String("cloudflared-bin", "", "ruta del binario de cloudflared")
+	turnEnabled := fs.Bool("turn", false, "arrancar el servidor TURN embebido")
+	turnPublicIP := fs.String("turn-public-ip", "", "IP pública anunciada por el servidor TURN")
+	maxChannels := fs.Int("max-channels", 0, "número máximo de canales (0 = sin límite)")
+	maxViewers := fs.Int("max-viewers", 0, "viewers máximos por canal (0 = sin límite)")
+	maxPublishers := fs.Int("max-publishers", 0, "publicadores máximos por canal (0 = sin límite)")
+	maxPipelines := fs.Int("max-pipelines", 0, "transcodificadores MJPEG simultáneos (0 = sin límite)")
+	dvrEnabled := fs.Bool("dvr", false, "guardar los últimos minutos de cada canal MJPEG para rebobinar")
+	dvrWindow := fs.Duration("dvr-window", 0, "duración del buffer DVR (p. ej. 10m)")
+	motionEnabled := fs.Bool("motion", false, "detectar movimiento en los frames de cada canal")
+	storePath := fs.String("store", "", "fichero donde se guardan las definiciones de canal (vacío = sin persistencia)")
+	tlsEnabled := fs.Bool("tls", false, "servir HTTPS (certificado propio o CA autofirmada)")
+	tlsCert := fs.String("tls-cert", "", "fichero PEM del certificado TLS")
+	tlsKey := fs.String("tls-key", "", "fichero PEM de la clave privada TLS")
+	debug := fs.Bool("debug", false, "modo debug (sin túnel)")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if *configPath != "" {
+		if err := c.loadFile(*configPath); err != nil {
+			return c, err
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return c, err
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			c.ListenAddr = *listen
+		case "log":
+			c.LogPath = *logPath
+		case "log-format":
+			c.Log.Format = *logFormat
+		case "log-level":
+			c.Log.Level = *logLevel
+		case "log-stderr":
+			c.Log.Stderr = *logStderr
+		case "static":
+			c.StaticDir = *staticDir
+		case "ice":
+			c.ICEServers = parseICEURLs(*iceServers)
+		case "udp-ports":
+			r, err := parsePortRange(*udpPorts)
+			if err != nil {
+				flagErr = fmt.Errorf("-udp-ports: %w", err)
+			}
+			c.UDPPorts = r
+		case "ice-udp-port":
+			c.ICEMux.UDPPort = *iceUDPPort
+		case "ice-tcp-port":
+			c.ICEMux.TCPPort = *iceTCPPort
+		case "nat-ip":
+			c.ICEMux.NAT1To1IPs = splitList(*natIPs)
+		case "codecs":
+			c.Media.CodecPreference = splitList(*codecs)
+		case "jitter-latency":
+			c.Jitter.Latency = *jitterLatency
+		case "transcoder":
+			c.Transcoder.Backend = *transcoderBackend
+		case "ffmpeg":
+			c.FFmpeg.Binary = *ffmpegBin
+		case "tunnel":
+			c.Tunnel.Provider = *tunnelProvider
+		case "public-url":
+			c.Tunnel.PublicURL = *publicURLFlag
+		case "ngrok":
+			if *ngrokEnabled {
+				c.Tunnel.Provider = "ngrok"
+			} else if c.Tunnel.Provider == "ngrok" {
+				c.Tunnel.Provider = "none"
+			}
+		case "ngrok-bin":
+			c.Tunnel.Ngrok.Binary = *ngrokBin
+		case "cloudflared-bin":
+			c.Tunnel.Cloudflared.Binary = *cloudflaredBin
+		case "turn":
+			c.TURN.Enabled = *turnEnabled
+		case "turn-public-ip":
+			c.TURN.PublicIP = *turnPublicIP
+		case "max-channels":
+			c.Limits.MaxChannels = *maxChannels
+		case "max-viewers":
+			c.Limits.MaxViewersPerChannel = *maxViewers
+		case "max-publishers":
+			c.Limits.MaxPublishersPerChannel = *maxPublishers
+		case "max-pipelines":
+			c.Limits.MaxPipelines = *maxPipelines
+		case "dvr":
+			c.DVR.Enabled = *dvrEnabled
+		case "dvr-window":
+			c.DVR.Window = *dvrWindow
+		case "motion":
+			c.Motion.Enabled = *motionEnabled
+		case "store":
+			c.Store.Path = *storePath
+		case "tls":
+			c.TLS.Enabled = *tlsEnabled
+		case "tls-cert":
+			c.TLS.CertFile = *tlsCert
+		case "tls-key":
+			c.TLS.KeyFile = *tlsKey
+		case "debug":
+			c.Debug = *debug
+		}
+	})
+	if flagErr != nil {
+		return c, flagErr
+	}
+	if c.Debug {
+		c.Tunnel.Provider = "none"
+	}
+	c.configPath = *configPath
+
+	if err := c.Validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// loadFile lee un fichero YAML sobre la configuración actual. Las claves
+// desconocidas se rechazan para detectar errores tipográficos.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("no se pudo abrir el fichero de configuración: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("fichero de configuración %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv aplica las variables de entorno GOPION_* y las heredadas
+// (GO_DEBUG).
+func (c *Config) applyEnv() error {
+	if os.Getenv("GO_DEBUG") == "1" {
+		c.Debug = true
+	}
+	strVars := map[string]*string{
+		"GOPION_LISTEN_ADDR":       &c.ListenAddr,
+		"GOPION_LOG_PATH":          &c.LogPath,
+		"GOPION_LOG_FORMAT":        &c.Log.Format,
+		"GOPION_LOG_LEVEL":         &c.Log.Level,
+		"GOPION_STATIC_DIR":        &c.StaticDir,
+		"GOPION_FFMPEG":            &c.FFmpeg.Binary,
+		"GOPION_TRANSCODER":        &c.Transcoder.Backend,
+		"GOPION_GST_LAUNCH":        &c.GStreamer.Binary,
+		"GOPION_TLS_CERT":          &c.TLS.CertFile,
+		"GOPION_TLS_KEY":           &c.TLS.KeyFile,
+		"GOPION_TLS_DIR":           &c.TLS.CertDir,
+		"GOPION_TUNNEL":            &c.Tunnel.Provider,
+		"GOPION_PUBLIC_URL":        &c.Tunnel.PublicURL,
+		"GOPION_NGROK_BIN":         &c.Tunnel.Ngrok.Binary,
+		"GOPION_NGROK_API":         &c.Tunnel.Ngrok.APIURL,
+		"GOPION_CLOUDFLARED_BIN":   &c.Tunnel.Cloudflared.Binary,
+		"GOPION_CLOUDFLARED_TOKEN": &c.Tunnel.Cloudflared.Token,
+		"GOPION_TURN_ADDR":         &c.TURN.ListenAddr,
+		"GOPION_TURN_PUBLIC_IP":    &c.TURN.PublicIP,
+		"GOPION_TURN_SECRET":       &c.TURN.Secret,
+		"GOPION_SHARE_SECRET":      &c.Share.Secret,
+		"GOPION_EDGE_TOKEN":        &c.Edge.AdminToken,
+		"GOPION_STORE":             &c.Store.Path,
+		"GOPION_DVR_DIR":           &c.DVR.Dir,
+		"GOPION_CLIPS_DIR":         &c.Clips.Dir,
+		"GOPION_MOTION_SECRET":     &c.Motion.WebhookSecret,
+	}
+	for name, dst := range strVars {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	if v, ok := os.LookupEnv("GOPION_ICE_SERVERS"); ok {
+		c.ICEServers = parseICEURLs(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_NAT_1TO1_IPS"); ok {
+		c.ICEMux.NAT1To1IPs = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_ICE_EXCLUDE_INTERFACES"); ok {
+		c.ICEMux.ExcludeInterfaces = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_ICE_EXCLUDE_CIDRS"); ok {
+		c.ICEMux.ExcludeCIDRs = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_MOTION_WEBHOOKS"); ok {
+		c.Motion.Webhooks = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_EDGE_ALLOWED_ORIGINS"); ok {
+		c.Edge.AllowedOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_CONTROL_VIEWER_COMMANDS"); ok {
+		c.Control.ViewerCommands = splitList(v)
+	}
+	if v, ok := os.LookupEnv("GOPION_UDP_PORTS"); ok {
+		r, err := parsePortRange(v)
+		if err != nil {
+			return fmt.Errorf("GOPION_UDP_PORTS: %w", err)
+		}
+		c.UDPPorts = r
+	}
+	if v, ok := os.LookupEnv("GOPION_DVR_WINDOW"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("GOPION_DVR_WINDOW: %w", err)
+		}
+		c.DVR.Window = d
+	}
+	if v, ok := os.LookupEnv("GOPION_JITTER_LATENCY"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("GOPION_JITTER_LATENCY: %w", err)
+		}
+		c.Jitter.Latency = d
+	}
+	// GOPION_NGROK se mantiene por compatibilidad: false desactiva el túnel ngrok
+	if v, ok := os.LookupEnv("GOPION_NGROK"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("GOPION_NGROK: %w", err)
+		}
+		if b {
+			c.Tunnel.Provider = "ngrok"
+		} else if c.Tunnel.Provider == "ngrok" {
+			c.Tunnel.Provider = "none"
+		}
+	}
+	boolVars := map[string]*bool{
+		"GOPION_TURN":           &c.TURN.Enabled,
+		"GOPION_TLS":            &c.TLS.Enabled,
+		"GOPION_LOG_STDERR":     &c.Log.Stderr,
+		"GOPION_DVR":            &c.DVR.Enabled,
+		"GOPION_MOTION":         &c.Motion.Enabled,
+		"GOPION_SHARE_REQUIRED": &c.Share.Required,
+	}
+	for name, dst := range boolVars {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = b
+		}
+	}
+	intVars := map[string]*int{
+		"GOPION_MAX_CHANNELS":   &c.Limits.MaxChannels,
+		"GOPION_MAX_VIEWERS":    &c.Limits.MaxViewersPerChannel,
+		"GOPION_MAX_PUBLISHERS": &c.Limits.MaxPublishersPerChannel,
+		"GOPION_MAX_PIPELINES":  &c.Limits.MaxPipelines,
+		"GOPION_ICE_UDP_PORT":   &c.ICEMux.UDPPort,
+		"GOPION_ICE_TCP_PORT":   &c.ICEMux.TCPPort,
+	}
+	for name, dst := range intVars {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// Validate comprueba la coherencia de la configuración y devuelve todos los
+// errores encontrados a la vez.
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr %q inválida: %w", c.ListenAddr, err))
+	}
+	if c.LogPath == "" {
+		errs = append(errs, errors.New("log_path no puede estar vacío"))
+	}
+	if f := c.Log.Format; f != "text" && f != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q inválido (text o json)", f))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q inválido (debug, info, warn o error)", c.Log.Level))
+	}
+	for component, l := range c.Log.Components {
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			errs = append(errs, fmt.Errorf("log.components.%s: nivel %q inválido", component, l))
+		}
+	}
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxBackups < 0 || c.Log.RotateEvery < 0 || c.Log.MaxAge < 0 {
+		errs = append(errs, errors.New("log.max_size_mb, log.rotate_every, log.max_backups y log.max_age no pueden ser negativos"))
+	}
+	if c.StaticDir != "" {
+		if st, err := os.Stat(c.StaticDir); err != nil || !st.IsDir() {
+			errs = append(errs, fmt.Errorf("static_dir %q no es un directorio accesible", c.StaticDir))
+		}
+	}
+	if c.TLS.Enabled {
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			errs = append(errs, errors.New("tls.cert_file y tls.key_file deben indicarse juntos"))
+		}
+		if c.TLS.CertFile == "" && c.TLS.CertDir == "" {
+			errs = append(errs, errors.New("tls.cert_dir no puede estar vacío sin tls.cert_file"))
+		}
+	}
+	for i, s := range c.ICEServers {
+		if len(s.URLs) == 0 {
+			errs = append(errs, fmt.Errorf("ice_servers[%d]: sin URLs", i))
+		}
+		for _, u := range s.URLs {
+			if !strings.HasPrefix(u, "stun:") && !strings.HasPrefix(u, "stuns:") &&
+				!strings.HasPrefix(u, "turn:") && !strings.HasPrefix(u, "turns:") {
+				errs = append(errs, fmt.Errorf("ice_servers[%d]: URL %q debe empezar por stun:, stuns:, turn: o turns:", i, u))
+			}
+			if (strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:")) && (s.Username == "" || s.Credential == "") {
+				errs = append(errs, fmt.Errorf("ice_servers[%d]: %q requiere username y credential", i, u))
+			}
+		}
+	}
+	if (c.UDPPorts.Min == 0) != (c.UDPPorts.Max == 0) || c.UDPPorts.Min > c.UDPPorts.Max {
+		errs = append(errs, fmt.Errorf("udp_ports %d-%d inválido: indica min y max con min <= max", c.UDPPorts.Min, c.UDPPorts.Max))
+	}
+	for name, port := range map[string]int{"ice_mux.udp_port": c.ICEMux.UDPPort, "ice_mux.tcp_port": c.ICEMux.TCPPort} {
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s %d fuera de rango", name, port))
+		}
+	}
+	for _, ip := range c.ICEMux.NAT1To1IPs {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("ice_mux.nat_1to1_ips: %q no es una IP válida", ip))
+		}
+	}
+	if t := c.ICEMux.NAT1To1CandidateType; t != "host" && t != "srflx" {
+		errs = append(errs, fmt.Errorf("ice_mux.nat_1to1_candidate_type %q inválido (host o srflx)", t))
+	}
+	for _, pattern := range append(append([]string{}, c.ICEMux.Interfaces...), c.ICEMux.ExcludeInterfaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("patrón de interfaz %q inválido: %w", pattern, err))
+		}
+	}
+	for _, cidr := range c.ICEMux.ExcludeCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("ice_mux.exclude_cidrs: %w", err))
+		}
+	}
+	for _, codec := range c.Media.CodecPreference {
+		switch strings.ToLower(codec) {
+		case "vp8", "h264", "vp9", "av1":
+		default:
+			errs = append(errs, fmt.Errorf("media.codec_preference: códec %q no soportado (vp8, h264, vp9, av1)", codec))
+		}
+	}
+	if c.Media.MaxBitrateKbps < 0 {
+		errs = append(errs, errors.New("media.max_bitrate_kbps no puede ser negativo"))
+	}
+	if c.Jitter.Latency < 0 || c.Jitter.Latency > 5*time.Second {
+		errs = append(errs, fmt.Errorf("jitter.latency %v fuera de rango (0-5s)", c.Jitter.Latency))
+	}
+	if c.Jitter.NACKInterval <= 0 {
+		errs = append(errs, errors.New("jitter.nack_interval debe ser positivo"))
+	}
+	if n := c.Jitter.NACKBufferSize; n < 64 || n > 32768 || n&(n-1) != 0 {
+		errs = append(errs, fmt.Errorf("jitter.nack_buffer_size %d inválido (potencia de 2 entre 64 y 32768)", n))
+	}
+	if c.Congestion.REMB {
+		if c.Congestion.Interval <= 0 {
+			errs = append(errs, errors.New("congestion.interval debe ser positivo"))
+		}
+		if c.Congestion.MinKbps <= 0 || c.Congestion.MaxKbps < c.Congestion.MinKbps {
+			errs = append(errs, fmt.Errorf("congestion.min_kbps (%d) debe ser positivo y no mayor que congestion.max_kbps (%d)", c.Congestion.MinKbps, c.Congestion.MaxKbps))
+		}
+		if c.Congestion.LagQueue <= 0 || c.Congestion.LagQueue > 1 {
+			errs = append(errs, fmt.Errorf("congestion.lag_queue %v inválido (entre 0 y 1)", c.Congestion.LagQueue))
+		}
+	}
+	switch c.Transcoder.Backend {
+	case "ffmpeg":
+		if c.FFmpeg.Binary == "" {
+			errs = append(errs, errors.New("ffmpeg.binary no puede estar vacío"))
+		}
+	case "gstreamer":
+		if c.GStreamer.Binary == "" || c.GStreamer.Encoder == "" {
+			errs = append(errs, errors.New("gstreamer.binary y gstreamer.encoder no pueden estar vacíos"))
+		}
+	case "fake":
+	default:
+		errs = append(errs, fmt.Errorf("transcoder.backend %q no soportado (ffmpeg, gstreamer o fake)", c.Transcoder.Backend))
+	}
+	if c.Transcoder.RestartBackoff <= 0 || c.Transcoder.MaxRestartBackoff < c.Transcoder.RestartBackoff {
+		errs = append(errs, fmt.Errorf("transcoder.restart_backoff (%v) debe ser positivo y no mayor que transcoder.max_restart_backoff (%v)", c.Transcoder.RestartBackoff, c.Transcoder.MaxRestartBackoff))
+	}
+	if c.Transcoder.StallTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("transcoder.stall_timeout %v demasiado corto (mínimo 1s)", c.Transcoder.StallTimeout))
+	}
+	if c.Transcoder.StderrLines < 0 {
+		errs = append(errs, errors.New("transcoder.stderr_lines no puede ser negativo"))
+	}
+	switch c.Tunnel.Provider {
+	case "none":
+	case "ngrok":
+		if c.Tunnel.Ngrok.APIURL == "" {
+			errs = append(errs, errors.New("tunnel.ngrok.api_url no puede estar vacío con ngrok"))
+		}
+	case "cloudflared":
+		if c.Tunnel.Cloudflared.Binary == "" {
+			errs = append(errs, errors.New("tunnel.cloudflared.binary no puede estar vacío"))
+		}
+		if c.Tunnel.Cloudflared.Token != "" && c.Tunnel.Cloudflared.Hostname == "" {
+			errs = append(errs, errors.New("tunnel.cloudflared.hostname es obligatorio con token"))
+		}
+		if _, _, err := net.SplitHostPort(c.Tunnel.Cloudflared.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel.cloudflared.metrics_addr %q inválida: %w", c.Tunnel.Cloudflared.MetricsAddr, err))
+		}
+	case "static":
+		if u, err := url.Parse(c.Tunnel.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("tunnel.public_url %q debe ser una URL http(s) absoluta", c.Tunnel.PublicURL))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tunnel.provider %q no soportado (none, ngrok, cloudflared o static)", c.Tunnel.Provider))
+	}
+	if c.Tunnel.Provider != "none" {
+		if c.Tunnel.Retries < 1 {
+			errs = append(errs, fmt.Errorf("tunnel.retries debe ser >= 1 (actual %d)", c.Tunnel.Retries))
+		}
+		if c.Tunnel.RetryDelay <= 0 || c.Tunnel.HealthInterval <= 0 {
+			errs = append(errs, errors.New("tunnel.retry_delay y tunnel.health_interval deben ser positivos"))
+		}
+		if c.Tunnel.RestartBackoff <= 0 || c.Tunnel.MaxRestartBackoff < c.Tunnel.RestartBackoff {
+			errs = append(errs, fmt.Errorf("tunnel.restart_backoff (%v) debe ser positivo y no mayor que tunnel.max_restart_backoff (%v)", c.Tunnel.RestartBackoff, c.Tunnel.MaxRestartBackoff))
+		}
+	}
+	if c.TURN.Enabled {
+		if _, _, err := net.SplitHostPort(c.TURN.ListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("turn.listen_addr %q inválida: %w", c.TURN.ListenAddr, err))
+		}
+		if ip := net.ParseIP(c.TURN.PublicIP); ip == nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("turn.public_ip %q debe ser una IPv4 válida", c.TURN.PublicIP))
+		}
+		if c.TURN.Realm == "" {
+			errs = append(errs, errors.New("turn.realm no puede estar vacío"))
+		}
+		if c.TURN.CredentialTTL < time.Minute {
+			errs = append(errs, fmt.Errorf("turn.credential_ttl %v demasiado corto (mínimo 1m)", c.TURN.CredentialTTL))
+		}
+		if r := c.TURN.RelayPorts; (r.Min == 0) != (r.Max == 0) || r.Min > r.Max {
+			errs = append(errs, fmt.Errorf("turn.relay_ports %d-%d inválido", r.Min, r.Max))
+		}
+	}
+	if c.Share.TTL <= 0 || c.Share.MaxTTL < c.Share.TTL {
+		errs = append(errs, fmt.Errorf("share.ttl (%v) debe ser positivo y no mayor que share.max_ttl (%v)", c.Share.TTL, c.Share.MaxTTL))
+	}
+	if c.Chat.History < 0 || c.Chat.MaxLength <= 0 {
+		errs = append(errs, errors.New("chat.history no puede ser negativo y chat.max_length debe ser positivo"))
+	}
+	if c.Chat.Rate <= 0 || c.Chat.Burst < 1 {
+		errs = append(errs, errors.New("chat.rate debe ser positivo y chat.burst al menos 1"))
+	}
+	for _, cmd := range c.Control.ViewerCommands {
+		if !slices.Contains(ControlCommands, cmd) {
+			errs = append(errs, fmt.Errorf("control.viewer_commands: orden %q no soportada (%s)", cmd, strings.Join(ControlCommands, ", ")))
+		}
+	}
+	if c.Control.Timeout <= 0 {
+		errs = append(errs, errors.New("control.timeout debe ser positivo"))
+	}
+	if c.Edge.RetryBackoff <= 0 || c.Edge.MaxRetryBackoff < c.Edge.RetryBackoff {
+		errs = append(errs, fmt.Errorf("edge.retry_backoff (%v) debe ser positivo y no mayor que edge.max_retry_backoff (%v)", c.Edge.RetryBackoff, c.Edge.MaxRetryBackoff))
+	}
+	if c.Edge.IdleTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("edge.idle_timeout %v demasiado corto (mínimo 1s)", c.Edge.IdleTimeout))
+	}
+	if c.Edge.CAFile != "" {
+		if _, err := os.Stat(c.Edge.CAFile); err != nil {
+			errs = append(errs, fmt.Errorf("edge.ca_file: %w", err))
+		}
+	}
+	edgeCodes := make(map[string]bool)
+	for i, ch := range c.Edge.Channels {
+		if ch.Code == "" {
+			errs = append(errs, fmt.Errorf("edge.channels[%d]: code no puede estar vacío", i))
+		} else if edgeCodes[ch.Code] {
+			errs = append(errs, fmt.Errorf("edge.channels[%d]: canal %q repetido", i, ch.Code))
+		}
+		edgeCodes[ch.Code] = true
+		if err := ValidateOrigin(ch.Origin); err != nil {
+			errs = append(errs, fmt.Errorf("edge.channels[%d]: %w", i, err))
+		}
+	}
+	for i, origin := range c.Edge.AllowedOrigins {
+		if err := ValidateOrigin(origin); err != nil {
+			errs = append(errs, fmt.Errorf("edge.allowed_origins[%d]: %w", i, err))
+		}
+	}
+	if c.DVR.Enabled {
+		if c.DVR.Window < 10*time.Second {
+			errs = append(errs, fmt.Errorf("dvr.window %v demasiado corto (mínimo 10s)", c.DVR.Window))
+		}
+		if c.DVR.MemoryMB < 1 {
+			errs = append(errs, errors.New("dvr.memory_mb debe ser al menos 1"))
+		}
+		if c.Clips.Dir == "" {
+			errs = append(errs, errors.New("clips.dir no puede estar vacío"))
+		}
+		if c.Clips.Retention < time.Minute {
+			errs = append(errs, fmt.Errorf("clips.retention %v demasiado corto (mínimo 1m)", c.Clips.Retention))
+		}
+		if c.Clips.MaxDuration <= 0 || c.Clips.MaxJobs < 1 {
+			errs = append(errs, errors.New("clips.max_duration debe ser positivo y clips.max_jobs al menos 1"))
+		}
+		if c.Clips.MaxQueued < 0 {
+			errs = append(errs, errors.New("clips.max_queued no puede ser negativo"))
+		}
+	}
+	if c.Motion.Enabled {
+		if c.Motion.FPS <= 0 || c.Motion.FPS > 30 {
+			errs = append(errs, fmt.Errorf("motion.fps %v fuera de rango (0 a 30)", c.Motion.FPS))
+		}
+		if c.Motion.Sensitivity < 1 || c.Motion.Sensitivity > 100 {
+			errs = append(errs, fmt.Errorf("motion.sensitivity %d fuera de rango (1 a 100)", c.Motion.Sensitivity))
+		}
+		if c.Motion.Cooldown < time.Second {
+			errs = append(errs, fmt.Errorf("motion.cooldown %v demasiado corto (mínimo 1s)", c.Motion.Cooldown))
+		}
+		if c.Motion.History < 1 {
+			errs = append(errs, errors.New("motion.history debe ser al menos 1"))
+		}
+		for i, z := range c.Motion.Zones {
+			if err := z.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("motion.zones[%d]: %w", i, err))
+			}
+		}
+		for i, hook := range c.Motion.Webhooks {
+			if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("motion.webhooks[%d]: URL %q no válida", i, hook))
+			}
+		}
+		if len(c.Motion.Webhooks) > 0 && c.Motion.WebhookTimeout <= 0 {
+			errs = append(errs, errors.New("motion.webhook_timeout debe ser positivo"))
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout debe ser positivo"))
+	}
+	if c.Limits.MaxChannels < 0 {
+		errs = append(errs, errors.New("limits.max_channels no puede ser negativo"))
+	}
+	if c.Limits.MaxViewersPerChannel < 0 {
+		errs = append(errs, errors.New("limits.max_viewers_per_channel no puede ser negativo"))
+	}
+	if c.Limits.MaxPublishersPerChannel < 0 || c.Limits.MaxPipelines < 0 {
+		errs = append(errs, errors.New("limits.max_publishers_per_channel y limits.max_pipelines no pueden ser negativos"))
+	}
+	for name, l := range map[string]RateLimit{"limits.per_ip": c.Limits.PerIP, "limits.global": c.Limits.Global} {
+		if l.Rate < 0 || (l.Rate > 0 && l.Burst < 1) {
+			errs = append(errs, fmt.Errorf("%s: rate no puede ser negativo y burst debe ser al menos 1 si hay rate", name))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuración inválida:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ValidateOrigin comprueba que la URL de un origen edge es http(s) absoluta
+func ValidateOrigin(origin string) error {
+	if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("origen %q debe ser una URL http(s) absoluta", origin)
+	}
+	return nil
+}
+
+// Port devuelve el puerto numérico de ListenAddr (0 si no se puede determinar).
+func (c Config) Port() int {
+	_, p, err := net.SplitHostPort(c.ListenAddr)
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(p)
+	return port
+}
+
+// Path devuelve la ruta del fichero de configuración cargado, si lo hay.
+func (c Config) Path() string {
+	return c.configPath
+}
+
+// splitList separa una lista por comas descartando elementos vacíos.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parseICEURLs convierte una lista separada por comas en un único ICEServer sin credenciales.
+func parseICEURLs(s string) []ICEServer {
+	urls := splitList(s)
+	if len(urls) == 0 {
+		return nil
+	}
+	return []ICEServer{{URLs: urls}}
+}
+
+// parsePortRange interpreta un rango "min-max".
+func parsePortRange(s string) (PortRange, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("rango %q inválido, se espera min-max", s)
+	}
+	min, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("puerto mínimo %q inválido", lo)
+	}
+	max, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("puerto máximo %q inválido", hi)
 	}
+	return PortRange{Min: uint16(min), Max: uint16(max)}, nil
 }
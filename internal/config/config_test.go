@@ -0,0 +1,57 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(c *Config)
+		wantErr string // vacío = configuración válida
+	}{
+		{"por defecto", func(c *Config) {}, ""},
+		{"listen_addr sin puerto", func(c *Config) { c.ListenAddr = "localhost" }, "listen_addr"},
+		{"formato de log", func(c *Config) { c.Log.Format = "xml" }, "log.format"},
+		{"nivel de componente", func(c *Config) { c.Log.Components = map[string]string{"rtcp": "ruido"} }, "log.components.rtcp"},
+		{"turn sin credenciales", func(c *Config) {
+			c.ICEServers = []ICEServer{{URLs: []string{"turn:turn.example.com:3478"}}}
+		}, "requiere username y credential"},
+		{"esquema ICE", func(c *Config) { c.ICEServers = []ICEServer{{URLs: []string{"http://x"}}} }, "debe empezar por stun:"},
+		{"udp_ports incompleto", func(c *Config) { c.UDPPorts = PortRange{Min: 50000} }, "udp_ports"},
+		{"nat_1to1_ips", func(c *Config) { c.ICEMux.NAT1To1IPs = []string{"no-es-ip"} }, "nat_1to1_ips"},
+		{"exclude_cidrs", func(c *Config) { c.ICEMux.ExcludeCIDRs = []string{"172.16.0.0/33"} }, "exclude_cidrs"},
+		{"códec desconocido", func(c *Config) { c.Media.CodecPreference = []string{"theora"} }, "media.codec_preference"},
+		{"nack_buffer_size no potencia de 2", func(c *Config) { c.Jitter.NACKBufferSize = 500 }, "jitter.nack_buffer_size"},
+		{"jitter demasiado largo", func(c *Config) { c.Jitter.Latency = 6 * time.Second }, "jitter.latency"},
+		{"backend fake", func(c *Config) { c.Transcoder.Backend = "fake" }, ""},
+		{"backend desconocido", func(c *Config) { c.Transcoder.Backend = "vlc" }, "transcoder.backend"},
+		{"stall_timeout corto", func(c *Config) { c.Transcoder.StallTimeout = 500 * time.Millisecond }, "transcoder.stall_timeout"},
+		{"túnel static sin URL", func(c *Config) { c.Tunnel.Provider = "static" }, "tunnel.public_url"},
+		{"turn sin IP pública", func(c *Config) { c.TURN.Enabled = true; c.TURN.Secret = "s" }, "turn.public_ip"},
+		{"origen de edge", func(c *Config) {
+			c.Edge.Channels = []EdgeChannel{{Code: "OFI2", Origin: "ftp://origen"}}
+		}, "edge.channels[0]"},
+		{"edge repetido", func(c *Config) {
+			c.Edge.Channels = []EdgeChannel{{Code: "A", Origin: "https://o:8080"}, {Code: "A", Origin: "https://o:8080"}}
+		}, "repetido"},
+		{"allowed_origins", func(c *Config) { c.Edge.AllowedOrigins = []string{"origen:8080"} }, "edge.allowed_origins[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.mutate(&c)
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, se esperaba nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, se esperaba un error con %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Validate() = %v, se esperaba un error con %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package logging configura el logger estructurado (log/slog) del servidor:
+// formato text o JSON, niveles por componente, rotación del fichero de log y
+// copia opcional en stderr. Los log.Printf que queden (dependencias, paquetes
+// antiguos) se redirigen al mismo handler a nivel info.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// Campos comunes de los registros, para que todos los componentes usen las mismas claves
+const (
+	KeyComponent = "component"
+	KeyChannel   = "channel"
+	KeyStreamID  = "stream_id"
+	KeyClientID  = "client_id"
+)
+
+// Setup instala el logger por defecto según la configuración y devuelve el
+// fichero de log (para redirigir ahí la salida de procesos externos), que debe
+// cerrarse al terminar. Con debug activado el nivel por defecto baja a debug.
+func Setup(c config.Config) (io.WriteCloser, error) {
+	file, err := newRotatingFile(c.LogPath, c.Log)
+	if err != nil {
+		return nil, err
+	}
+	var out io.Writer = file
+	if c.Log.Stderr {
+		out = io.MultiWriter(file, os.Stderr)
+	}
+
+	level := parseLevel(c.Log.Level)
+	if c.Debug && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	levels := make(map[string]slog.Level, len(c.Log.Components))
+	minLevel := level
+	for component, l := range c.Log.Components {
+		levels[strings.ToLower(component)] = parseLevel(l)
+		minLevel = min(minLevel, levels[strings.ToLower(component)])
+	}
+
+	// El handler interno deja pasar todo lo que algún componente acepta; el
+	// filtrado fino lo hace componentHandler
+	opts := &slog.HandlerOptions{Level: minLevel, AddSource: c.Log.Format == "json" || c.Debug}
+	var inner slog.Handler
+	if c.Log.Format == "json" {
+		inner = slog.NewJSONHandler(out, opts)
+	} else {
+		inner = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(&componentHandler{inner: inner, def: level, levels: levels, level: level, minLevel: minLevel}))
+	return file, nil
+}
+
+// For devuelve el logger de un componente
+func For(component string) *slog.Logger {
+	return slog.Default().With(KeyComponent, component)
+}
+
+// parseLevel interpreta un nivel ya validado por config (info si está vacío)
+func parseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// componentHandler aplica el nivel del componente fijado con With(component, ...);
+// los registros sin componente usan el nivel por defecto
+type componentHandler struct {
+	inner    slog.Handler
+	def      slog.Level
+	levels   map[string]slog.Level
+	level    slog.Level // nivel efectivo de este handler
+	bound    bool       // ya se fijó el componente con WithAttrs
+	minLevel slog.Level
+}
+
+func (h *componentHandler) Enabled(_ context.Context, l slog.Level) bool {
+	if h.bound {
+		return l >= h.level
+	}
+	// El componente puede venir como atributo del propio registro
+	return l >= h.minLevel
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.bound {
+		level := h.def
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == KeyComponent {
+				level = h.componentLevel(a.Value.String())
+				return false
+			}
+			return true
+		})
+		if r.Level < level {
+			return nil
+		}
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.inner = h.inner.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == KeyComponent {
+			next.level = h.componentLevel(a.Value.String())
+			next.bound = true
+		}
+	}
+	return &next
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.inner = h.inner.WithGroup(name)
+	return &next
+}
+
+// componentLevel devuelve el nivel configurado del componente o el de por defecto
+func (h *componentHandler) componentLevel(component string) slog.Level {
+	if l, ok := h.levels[strings.ToLower(component)]; ok {
+		return l
+	}
+	return h.def
+}
@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// rotatingFile es el fichero de log con rotación por tamaño y por tiempo. Los
+// ficheros rotados se renombran como <nombre>-<fecha>.<ext> junto al actual y
+// se borran los que exceden MaxBackups o son más antiguos que MaxAge.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	cfg      config.LogConfig
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// newRotatingFile abre (en modo append) el fichero de log
+func newRotatingFile(path string, cfg config.LogConfig) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, cfg: cfg}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+// open abre el fichero actual y toma su tamaño y fecha de creación aproximada
+func (r *rotatingFile) open() error {
+	if dir := filepath.Dir(r.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("no se pudo abrir el fichero de log: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = st.Size()
+	r.openedAt = time.Now()
+	if r.size > 0 {
+		r.openedAt = st.ModTime()
+	}
+	return nil
+}
+
+// Write escribe un registro, rotando antes si toca
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// Seguir escribiendo en el fichero actual antes que perder logs
+			fmt.Fprintf(os.Stderr, "[Log] Error rotando %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// shouldRotate indica si el siguiente registro supera el tamaño máximo o si el
+// fichero ha superado el intervalo de rotación
+func (r *rotatingFile) shouldRotate(next int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if max := int64(r.cfg.MaxSizeMB) << 20; max > 0 && r.size+next > max {
+		return true
+	}
+	return r.cfg.RotateEvery > 0 && time.Since(r.openedAt) >= r.cfg.RotateEvery
+}
+
+// rotate renombra el fichero actual, abre uno nuevo y aplica la retención
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	if err := os.Rename(r.path, r.backupName(time.Now())); err != nil {
+		// Reabrir el actual para no quedarse sin log
+		if oerr := r.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	go r.prune()
+	return nil
+}
+
+// backupName devuelve el nombre del fichero rotado, p. ej. webrtc_server-20250909T184610.log
+func (r *rotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-" + t.Format("20060102T150405.000") + ext
+}
+
+// prune borra los ficheros rotados que exceden MaxBackups o MaxAge
+func (r *rotatingFile) prune() {
+	ext := filepath.Ext(r.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+	// El sufijo de fecha ordena cronológicamente: los más recientes al final
+	sort.Strings(matches)
+	for i, name := range matches {
+		old := r.cfg.MaxBackups > 0 && i < len(matches)-r.cfg.MaxBackups
+		if !old && r.cfg.MaxAge > 0 {
+			if st, err := os.Stat(name); err == nil && time.Since(st.ModTime()) > r.cfg.MaxAge {
+				old = true
+			}
+		}
+		if old {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+// Sync vuelca el fichero actual a disco
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close cierra el fichero; las escrituras posteriores fallan con os.ErrClosed
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,315 @@
+// Package tlscert prepara la configuración TLS del servidor HTTP, ya sea a
+// partir de un certificado proporcionado o de una CA autofirmada propia que se
+// guarda en disco y emite un certificado de servidor para las IPs de la LAN.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+const (
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	serverCertFile = "server.pem"
+	serverKeyFile  = "server-key.pem"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// iOS rechaza certificados de servidor con más de 825 días aunque la CA sea de confianza
+	serverValidity = 397 * 24 * time.Hour
+	// renewBefore regenera el certificado de servidor cuando le queda menos de
+	// esto; a la CA no se la regenera nunca sola, sólo se avisa
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// privateRanges son las redes que la CA puede certificar además de las IPs
+// propias del equipo: loopback y los rangos privados de IPv4 e IPv6
+var privateRanges = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+// Certificates es el resultado de Load
+type Certificates struct {
+	// TLSConfig se asigna a http.Server.TLSConfig
+	TLSConfig *tls.Config
+	// CACert es el certificado DER de la CA autofirmada (nil con certificados proporcionados)
+	CACert []byte
+}
+
+// Load devuelve la configuración TLS. Con cert_file/key_file usa ese par; si no,
+// crea (o reutiliza) la CA en cert_dir y un certificado de servidor que cubre
+// localhost, las IPs de las interfaces locales y los hosts adicionales.
+func Load(c config.TLSConfig) (*Certificates, error) {
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cargando el certificado TLS: %w", err)
+		}
+		return &Certificates{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}}, nil
+	}
+	if err := os.MkdirAll(c.CertDir, 0o700); err != nil {
+		return nil, err
+	}
+	hosts, ips := serverNames(c.Hosts)
+	caCert, caKey, err := loadOrCreateCA(c.CertDir, hosts, ips)
+	if err != nil {
+		return nil, fmt.Errorf("preparando la CA autofirmada: %w", err)
+	}
+	cert, err := loadOrCreateServerCert(c.CertDir, caCert, caKey, hosts, ips)
+	if err != nil {
+		return nil, fmt.Errorf("preparando el certificado de servidor: %w", err)
+	}
+	return &Certificates{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		CACert:    caCert.Raw,
+	}, nil
+}
+
+// serverNames reúne los nombres DNS e IPs que debe cubrir el certificado de servidor
+func serverNames(extra []string) ([]string, []net.IP) {
+	hosts := []string{"localhost"}
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	for _, h := range extra {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, ips
+}
+
+// loadOrCreateCA reutiliza la CA de dir o crea una limitada (name
+// constraints) a localhost, .local, los rangos privados y los nombres e IPs
+// actuales del servidor, para que instalarla no permita suplantar otros sitios.
+// Una CA guardada no se sustituye nunca: si caduca pronto o no cubre algún
+// nombre sólo se avisa, y si ya ha caducado el operador debe borrarla.
+func loadOrCreateCA(dir string, hosts []string, ips []net.IP) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	cert, key, err := readPair(certPath, keyPath)
+	if err == nil {
+		log := logging.For("tls").With("path", certPath, "expires", cert.NotAfter.Format(time.DateOnly))
+		if time.Now().After(cert.NotAfter) {
+			return nil, nil, fmt.Errorf("la CA %s caducó el %s: borra %s y %s para crear otra y vuelve a instalarla en los dispositivos", certPath, cert.NotAfter.Format(time.DateOnly), caCertFile, caKeyFile)
+		}
+		if left := time.Until(cert.NotAfter); left < renewBefore {
+			log.Error("La CA autofirmada caduca pronto y no se renueva sola: borra sus ficheros para crear otra y vuelve a instalarla en los dispositivos", "days_left", int(left.Hours()/24))
+		}
+		if len(cert.PermittedDNSDomains) == 0 && len(cert.PermittedIPRanges) == 0 {
+			log.Warn("La CA autofirmada no tiene restricciones de nombre y podría certificar cualquier sitio; bórrala para crear una limitada a la red local")
+		} else if names := uncoveredNames(cert, hosts, ips); len(names) > 0 {
+			log.Warn("La CA autofirmada no puede certificar estos nombres; bórrala para crear otra que los incluya", "names", names)
+		}
+		return cert, key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	dnsDomains, ipRanges := caConstraints(hosts, ips)
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:                randomSerial(),
+		Subject:                     pkix.Name{Organization: []string{"go-pion-stream"}, CommonName: "go-pion-stream CA " + hostname},
+		NotBefore:                   time.Now().Add(-time.Hour),
+		NotAfter:                    time.Now().Add(caValidity),
+		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLenZero:              true,
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         dnsDomains,
+		PermittedIPRanges:           ipRanges,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return nil, nil, err
+	}
+	logging.For("tls").Info("CA autofirmada creada", "path", certPath, "dns", dnsDomains, "ip_ranges", len(ipRanges))
+	cert, err = x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// caConstraints devuelve los dominios y rangos de IP que puede certificar la
+// CA: localhost, .local y los rangos privados, más los nombres e IPs del
+// servidor que queden fuera de ellos
+func caConstraints(hosts []string, ips []net.IP) ([]string, []*net.IPNet) {
+	domains := []string{"localhost", "local"}
+	for _, h := range hosts {
+		if !dnsPermitted(domains, h) {
+			domains = append(domains, h)
+		}
+	}
+	var ranges []*net.IPNet
+	for _, cidr := range privateRanges {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, ipNet)
+	}
+	for _, ip := range ips {
+		if !ipPermitted(ranges, ip) {
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return domains, ranges
+}
+
+// uncoveredNames devuelve los nombres e IPs que las restricciones de la CA no permiten
+func uncoveredNames(caCert *x509.Certificate, hosts []string, ips []net.IP) []string {
+	var names []string
+	for _, h := range hosts {
+		if !dnsPermitted(caCert.PermittedDNSDomains, h) {
+			names = append(names, h)
+		}
+	}
+	for _, ip := range ips {
+		if !ipPermitted(caCert.PermittedIPRanges, ip) {
+			names = append(names, ip.String())
+		}
+	}
+	return names
+}
+
+// dnsPermitted aplica la regla de RFC 5280: un dominio permite ese nombre y sus subdominios
+func dnsPermitted(domains []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if strings.HasPrefix(d, ".") {
+			if strings.HasSuffix(host, d) {
+				return true
+			}
+		} else if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipPermitted(ranges []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(ranges, func(r *net.IPNet) bool { return r.Contains(ip) })
+}
+
+func loadOrCreateServerCert(dir string, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, ips []net.IP) (tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(dir, serverCertFile), filepath.Join(dir, serverKeyFile)
+	if cert, _, err := readPair(certPath, keyPath); err == nil && serverCertValid(cert, caCert, hosts, ips) {
+		return tls.LoadX509KeyPair(certPath, keyPath)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{Organization: []string{"go-pion-stream"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     hosts,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	logging.For("tls").Info("Certificado de servidor emitido", "hosts", hosts, "ips", ips)
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// serverCertValid comprueba que el certificado guardado lo firmó la CA actual,
+// no está a punto de caducar y cubre todos los nombres e IPs actuales
+func serverCertValid(cert, caCert *x509.Certificate, hosts []string, ips []net.IP) bool {
+	if cert.CheckSignatureFrom(caCert) != nil || time.Until(cert.NotAfter) < renewBefore {
+		return false
+	}
+	for _, h := range hosts {
+		if !slices.Contains(cert.DNSNames, h) {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+func readPair(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s o %s no contienen PEM válido", certPath, keyPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writePair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
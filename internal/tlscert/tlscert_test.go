@@ -0,0 +1,130 @@
+package tlscert
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// La CA creada sólo certifica la red local y los nombres del servidor
+func TestCANameConstraints(t *testing.T) {
+	c := config.TLSConfig{CertDir: t.TempDir(), Hosts: []string{"camara.example.org", "203.0.113.7"}}
+	certs, err := Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(certs.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	leaf, err := x509.ParseCertificate(certs.TLSConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"localhost", "camara.example.org", "203.0.113.7", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: name}); err != nil {
+			t.Errorf("el certificado de servidor no vale para %s: %v", name, err)
+		}
+	}
+
+	// Un certificado para otro sitio firmado con la clave de la CA no es válido
+	caKey := readKey(t, filepath.Join(c.CertDir, caKeyFile))
+	forged := issue(t, ca, caKey, &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "banco.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"banco.example.com"},
+	})
+	if _, err := forged.Verify(x509.VerifyOptions{Roots: roots, DNSName: "banco.example.com"}); err == nil {
+		t.Error("la CA certifica un dominio ajeno")
+	}
+}
+
+// Una CA a punto de caducar se conserva y una caducada exige que el operador la borre
+func TestCAIsNeverReplaced(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		expires time.Duration
+		wantErr bool
+	}{
+		{"caduca pronto", 10 * 24 * time.Hour, false},
+		{"caducada", -time.Hour, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := &x509.Certificate{
+				SerialNumber:          randomSerial(),
+				Subject:               pkix.Name{CommonName: "CA de prueba"},
+				NotBefore:             time.Now().Add(-48 * time.Hour),
+				NotAfter:              time.Now().Add(tt.expires),
+				KeyUsage:              x509.KeyUsageCertSign,
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certPath := filepath.Join(dir, caCertFile)
+			if err := writePair(certPath, filepath.Join(dir, caKeyFile), der, key); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(certPath)
+
+			_, err = Load(config.TLSConfig{CertDir: dir})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Load = %v, se esperaba error: %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "caducó") {
+				t.Errorf("error sin explicar la caducidad: %v", err)
+			}
+			if after, _ := os.ReadFile(certPath); !bytes.Equal(before, after) {
+				t.Error("se sustituyó la CA guardada")
+			}
+		})
+	}
+}
+
+func readKey(t *testing.T, path string) *ecdsa.PrivateKey {
+	t.Helper()
+	_, key, err := readPair(filepath.Join(filepath.Dir(path), caCertFile), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func issue(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,173 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// ClipFormats son los formatos de exportación de clips: avi (MJPEG, sin
+// recodificar) y mp4 (H.264) o webm (VP8) recodificados con ffmpeg
+var ClipFormats = []string{"avi", "mp4", "webm"}
+
+// EncodeClip recodifica una secuencia de JPEGs concatenados leída de input a
+// un fichero mp4 o webm con ffmpeg, a fps frames por segundo
+func EncodeClip(ctx context.Context, c config.FFmpegConfig, format string, fps float64, input io.Reader, output string) error {
+	args := []string{
+		"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "mjpeg", "-framerate", strconv.FormatFloat(fps, 'f', 3, 64),
+		"-i", "pipe:0",
+		// yuv420p necesita dimensiones pares
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-pix_fmt", "yuv420p",
+	}
+	switch format {
+	case "mp4":
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-movflags", "+faststart", "-f", "mp4")
+	case "webm":
+		args = append(args, "-c:v", "libvpx", "-b:v", "2M", "-deadline", "realtime", "-f", "webm")
+	default:
+		return fmt.Errorf("formato de clip %q no soportado por ffmpeg", format)
+	}
+	args = append(args, output)
+	cmd := exec.CommandContext(ctx, c.Binary, args...)
+	cmd.Stdin = input
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+		}
+		return err
+	}
+	return nil
+}
+
+// aviHeaderSize es lo que ocupan las cabeceras RIFF, hdrl y LIST movi hasta el primer frame
+const aviHeaderSize = 224
+
+// AVIWriter escribe un AVI con un único stream de vídeo MJPEG. Los JPEG se
+// guardan tal cual; las cabeceras se reescriben en Close con el número de
+// frames y la velocidad media.
+type AVIWriter struct {
+	w             io.WriteSeeker
+	width, height int
+	pos           int64 // bytes escritos desde el inicio del fichero
+	maxFrame      int
+	index         []aviIndexEntry
+}
+
+type aviIndexEntry struct {
+	offset uint32 // desde el fourcc "movi"
+	size   uint32
+}
+
+// NewAVIWriter reserva las cabeceras de un AVI de width x height en w
+func NewAVIWriter(w io.WriteSeeker, width, height int) (*AVIWriter, error) {
+	a := &AVIWriter{w: w, width: width, height: height}
+	if _, err := w.Write(a.header(0)); err != nil {
+		return nil, err
+	}
+	a.pos = aviHeaderSize
+	return a, nil
+}
+
+// WriteFrame añade un JPEG como frame clave
+func (a *AVIWriter) WriteFrame(jpeg []byte) error {
+	if a.pos+int64(len(jpeg)) > math.MaxUint32-1<<20 {
+		return errors.New("el AVI supera los 4 GB")
+	}
+	chunk := make([]byte, 8, 8+len(jpeg)+1)
+	copy(chunk, "00dc")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(jpeg)))
+	chunk = append(chunk, jpeg...)
+	if len(jpeg)%2 == 1 {
+		chunk = append(chunk, 0) // los chunks RIFF se alinean a 2 bytes
+	}
+	if _, err := a.w.Write(chunk); err != nil {
+		return err
+	}
+	a.index = append(a.index, aviIndexEntry{offset: uint32(a.pos - (aviHeaderSize - 4)), size: uint32(len(jpeg))})
+	a.pos += int64(len(chunk))
+	a.maxFrame = max(a.maxFrame, len(jpeg))
+	return nil
+}
+
+// Close escribe el índice idx1 y reescribe las cabeceras con fps frames por segundo
+func (a *AVIWriter) Close(fps float64) error {
+	idx := make([]byte, 8+16*len(a.index))
+	copy(idx, "idx1")
+	binary.LittleEndian.PutUint32(idx[4:], uint32(16*len(a.index)))
+	for i, e := range a.index {
+		entry := idx[8+16*i:]
+		copy(entry, "00dc")
+		binary.LittleEndian.PutUint32(entry[4:], 0x10) // AVIIF_KEYFRAME
+		binary.LittleEndian.PutUint32(entry[8:], e.offset)
+		binary.LittleEndian.PutUint32(entry[12:], e.size)
+	}
+	if _, err := a.w.Write(idx); err != nil {
+		return err
+	}
+	moviSize := a.pos - (aviHeaderSize - 12) - 8
+	a.pos += int64(len(idx))
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := a.header(fps)
+	binary.LittleEndian.PutUint32(h[4:], uint32(a.pos-8))
+	binary.LittleEndian.PutUint32(h[aviHeaderSize-8:], uint32(moviSize))
+	if _, err := a.w.Write(h); err != nil {
+		return err
+	}
+	_, err := a.w.Seek(a.pos, io.SeekStart)
+	return err
+}
+
+// header construye las cabeceras RIFF/AVI hasta el fourcc "movi" incluido
+func (a *AVIWriter) header(fps float64) []byte {
+	if fps <= 0 {
+		fps = 25
+	}
+	const scale = 1000
+	frames := uint32(len(a.index))
+	var b bytes.Buffer
+	put := func(vs ...any) {
+		for _, v := range vs {
+			if s, ok := v.(string); ok {
+				b.WriteString(s)
+				continue
+			}
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+	}
+	put("RIFF", uint32(0), "AVI ")
+	put("LIST", uint32(192), "hdrl")
+	// avih: MainAVIHeader
+	put("avih", uint32(56),
+		uint32(1e6/fps), uint32(float64(a.maxFrame)*fps), uint32(0), uint32(0x10), // AVIF_HASINDEX
+		frames, uint32(0), uint32(1), uint32(a.maxFrame),
+		uint32(a.width), uint32(a.height), [4]uint32{})
+	put("LIST", uint32(116), "strl")
+	// strh: AVIStreamHeader
+	put("strh", uint32(56),
+		"vids", "MJPG", uint32(0), uint16(0), uint16(0), uint32(0),
+		uint32(scale), uint32(math.Round(fps*scale)), uint32(0), frames,
+		uint32(a.maxFrame), int32(-1), uint32(0),
+		[4]int16{0, 0, int16(a.width), int16(a.height)})
+	// strf: BITMAPINFOHEADER
+	put("strf", uint32(40),
+		uint32(40), int32(a.width), int32(a.height), uint16(1), uint16(24), "MJPG",
+		uint32(a.width*a.height*3), int32(0), int32(0), uint32(0), uint32(0))
+	put("LIST", uint32(0), "movi")
+	return b.Bytes()
+}
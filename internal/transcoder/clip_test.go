@@ -0,0 +1,94 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAVIWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		fps    float64
+	}{
+		{"sin frames", nil, 10},
+		{"frames pares", [][]byte{{0xFF, 0xD8, 1, 0xFF, 0xD9, 0}, {0xFF, 0xD8, 0xFF, 0xD9}}, 25},
+		{"frame impar con relleno", [][]byte{{0xFF, 0xD8, 1, 0xFF, 0xD9}, {0xFF, 0xD8, 2, 3, 0xFF, 0xD9}, {0xFF, 0xD8, 4, 0xFF, 0xD9}}, 12.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clip.avi")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := NewAVIWriter(f, 320, 240)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, frame := range tt.frames {
+				if err := a.WriteFrame(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := a.Close(tt.fps); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u32 := func(off int) int { return int(binary.LittleEndian.Uint32(data[off:])) }
+
+			if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+				t.Fatalf("cabecera RIFF inválida: %q", data[:12])
+			}
+			if got := u32(4); got != len(data)-8 {
+				t.Errorf("tamaño RIFF %d, se esperaba %d", got, len(data)-8)
+			}
+			// avih: frames totales, ancho y alto
+			if string(data[24:28]) != "avih" {
+				t.Fatalf("no hay avih en el offset 24: %q", data[24:28])
+			}
+			if got := u32(48); got != len(tt.frames) {
+				t.Errorf("avih: %d frames, se esperaban %d", got, len(tt.frames))
+			}
+			if w, h := u32(64), u32(68); w != 320 || h != 240 {
+				t.Errorf("avih: %dx%d, se esperaba 320x240", w, h)
+			}
+			const movi = aviHeaderSize - 4 // fourcc "movi"
+			if string(data[movi:movi+4]) != "movi" {
+				t.Fatalf("no hay LIST movi antes del primer frame")
+			}
+			idx := bytes.LastIndex(data, []byte("idx1"))
+			if idx < 0 {
+				t.Fatal("no hay índice idx1")
+			}
+			if got := u32(movi - 4); got != idx-movi {
+				t.Errorf("tamaño de LIST movi %d, se esperaba %d", got, idx-movi)
+			}
+			if got := u32(idx + 4); got != 16*len(tt.frames) {
+				t.Fatalf("tamaño de idx1 %d, se esperaba %d", got, 16*len(tt.frames))
+			}
+			// Cada entrada del índice apunta a su chunk 00dc con el JPEG intacto
+			for i, frame := range tt.frames {
+				entry := idx + 8 + 16*i
+				if string(data[entry:entry+4]) != "00dc" || u32(entry+4) != 0x10 {
+					t.Errorf("entrada %d del índice inválida", i)
+				}
+				chunk := movi + u32(entry+8)
+				size := u32(entry + 12)
+				if string(data[chunk:chunk+4]) != "00dc" || u32(chunk+4) != len(frame) || size != len(frame) {
+					t.Fatalf("frame %d: chunk en %d mal indexado", i, chunk)
+				}
+				if !bytes.Equal(data[chunk+8:chunk+8+size], frame) {
+					t.Errorf("frame %d alterado", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// execTranscoder ejecuta un proceso externo que lee la entrada por stdin y
+// escribe JPEGs concatenados por stdout. ffmpeg y gst-launch lo comparten.
+type execTranscoder struct {
+	binary string
+	args   func(spec InputSpec) []string
+
+	cmd      *exec.Cmd
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stdout   io.Closer
+	stderr   io.Closer
+}
+
+func (t *execTranscoder) Start(spec InputSpec, onFrame func([]byte), onLog func(string)) error {
+	// El proceso hijo hereda la entrada; el padre cierra su copia
+	defer spec.Input.Close()
+	cmd := exec.Command(t.binary, t.args(spec)...)
+	cmd.Dir = "."
+	cmd.Stdin = spec.Input
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	t.cmd, t.stdout, t.stderr = cmd, stdout, stderr
+
+	t.wg.Add(2)
+	// Leer stderr línea a línea; ffmpeg separa el progreso con \r
+	go func() {
+		defer t.wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		scanner.Split(scanLogLines)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && onLog != nil {
+				onLog(line)
+			}
+		}
+		// Una línea demasiado larga detiene el scanner: seguir drenando stderr
+		_, _ = io.Copy(io.Discard, stderr)
+	}()
+	// Leer stdout y extraer JPEGs completos
+	go func() {
+		defer t.wg.Done()
+		splitJPEGs(stdout, onFrame)
+	}()
+	return nil
+}
+
+func (t *execTranscoder) Wait() error {
+	t.wg.Wait()
+	return t.cmd.Wait()
+}
+
+func (t *execTranscoder) Stop() {
+	t.stopOnce.Do(func() {
+		if t.cmd == nil || t.cmd.Process == nil {
+			return
+		}
+		_ = t.cmd.Process.Kill()
+		// Cerrar las tuberías por si algún proceso hijo las mantiene abiertas
+		t.stdout.Close()
+		t.stderr.Close()
+	})
+}
+
+// scanLogLines separa la salida de diagnóstico por \n o \r
+func scanLogLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
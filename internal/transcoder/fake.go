@@ -0,0 +1,97 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"sync"
+)
+
+// fakeJPEG es el frame que emite el transcodificador falso: un cuadro gris de 16x16
+var fakeJPEG = func() []byte {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, img, nil)
+	return buf.Bytes()
+}()
+
+// fakeTranscoder es un transcodificador en memoria que no necesita binarios
+// externos: emite un JPEG fijo por cada frame IVF de la entrada (o por cada
+// lectura en H.264), lo que permite probar el relay sin ffmpeg instalado.
+type fakeTranscoder struct {
+	input    io.ReadCloser
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFake crea un transcodificador falso
+func NewFake() Transcoder {
+	return &fakeTranscoder{done: make(chan struct{})}
+}
+
+func (t *fakeTranscoder) Start(spec InputSpec, onFrame func([]byte), onLog func(string)) error {
+	t.input = spec.Input
+	if onLog != nil {
+		onLog("fake: transcodificando " + spec.MimeType + " desde " + spec.Format)
+	}
+	go func() {
+		defer close(t.done)
+		defer t.input.Close()
+		emit := func() {
+			if onFrame != nil {
+				frame := make([]byte, len(fakeJPEG))
+				copy(frame, fakeJPEG)
+				onFrame(frame)
+			}
+		}
+		if spec.Format == "ivf" {
+			readIVFFrames(t.input, emit)
+		} else {
+			buf := make([]byte, 32*1024)
+			for {
+				if _, err := t.input.Read(buf); err != nil {
+					break
+				}
+				emit()
+			}
+		}
+	}()
+	return nil
+}
+
+// readIVFFrames recorre una secuencia IVF llamando a onFrame por cada frame
+func readIVFFrames(r io.Reader, onFrame func()) {
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	frameHeader := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, frameHeader); err != nil {
+			return
+		}
+		size := binary.LittleEndian.Uint32(frameHeader[:4])
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return
+		}
+		onFrame()
+	}
+}
+
+func (t *fakeTranscoder) Wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *fakeTranscoder) Stop() {
+	t.stopOnce.Do(func() {
+		if t.input != nil {
+			t.input.Close()
+		}
+	})
+}
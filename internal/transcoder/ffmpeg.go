@@ -0,0 +1,24 @@
+package transcoder
+
+import "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+
+// NewFFmpeg crea un transcodificador que ejecuta ffmpeg
+func NewFFmpeg(c config.FFmpegConfig) Transcoder {
+	return &execTranscoder{binary: c.Binary, args: func(spec InputSpec) []string {
+		return ffmpegArgs(c, spec)
+	}}
+}
+
+// ffmpegArgs construye la línea de comandos de ffmpeg: lectura por stdin en el
+// formato de la entrada (ivf o h264), argumentos de codificación configurados
+// (por defecto MJPEG sin audio, calidad moderada) y salida MJPEG por stdout.
+func ffmpegArgs(c config.FFmpegConfig, spec InputSpec) []string {
+	args := []string{
+		"-nostdin",
+		"-fflags", "nobuffer",
+		"-f", spec.Format,
+		"-i", "pipe:0",
+	}
+	args = append(args, c.Args...)
+	return append(args, "-f", "mjpeg", "pipe:1")
+}
@@ -0,0 +1,27 @@
+package transcoder
+
+import (
+	"strings"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// NewGStreamer crea un transcodificador que ejecuta un pipeline de gst-launch
+func NewGStreamer(c config.GStreamerConfig) Transcoder {
+	return &execTranscoder{binary: c.Binary, args: func(spec InputSpec) []string {
+		return gstreamerArgs(c, spec)
+	}}
+}
+
+// gstreamerArgs construye el pipeline: stdin → demuxer/parser del formato de
+// entrada → decodebin → codificador JPEG configurado → stdout. -q evita que
+// gst-launch escriba mensajes en stdout mezclados con los JPEGs.
+func gstreamerArgs(c config.GStreamerConfig, spec InputSpec) []string {
+	parser := "ivfparse"
+	if spec.Format == "h264" {
+		parser = "h264parse"
+	}
+	pipeline := []string{"-q", "fdsrc", "fd=0", "!", parser, "!", "decodebin", "!", "videoconvert", "!"}
+	pipeline = append(pipeline, strings.Fields(c.Encoder)...)
+	return append(pipeline, "!", "fdsink", "fd=1", "sync=false")
+}
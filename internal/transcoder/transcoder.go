@@ -0,0 +1,105 @@
+// Package transcoder convierte el vídeo depaquetizado de un publicador en una
+// secuencia de JPEGs para los viewers MJPEG. Cada backend (ffmpeg, gst-launch o
+// el falso en memoria) implementa la interfaz Transcoder.
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// InputSpec describe la entrada del transcodificador
+type InputSpec struct {
+	// Format es el contenedor de la entrada: "ivf" (VP8, VP9, AV1) o "h264" (Annex-B)
+	Format string
+	// MimeType es el códec negociado con el publicador (p. ej. video/VP8)
+	MimeType string
+	// Input es el flujo de entrada. El transcodificador pasa a ser su dueño y lo cierra.
+	Input io.ReadCloser
+}
+
+// Transcoder es un proceso de transcodificación a MJPEG. Una instancia sólo
+// se arranca una vez; para relanzar se crea otra con New.
+type Transcoder interface {
+	// Start lanza el transcodificador. Cada JPEG completo se entrega a onFrame
+	// y cada línea de diagnóstico a onLog.
+	Start(spec InputSpec, onFrame func([]byte), onLog func(string)) error
+	// Wait espera a que termine y devuelve su error de salida
+	Wait() error
+	// Stop lo detiene sin esperar
+	Stop()
+}
+
+// factory construye un backend a partir de la configuración
+type factory func(c config.Config) Transcoder
+
+var backends = map[string]factory{
+	"ffmpeg":    func(c config.Config) Transcoder { return NewFFmpeg(c.FFmpeg) },
+	"gstreamer": func(c config.Config) Transcoder { return NewGStreamer(c.GStreamer) },
+	"fake":      func(config.Config) Transcoder { return NewFake() },
+}
+
+// New crea un transcodificador del backend indicado
+func New(backend string, c config.Config) (Transcoder, error) {
+	f, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("backend de transcodificación %q no soportado", backend)
+	}
+	return f(c), nil
+}
+
+// Backends devuelve los nombres de los backends disponibles
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitJPEGs lee r y entrega a onFrame cada JPEG completo (de SOI a EOI)
+func splitJPEGs(r io.Reader, onFrame func([]byte)) {
+	buf := make([]byte, 0, 512*1024)
+	jpegSOI := []byte{0xFF, 0xD8}
+	jpegEOI := []byte{0xFF, 0xD9}
+	tmp := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(tmp)
+		if n > 0 {
+			buf = append(buf, tmp[:n]...)
+			for {
+				start := bytes.Index(buf, jpegSOI)
+				if start < 0 {
+					if len(buf) > 2*1024*1024 {
+						buf = buf[:0]
+					}
+					break
+				}
+				endRel := bytes.Index(buf[start:], jpegEOI)
+				if endRel < 0 {
+					if len(buf) > 8*1024*1024 {
+						newBuf := make([]byte, 0, 512*1024)
+						newBuf = append(newBuf, buf[start:]...)
+						buf = newBuf
+					}
+					break
+				}
+				end := start + endRel + 2
+				frame := make([]byte, end-start)
+				copy(frame, buf[start:end])
+				if onFrame != nil {
+					onFrame(frame)
+				}
+				buf = buf[end:]
+			}
+		}
+		if rerr != nil {
+			return
+		}
+	}
+}
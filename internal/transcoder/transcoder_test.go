@@ -0,0 +1,151 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// chunkReader entrega los datos en lecturas de como mucho n bytes
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(r.n, len(p), len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestSplitJPEGs(t *testing.T) {
+	jpegA := []byte{0xFF, 0xD8, 1, 2, 3, 0xFF, 0xD9}
+	jpegB := []byte{0xFF, 0xD8, 4, 0xFF, 0xD9}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		name  string
+		input []byte
+		chunk int
+		want  [][]byte
+	}{
+		{"vacío", nil, 1024, nil},
+		{"un frame", jpegA, 1024, [][]byte{jpegA}},
+		{"dos frames seguidos", join(jpegA, jpegB), 1024, [][]byte{jpegA, jpegB}},
+		{"basura entre frames", join([]byte{9, 9}, jpegA, []byte{7}, jpegB), 1024, [][]byte{jpegA, jpegB}},
+		{"lecturas de un byte", join(jpegA, jpegB), 1, [][]byte{jpegA, jpegB}},
+		{"frame incompleto al final", join(jpegA, jpegB[:3]), 1024, [][]byte{jpegA}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]byte
+			splitJPEGs(&chunkReader{data: tt.input, n: tt.chunk}, func(f []byte) { got = append(got, f) })
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d frames, se esperaban %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("frame %d = %x, se esperaba %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// ivfStream construye una secuencia IVF con frames de los tamaños indicados
+func ivfStream(sizes ...int) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 32)
+	copy(header, "DKIF")
+	b.Write(header)
+	for i, size := range sizes {
+		frameHeader := make([]byte, 12)
+		binary.LittleEndian.PutUint32(frameHeader, uint32(size))
+		binary.LittleEndian.PutUint64(frameHeader[4:], uint64(i))
+		b.Write(frameHeader)
+		b.Write(make([]byte, size))
+	}
+	return b.Bytes()
+}
+
+func TestFakeTranscoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  []byte
+		want   int
+	}{
+		{"ivf", "ivf", ivfStream(10, 200, 3), 3},
+		{"ivf truncado", "ivf", ivfStream(10, 200)[:32+12+10+12+50], 1},
+		{"ivf sin cabecera", "ivf", []byte("DKIF"), 0},
+		{"h264", "h264", []byte{0, 0, 0, 1, 0x67}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := New("fake", config.Default())
+			if err != nil {
+				t.Fatal(err)
+			}
+			frames := 0
+			var logs []string
+			err = tr.Start(InputSpec{Format: tt.format, MimeType: "video/VP8", Input: io.NopCloser(bytes.NewReader(tt.input))},
+				func(f []byte) {
+					if !bytes.HasPrefix(f, []byte{0xFF, 0xD8}) || !bytes.HasSuffix(f, []byte{0xFF, 0xD9}) {
+						t.Errorf("el frame no es un JPEG completo")
+					}
+					frames++
+				},
+				func(line string) { logs = append(logs, line) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.Wait(); err != nil {
+				t.Fatalf("Wait() = %v", err)
+			}
+			if frames != tt.want {
+				t.Errorf("%d frames, se esperaban %d", frames, tt.want)
+			}
+			if len(logs) == 0 {
+				t.Errorf("no se registró ninguna línea de diagnóstico")
+			}
+		})
+	}
+}
+
+func TestFakeTranscoderStop(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	tr := NewFake()
+	if err := tr.Start(InputSpec{Format: "ivf", Input: pr}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		tr.Wait()
+		close(done)
+	}()
+	tr.Stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait no volvió tras Stop")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, backend := range Backends() {
+		if _, err := New(backend, config.Default()); err != nil {
+			t.Errorf("New(%q) = %v", backend, err)
+		}
+	}
+	if _, err := New("vlc", config.Default()); err == nil {
+		t.Error("New(\"vlc\") no devolvió error")
+	}
+}
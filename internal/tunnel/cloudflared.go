@@ -0,0 +1,67 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// cloudflaredProvider ejecuta cloudflared: un quick tunnel hacia el servidor
+// local o, con token, el túnel con nombre configurado en Cloudflare. La URL y
+// la salud se consultan en su servidor de métricas.
+type cloudflaredProvider struct {
+	metricsURL string
+	hostname   string
+	proc       process
+}
+
+func newCloudflared(c config.CloudflaredConfig, target string, insecure bool) *cloudflaredProvider {
+	args := []string{"tunnel", "--no-autoupdate", "--metrics", c.MetricsAddr}
+	var env []string
+	if c.Token != "" {
+		// El token va por entorno para no exponerlo en la lista de procesos
+		args = append(args, "run")
+		env = append(env, "TUNNEL_TOKEN="+c.Token)
+	} else {
+		args = append(args, "--url", target)
+		if insecure {
+			// La CA autofirmada del servidor local no es de confianza para cloudflared
+			args = append(args, "--no-tls-verify")
+		}
+	}
+	return &cloudflaredProvider{
+		metricsURL: "http://" + c.MetricsAddr,
+		hostname:   c.Hostname,
+		proc:       process{binary: c.Binary, args: args, env: env, log: logging.For("tunnel").With("provider", "cloudflared")},
+	}
+}
+
+func (p *cloudflaredProvider) Name() string          { return "cloudflared" }
+func (p *cloudflaredProvider) Start() error          { return p.proc.start() }
+func (p *cloudflaredProvider) Done() <-chan struct{} { return p.proc.done }
+func (p *cloudflaredProvider) Stop()                 { p.proc.stop() }
+
+// PublicURL devuelve https://hostname para túneles con nombre o consulta el
+// hostname asignado al quick tunnel
+func (p *cloudflaredProvider) PublicURL(ctx context.Context) (string, error) {
+	if p.hostname != "" {
+		return "https://" + p.hostname, nil
+	}
+	var result struct {
+		Hostname string `json:"hostname"`
+	}
+	if err := getJSON(ctx, p.metricsURL+"/quicktunnel", &result); err != nil {
+		return "", err
+	}
+	if result.Hostname == "" {
+		return "", errors.New("cloudflared aún no tiene hostname asignado")
+	}
+	return "https://" + result.Hostname, nil
+}
+
+// Check usa /ready, que responde 200 mientras haya conexiones con Cloudflare
+func (p *cloudflaredProvider) Check(ctx context.Context) error {
+	return checkStatus(ctx, p.metricsURL+"/ready")
+}
@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// ngrokProvider ejecuta "ngrok http <destino>" y consulta su API local para
+// obtener la URL pública
+type ngrokProvider struct {
+	apiURL string
+	proc   process
+}
+
+func newNgrok(c config.NgrokConfig, target string) (*ngrokProvider, error) {
+	binary, err := findNgrok(c.Binary)
+	if err != nil {
+		return nil, err
+	}
+	log := logging.For("tunnel").With("provider", "ngrok")
+	log.Info("Usando ejecutable", "path", binary)
+	return &ngrokProvider{
+		apiURL: c.APIURL,
+		proc:   process{binary: binary, args: []string{"http", target, "--log", "stdout"}, log: log},
+	}, nil
+}
+
+// findNgrok resuelve el binario configurado o busca ngrok/ngrok.exe en el
+// directorio de trabajo y después en el PATH
+func findNgrok(configured string) (string, error) {
+	if configured != "" {
+		return exec.LookPath(configured)
+	}
+	for _, name := range []string{"ngrok", "ngrok.exe"} {
+		if st, err := os.Stat(name); err == nil && !st.IsDir() {
+			// Ruta absoluta: exec no ejecuta binarios del directorio actual por nombre
+			return filepath.Abs(name)
+		}
+	}
+	path, err := exec.LookPath("ngrok")
+	if err != nil {
+		return "", errors.New("ngrok no encontrado en el directorio de trabajo ni en el PATH")
+	}
+	return path, nil
+}
+
+func (p *ngrokProvider) Name() string          { return "ngrok" }
+func (p *ngrokProvider) Start() error          { return p.proc.start() }
+func (p *ngrokProvider) Done() <-chan struct{} { return p.proc.done }
+func (p *ngrokProvider) Stop()                 { p.proc.stop() }
+
+// PublicURL devuelve la URL del primer túnel de la API, preferiblemente HTTPS
+func (p *ngrokProvider) PublicURL(ctx context.Context) (string, error) {
+	var result struct {
+		Tunnels []struct {
+			PublicURL string `json:"public_url"`
+		} `json:"tunnels"`
+	}
+	if err := getJSON(ctx, p.apiURL, &result); err != nil {
+		return "", err
+	}
+	if len(result.Tunnels) == 0 {
+		return "", errors.New("la API de ngrok no lista ningún túnel")
+	}
+	for _, t := range result.Tunnels {
+		if strings.HasPrefix(t.PublicURL, "https://") {
+			return t.PublicURL, nil
+		}
+	}
+	return result.Tunnels[0].PublicURL, nil
+}
+
+// Check comprueba que la API de ngrok sigue listando el túnel
+func (p *ngrokProvider) Check(ctx context.Context) error {
+	if _, err := p.PublicURL(ctx); err != nil {
+		return fmt.Errorf("ngrok: %w", err)
+	}
+	return nil
+}
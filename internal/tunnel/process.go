@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// stopTimeout es la espera tras la señal de interrupción antes de matar el proceso
+const stopTimeout = 5 * time.Second
+
+// process es el proceso externo de un túnel. Su salida (stdout y stderr) se
+// registra línea a línea en el log a nivel debug.
+type process struct {
+	binary string
+	args   []string
+	env    []string
+	log    *slog.Logger
+
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// start lanza el proceso; done se cierra cuando termina
+func (p *process) start() error {
+	pr, pw := io.Pipe()
+	cmd := exec.Command(p.binary, p.args...)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if len(p.env) > 0 {
+		cmd.Env = append(os.Environ(), p.env...)
+	}
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return err
+	}
+	p.cmd = cmd
+	p.done = make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			p.log.Debug(scanner.Text(), "stream", "output")
+		}
+		// Seguir vaciando la tubería si hay una línea demasiado larga
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+	go func(done chan struct{}) {
+		err := cmd.Wait()
+		pw.Close()
+		p.log.Info("Proceso del túnel terminado", "err", err)
+		close(done)
+	}(p.done)
+	return nil
+}
+
+// stop pide al proceso que termine y lo mata si no lo hace a tiempo
+func (p *process) stop() {
+	if p.cmd == nil {
+		return
+	}
+	// En Windows no se puede enviar os.Interrupt a otro proceso
+	if runtime.GOOS == "windows" || p.cmd.Process.Signal(os.Interrupt) != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		_ = p.cmd.Process.Kill()
+		<-p.done
+	}
+	p.cmd = nil
+}
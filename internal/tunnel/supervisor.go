@@ -0,0 +1,205 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// maxHealthFailures es el número de comprobaciones de salud fallidas seguidas
+// tras el que se relanza el túnel
+const maxHealthFailures = 3
+
+// Status es el estado del túnel que se publica en /tunnel
+type Status struct {
+	Provider  string    `json:"provider"`
+	PublicURL string    `json:"public_url"`
+	Running   bool      `json:"running"`
+	Healthy   bool      `json:"healthy"`
+	Restarts  int       `json:"restarts"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Supervisor mantiene el túnel arrancado y avisa con onURL cada vez que
+// cambia la URL pública ("" mientras el túnel está caído)
+type Supervisor struct {
+	provider Provider
+	cfg      config.TunnelConfig
+	onURL    func(string)
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewSupervisor crea el supervisor del proveedor p; Run lo pone en marcha
+func NewSupervisor(p Provider, cfg config.TunnelConfig, onURL func(string)) *Supervisor {
+	return &Supervisor{
+		provider: p,
+		cfg:      cfg,
+		onURL:    onURL,
+		log:      logging.For("tunnel").With("provider", p.Name()),
+		status:   Status{Provider: p.Name()},
+	}
+}
+
+// Run arranca el túnel y lo relanza con espera exponencial hasta que se cancela ctx
+func (s *Supervisor) Run(ctx context.Context) {
+	backoff := s.cfg.RestartBackoff
+	for attempt := 0; ; attempt++ {
+		s.update(func(st *Status) { st.Restarts = attempt })
+		started := time.Now()
+		err := s.runOnce(ctx)
+		s.setURL("")
+		s.update(func(st *Status) {
+			st.Running = false
+			st.Healthy = false
+			if err != nil {
+				st.LastError = err.Error()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		// Un túnel que ha funcionado un buen rato vuelve a la espera mínima
+		if time.Since(started) > s.cfg.MaxRestartBackoff {
+			backoff = s.cfg.RestartBackoff
+		}
+		s.log.Warn("Túnel caído, relanzando", "err", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.cfg.MaxRestartBackoff)
+	}
+}
+
+// runOnce arranca el túnel, obtiene su URL y lo vigila hasta que falla o se cancela ctx
+func (s *Supervisor) runOnce(ctx context.Context) error {
+	if err := s.provider.Start(); err != nil {
+		return fmt.Errorf("arrancando %s: %w", s.provider.Name(), err)
+	}
+	defer s.provider.Stop()
+	s.update(func(st *Status) { st.Running = true })
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.provider.Done():
+		return errors.New("el proceso terminó al arrancar")
+	case <-time.After(s.cfg.StartupDelay):
+	}
+	url, err := s.discover(ctx)
+	if err != nil {
+		return err
+	}
+	s.setURL(url)
+	s.update(func(st *Status) { st.Healthy = true; st.LastCheck = time.Now() })
+
+	ticker := time.NewTicker(s.cfg.HealthInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.provider.Done():
+			return errors.New("el proceso terminó")
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, s.cfg.HealthInterval)
+		err := s.provider.Check(checkCtx)
+		if err == nil {
+			// La URL puede cambiar sin que se relance el proceso
+			if u, uerr := s.provider.PublicURL(checkCtx); uerr == nil && u != url {
+				url = u
+				s.setURL(url)
+			}
+		}
+		cancel()
+		s.update(func(st *Status) {
+			st.Healthy = err == nil
+			st.LastCheck = time.Now()
+			if err != nil {
+				st.LastError = err.Error()
+			}
+		})
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		s.log.Warn("Comprobación de salud fallida", "failures", failures, "err", err)
+		if failures >= maxHealthFailures {
+			return fmt.Errorf("comprobación de salud: %w", err)
+		}
+	}
+}
+
+// discover consulta la URL pública con reintentos; la espera se duplica en cada intento
+func (s *Supervisor) discover(ctx context.Context) (string, error) {
+	delay := s.cfg.RetryDelay
+	var err error
+	for i := 0; i < s.cfg.Retries; i++ {
+		var url string
+		if url, err = s.provider.PublicURL(ctx); err == nil {
+			return url, nil
+		}
+		s.log.Info("No se pudo obtener la URL pública, reintentando", "attempt", i+1, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-s.provider.Done():
+			return "", errors.New("el proceso terminó antes de publicar la URL")
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return "", fmt.Errorf("no se pudo obtener la URL pública tras %d intentos: %w", s.cfg.Retries, err)
+}
+
+// setURL publica la URL si ha cambiado
+func (s *Supervisor) setURL(url string) {
+	s.mu.Lock()
+	changed := s.status.PublicURL != url
+	s.status.PublicURL = url
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if url != "" {
+		s.log.Info("URL pública", "url", url)
+	}
+	if s.onURL != nil {
+		s.onURL(url)
+	}
+}
+
+func (s *Supervisor) update(fn func(*Status)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+// Status devuelve una copia del estado actual
+func (s *Supervisor) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// ServeHTTP publica el estado del túnel en JSON
+func (s *Supervisor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
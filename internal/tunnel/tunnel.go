@@ -0,0 +1,110 @@
+// Package tunnel publica el servidor local en Internet mediante un proveedor
+// de túneles (ngrok, cloudflared o una URL fija) y lo supervisa: si el proceso
+// termina o deja de responder a la comprobación de salud se relanza con espera
+// exponencial y la URL pública se vuelve a consultar.
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// Provider es un proveedor de túnel. Start y Stop no se llaman de forma
+// concurrente: el supervisor arranca, consulta y detiene un túnel cada vez.
+type Provider interface {
+	Name() string
+	// Start arranca el túnel sin esperar a que esté listo
+	Start() error
+	// Done se cierra cuando el túnel termina por sí solo (nil si nunca termina)
+	Done() <-chan struct{}
+	// PublicURL consulta la URL pública del túnel activo
+	PublicURL(ctx context.Context) (string, error)
+	// Check comprueba que el túnel activo sigue funcionando
+	Check(ctx context.Context) error
+	// Stop detiene el túnel y espera a que termine
+	Stop()
+}
+
+// httpClient se usa para las APIs locales de los proveedores y las comprobaciones de salud
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// New crea el proveedor configurado, o nil si el proveedor es none
+func New(c config.Config) (Provider, error) {
+	switch c.Tunnel.Provider {
+	case "none":
+		return nil, nil
+	case "ngrok":
+		return newNgrok(c.Tunnel.Ngrok, localTarget(c))
+	case "cloudflared":
+		return newCloudflared(c.Tunnel.Cloudflared, localTarget(c), c.TLS.Enabled), nil
+	case "static":
+		return &staticProvider{url: c.Tunnel.PublicURL}, nil
+	}
+	return nil, fmt.Errorf("proveedor de túnel %q no soportado", c.Tunnel.Provider)
+}
+
+// localTarget devuelve la URL local a la que reenvía el túnel
+func localTarget(c config.Config) string {
+	if c.TLS.Enabled {
+		return fmt.Sprintf("https://localhost:%d", c.Port())
+	}
+	return fmt.Sprintf("http://localhost:%d", c.Port())
+}
+
+// getJSON hace un GET y decodifica la respuesta JSON en out
+func getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: estado %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// checkStatus hace un GET y falla si la respuesta no es 200
+func checkStatus(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: estado %s", url, resp.Status)
+	}
+	return nil
+}
+
+// staticProvider anuncia una URL fija (p. ej. un proxy inverso propio) y
+// comprueba que responde en /healthz
+type staticProvider struct {
+	url string
+}
+
+func (p *staticProvider) Name() string          { return "static" }
+func (p *staticProvider) Start() error          { return nil }
+func (p *staticProvider) Done() <-chan struct{} { return nil }
+func (p *staticProvider) Stop()                 {}
+
+func (p *staticProvider) PublicURL(context.Context) (string, error) {
+	return p.url, nil
+}
+
+func (p *staticProvider) Check(ctx context.Context) error {
+	return checkStatus(ctx, p.url+"/healthz")
+}
@@ -0,0 +1,116 @@
+package turnserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/turn/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// Server es un servidor TURN embebido que autentica con credenciales efímeras
+// de tipo "TURN REST" (usuario = expiración:identificador, contraseña = HMAC del secreto).
+type Server struct {
+	server *turn.Server
+	secret string
+	ttl    time.Duration
+	urls   []string
+}
+
+// Start arranca el servidor TURN en UDP y TCP sobre la dirección configurada.
+// Si no se indica secreto se genera uno aleatorio válido sólo para este proceso.
+func Start(c config.TURNConfig) (*Server, error) {
+	publicIP := net.ParseIP(c.PublicIP)
+	if publicIP == nil {
+		return nil, fmt.Errorf("turn.public_ip %q no es una IP válida", c.PublicIP)
+	}
+	secret := c.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = base64.RawStdEncoding.EncodeToString(buf)
+		logging.For("turn").Warn("No hay secreto configurado, usando uno aleatorio para esta ejecución")
+	}
+
+	udpListener, err := net.ListenPacket("udp4", c.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo escuchar TURN/UDP en %s: %w", c.ListenAddr, err)
+	}
+	tcpListener, err := net.Listen("tcp4", c.ListenAddr)
+	if err != nil {
+		udpListener.Close()
+		return nil, fmt.Errorf("no se pudo escuchar TURN/TCP en %s: %w", c.ListenAddr, err)
+	}
+
+	relayGenerator := func() turn.RelayAddressGenerator {
+		if c.RelayPorts.Min != 0 {
+			return &turn.RelayAddressGeneratorPortRange{
+				RelayAddress: publicIP,
+				Address:      "0.0.0.0",
+				MinPort:      c.RelayPorts.Min,
+				MaxPort:      c.RelayPorts.Max,
+			}
+		}
+		return &turn.RelayAddressGeneratorStatic{
+			RelayAddress: publicIP,
+			Address:      "0.0.0.0",
+		}
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       c.Realm,
+		AuthHandler: turn.LongTermTURNRESTAuthHandler(secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udpListener,
+			RelayAddressGenerator: relayGenerator(),
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcpListener,
+			RelayAddressGenerator: relayGenerator(),
+		}},
+	})
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	_, port, _ := net.SplitHostPort(c.ListenAddr)
+	host := net.JoinHostPort(publicIP.String(), port)
+	logging.For("turn").Info("Servidor TURN escuchando", "addr", c.ListenAddr, "public_ip", host, "realm", c.Realm)
+	return &Server{
+		server: server,
+		secret: secret,
+		ttl:    c.CredentialTTL,
+		urls: []string{
+			"turn:" + host + "?transport=udp",
+			"turn:" + host + "?transport=tcp",
+		},
+	}, nil
+}
+
+// Credentials genera un usuario y contraseña efímeros para user, válidos durante el TTL configurado.
+func (s *Server) Credentials(user string) (config.ICEServer, error) {
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(s.secret, user, s.ttl)
+	if err != nil {
+		return config.ICEServer{}, err
+	}
+	return config.ICEServer{URLs: s.urls, Username: username, Credential: password}, nil
+}
+
+// TTL devuelve la validez de las credenciales emitidas.
+func (s *Server) TTL() time.Duration {
+	return s.ttl
+}
+
+// Close detiene el servidor y libera los puertos.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,54 @@
+package webrtc
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/static"
+)
+
+// assets son las páginas de la interfaz: las embebidas en el binario o, si se
+// configura static_dir, las de ese directorio con las embebidas como respaldo
+var assets fs.FS = static.Files
+
+// indexTmpl es la plantilla de la página principal, analizada al arrancar
+var indexTmpl *template.Template
+
+// overlayFS busca cada fichero primero en dir y, si no existe, en base
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// initAssets elige el sistema de ficheros de la interfaz y analiza index.tmpl
+func initAssets(c cfg.Config) error {
+	assets = static.Files
+	if c.StaticDir != "" {
+		assets = overlayFS{dir: os.DirFS(c.StaticDir), base: static.Files}
+		logging.For("server").Info("Sirviendo ficheros estáticos con sustituciones", "dir", c.StaticDir)
+	}
+	tmpl, err := template.ParseFS(assets, "index.tmpl")
+	if err != nil {
+		return err
+	}
+	indexTmpl = tmpl
+	return nil
+}
+
+// serveAsset sirve una página de la interfaz
+func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
+	http.ServeFileFS(w, r, assets, name)
+}
@@ -0,0 +1,194 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+const (
+	// bitrateDecrease multiplica el bitrate pedido cada intervalo con retraso
+	bitrateDecrease = 0.7
+	// bitrateIncrease lo recupera poco a poco cuando el pipeline vuelve a dar abasto
+	bitrateIncrease = 1.1
+	// bitrateRecoverAfter es el número de intervalos sin retraso antes de empezar a subir
+	bitrateRecoverAfter = 3
+)
+
+// bitrateStatus es el estado del control de bitrate de un publicador que se
+// expone en /stats/bitrate
+type bitrateStatus struct {
+	StreamID   int       `json:"streamId"`
+	CapKbps    int       `json:"capKbps"`
+	TargetKbps int       `json:"targetKbps"`
+	Lagging    bool      `json:"lagging"`
+	Reductions int       `json:"reductions"`
+	SSRCs      []uint32  `json:"ssrcs"`
+	LastREMB   time.Time `json:"lastRemb"`
+}
+
+// bitrateController pide al publicador, con REMB, que no envíe más de lo que
+// el canal admite ni de lo que su pipeline MJPEG puede procesar
+type bitrateController struct {
+	code string
+	pc   *webrtc.PeerConnection
+
+	mu          sync.Mutex
+	status      bitrateStatus
+	target      float64 // kbps
+	lastDropped uint64
+	calm        int // intervalos seguidos sin retraso
+}
+
+// bitrateControllers indexa los controladores por canal y stream
+var bitrateControllers = struct {
+	sync.Mutex
+	m map[string]map[int]*bitrateController
+}{m: make(map[string]map[int]*bitrateController)}
+
+// newBitrateController crea el controlador del publicador; run lo registra y lo pone en marcha
+func newBitrateController(code string, streamID int, pc *webrtc.PeerConnection) *bitrateController {
+	return &bitrateController{code: code, pc: pc, status: bitrateStatus{StreamID: streamID}}
+}
+
+// addSSRC añade el SSRC de una capa de vídeo a los que se indican en el REMB
+func (c *bitrateController) addSSRC(ssrc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.status.SSRCs, ssrc) {
+		c.status.SSRCs = append(c.status.SSRCs, ssrc)
+	}
+}
+
+// capKbps es el tope del canal: su política de medios o, si no tiene, congestion.max_kbps
+func (c *bitrateController) capKbps() int {
+	if kbps := channelMediaPolicy(c.code).MaxBitrateKbps; kbps > 0 {
+		return kbps
+	}
+	return serverConfig.Congestion.MaxKbps
+}
+
+// run envía un REMB cada intervalo hasta que se cancela ctx
+func (c *bitrateController) run(ctx context.Context) {
+	bitrateControllers.Lock()
+	if bitrateControllers.m[c.code] == nil {
+		bitrateControllers.m[c.code] = make(map[int]*bitrateController)
+	}
+	bitrateControllers.m[c.code][c.status.StreamID] = c
+	bitrateControllers.Unlock()
+	defer func() {
+		bitrateControllers.Lock()
+		if bitrateControllers.m[c.code][c.status.StreamID] == c {
+			delete(bitrateControllers.m[c.code], c.status.StreamID)
+			if len(bitrateControllers.m[c.code]) == 0 {
+				delete(bitrateControllers.m, c.code)
+			}
+		}
+		bitrateControllers.Unlock()
+	}()
+	ticker := time.NewTicker(serverConfig.Congestion.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.tick()
+		}
+	}
+}
+
+// tick recalcula el bitrate objetivo según el retraso del pipeline y lo envía
+func (c *bitrateController) tick() {
+	cfg := serverConfig.Congestion
+	capKbps := c.capKbps()
+	lag, feeding := pipelineLag(c.code, c.status.StreamID)
+
+	c.mu.Lock()
+	if c.target == 0 {
+		c.target = float64(capKbps)
+	}
+	lagging := feeding && (lag.Stalled || lag.Queue >= cfg.LagQueue || lag.Dropped > c.lastDropped)
+	c.lastDropped = lag.Dropped
+	previous, wasLagging := c.target, c.status.Lagging
+	switch {
+	case lagging:
+		c.calm = 0
+		c.target = max(float64(cfg.MinKbps), c.target*bitrateDecrease)
+		if c.target < previous {
+			c.status.Reductions++
+		}
+	case c.calm < bitrateRecoverAfter:
+		c.calm++
+	default:
+		c.target *= bitrateIncrease
+	}
+	c.target = min(c.target, float64(capKbps))
+	c.status.CapKbps = capKbps
+	c.status.TargetKbps = int(c.target)
+	c.status.Lagging = lagging
+	ssrcs := slices.Clone(c.status.SSRCs)
+	target := c.target
+	c.mu.Unlock()
+
+	log := logging.For("bitrate").With("channel", c.code, "stream_id", c.status.StreamID)
+	switch {
+	case lagging && !wasLagging:
+		log.Info("Pipeline con retraso, bajando el bitrate del publicador", "target_kbps", int(target), "queue", lag.Queue, "dropped", lag.Dropped, "stalled", lag.Stalled)
+	case lagging && target < previous:
+		log.Debug("Bajando el bitrate del publicador", "target_kbps", int(target), "queue", lag.Queue, "dropped", lag.Dropped, "stalled", lag.Stalled)
+	case !lagging && int(previous) < int(target) && int(target) == capKbps:
+		log.Info("Bitrate del publicador recuperado", "target_kbps", int(target))
+	}
+	if len(ssrcs) == 0 {
+		return
+	}
+	remb := &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: float32(target * 1000), SSRCs: ssrcs}
+	if err := c.pc.WriteRTCP([]rtcp.Packet{remb}); err != nil {
+		log.Debug("Error enviando REMB", "err", err)
+		return
+	}
+	c.mu.Lock()
+	c.status.LastREMB = time.Now()
+	c.mu.Unlock()
+}
+
+// snapshot devuelve una copia del estado
+func (c *bitrateController) snapshot() bitrateStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.status
+	st.SSRCs = slices.Clone(st.SSRCs)
+	return st
+}
+
+// bitrateStatsHandler devuelve en JSON el control de bitrate de cada publicador
+// del canal: GET /stats/bitrate?code=ABC
+func bitrateStatsHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	bitrateControllers.Lock()
+	controllers := make([]*bitrateController, 0, len(bitrateControllers.m[code]))
+	for _, c := range bitrateControllers.m[code] {
+		controllers = append(controllers, c)
+	}
+	bitrateControllers.Unlock()
+	stats := make([]bitrateStatus, 0, len(controllers))
+	for _, c := range controllers {
+		stats = append(stats, c.snapshot())
+	}
+	slices.SortFunc(stats, func(a, b bitrateStatus) int { return a.StreamID - b.StreamID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
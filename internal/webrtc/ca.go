@@ -0,0 +1,24 @@
+package webrtc
+
+import (
+	"net/http"
+)
+
+// caCert es el certificado DER de la CA autofirmada (nil si no se usa)
+var caCert []byte
+
+// caUIHandler sirve la página con las instrucciones para instalar la CA
+func caUIHandler(w http.ResponseWriter, r *http.Request) {
+	serveAsset(w, r, "ca.html")
+}
+
+// caCertHandler descarga la CA autofirmada en DER, formato que aceptan iOS y Android
+func caCertHandler(w http.ResponseWriter, r *http.Request) {
+	if caCert == nil {
+		http.Error(w, "El servidor no usa una CA autofirmada", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="go-pion-stream-ca.crt"`)
+	w.Write(caCert)
+}
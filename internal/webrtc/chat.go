@@ -0,0 +1,219 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+// chatLabel es la etiqueta del data channel de chat que abre el publicador
+const chatLabel = "chat"
+
+// chatKeepAlive es cada cuánto se envía un comentario SSE para mantener viva la conexión
+const chatKeepAlive = 15 * time.Second
+
+// chatLimits traduce la configuración del chat a los límites del relay
+func chatLimits(c cfg.ChatConfig) relay.ChatLimits {
+	return relay.ChatLimits{History: c.History, MaxLength: c.MaxLength, Rate: c.Rate, Burst: c.Burst}
+}
+
+// chatStatus traduce los errores del chat a un código HTTP
+func chatStatus(err error) int {
+	switch {
+	case errors.Is(err, relay.ErrChatRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, relay.ErrChatInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// attachPublisherChat conecta el data channel "chat" del publicador con el chat
+// del canal: al abrirse recibe el historial y después cada mensaje nuevo, y lo
+// que escribe el publicador ({"text": "..."}) se publica con su stream ID.
+func attachPublisherChat(code string, streamID int, dc *webrtc.DataChannel) {
+	logger := logging.For("chat").With("channel", code, "stream_id", streamID)
+	channel, exists := connectionManager.ValidateChannel(code)
+	if !exists {
+		_ = dc.Close()
+		return
+	}
+	send := func(v any) {
+		data, err := json.Marshal(v)
+		if err == nil {
+			err = dc.SendText(string(data))
+		}
+		if err != nil {
+			logger.Debug("Error enviando por el data channel de chat", "err", err)
+		}
+	}
+	dc.OnOpen(func() {
+		history, messages, cancel := channel.SubscribeChat()
+		dc.OnClose(cancel)
+		for _, msg := range history {
+			send(msg)
+		}
+		go func() {
+			for msg := range messages {
+				send(msg)
+			}
+		}()
+		logger.Info("Chat del publicador abierto", "history", len(history))
+	})
+	dc.OnMessage(func(m webrtc.DataChannelMessage) {
+		var in struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(m.Data, &in); err != nil {
+			send(map[string]string{"error": "mensaje mal formado"})
+			return
+		}
+		if _, err := channel.PostChat(relay.ChatMessage{Role: relay.ChatRolePublisher, StreamID: streamID, Text: in.Text}); err != nil {
+			send(map[string]string{"error": err.Error()})
+		}
+	})
+}
+
+// requestViewer valida code, clientID y el token que /register entregó al
+// cliente (?token= o cabecera X-Client-Token) y devuelve el canal y el viewer
+func requestViewer(w http.ResponseWriter, r *http.Request) (*relay.Channel, *relay.Client, bool) {
+	code := r.URL.Query().Get("code")
+	clientID, err := strconv.Atoi(r.URL.Query().Get("clientID"))
+	if code == "" || err != nil {
+		http.Error(w, "code y clientID requeridos", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	channel, exists := connectionManager.ValidateChannel(code)
+	if !exists {
+		http.Error(w, "Canal no encontrado", http.StatusNotFound)
+		return nil, nil, false
+	}
+	client, err := channel.GetClient(clientID)
+	if err != nil {
+		http.Error(w, "El viewer no está registrado en el canal", http.StatusForbidden)
+		return nil, nil, false
+	}
+	if !client.ValidToken(clientToken(r)) {
+		http.Error(w, "Token de cliente inválido", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return channel, client, true
+}
+
+// clientToken devuelve el token de cliente de la petición; EventSource no
+// permite cabeceras, así que también se acepta en la query
+func clientToken(r *http.Request) string {
+	if token := r.Header.Get("X-Client-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requestOperator indica si la petición viene del operador del canal: un
+// cliente registrado con rol de operador (clientID y token) o quien presenta
+// la clave de operador del canal guardado (?key=)
+func requestOperator(r *http.Request, code string) bool {
+	if checkOperatorKey(code, requestOperatorKey(r)) {
+		return true
+	}
+	channel, exists := connectionManager.ValidateChannel(code)
+	clientID, err := strconv.Atoi(r.URL.Query().Get("clientID"))
+	if !exists || err != nil {
+		return false
+	}
+	client, err := channel.GetClient(clientID)
+	return err == nil && client.ValidToken(clientToken(r)) && client.GetRole() == relay.ClientRoleOperator
+}
+
+// chatHandler publica el mensaje de un viewer: POST /chat?code=ABC&clientID=3
+// con {"text": "..."}. Devuelve el mensaje publicado con su ID y hora.
+func chatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	channel, client, ok := requestViewer(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	msg, err := channel.PostChat(relay.ChatMessage{Role: relay.ChatRoleViewer, ClientID: client.ID, Text: in.Text})
+	if err != nil {
+		http.Error(w, err.Error(), chatStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// chatEventsHandler envía el chat del canal como Server-Sent Events:
+// GET /chat/events?code=ABC&clientID=3. Primero llega el historial y después
+// cada mensaje nuevo, hasta que el viewer sale del canal.
+func chatEventsHandler(w http.ResponseWriter, r *http.Request) {
+	channel, client, ok := requestViewer(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming no soportado", http.StatusInternalServerError)
+		return
+	}
+	history, messages, cancel := channel.SubscribeChat()
+	defer cancel()
+	// Los eventos de movimiento van con "event: motion" para no mezclarse con los mensajes
+	motion, cancelMotion := subscribeMotion(r.URL.Query().Get("code"))
+	defer cancelMotion()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	write := func(msg relay.ChatMessage) {
+		data, _ := json.Marshal(msg)
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.ID, data)
+	}
+	for _, msg := range history {
+		write(msg)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(chatKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			write(msg)
+			flusher.Flush()
+		case ev := <-motion:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: motion\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-client.Done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/transcoder"
+)
+
+// Estados de un trabajo de exportación de clip
+const (
+	clipQueued   = "queued"
+	clipRunning  = "running"
+	clipDone     = "done"
+	clipFailed   = "failed"
+	clipCanceled = "canceled"
+)
+
+// clipContentTypes es el tipo MIME de cada formato de clip
+var clipContentTypes = map[string]string{
+	"avi":  "video/x-msvideo",
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+}
+
+// clipStatus es el estado de un trabajo de exportación que se expone en /clips.
+// El buffer DVR sólo guarda vídeo, así que los clips no llevan audio.
+type clipStatus struct {
+	ID         string    `json:"id"`
+	Code       string    `json:"code"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Format     string    `json:"format"`
+	State      string    `json:"state"`
+	Progress   float64   `json:"progress"` // 0 a 1
+	Frames     int       `json:"frames"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+	Download   string    `json:"download,omitempty"`
+}
+
+// clipJob exporta un intervalo del buffer DVR de un canal a un fichero
+type clipJob struct {
+	path   string // fichero final; se escribe en path+".part"
+	cancel context.CancelFunc
+	done   chan struct{} // se cierra cuando run termina y ya no toca los ficheros
+
+	mu     sync.Mutex
+	status clipStatus
+}
+
+func (j *clipJob) snapshot() clipStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func (j *clipJob) update(fn func(st *clipStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+}
+
+// clipJobs indexa los trabajos de exportación por ID. pending cuenta los que
+// esperan hueco o se están exportando, como mucho clips.max_jobs + clips.max_queued.
+var clipJobs = struct {
+	sync.Mutex
+	m       map[string]*clipJob
+	pending int
+}{m: make(map[string]*clipJob)}
+
+// errClipQueueFull indica que no caben más exportaciones en cola
+var errClipQueueFull = errors.New("hay demasiadas exportaciones de clips en curso, reintenta más tarde")
+
+// finishedClipJob devuelve un trabajo sin exportación pendiente
+func finishedClipJob(path string, st clipStatus) *clipJob {
+	done := make(chan struct{})
+	close(done)
+	return &clipJob{path: path, cancel: func() {}, done: done, status: st}
+}
+
+// clipSlots limita las exportaciones simultáneas a clips.max_jobs
+var clipSlots chan struct{}
+
+// initClips prepara el directorio de clips, recupera los que siguen dentro de
+// su retención y arranca la limpieza periódica. Sin DVR no hay clips.
+func initClips(c cfg.ClipsConfig, dvr cfg.DVRConfig) error {
+	if !dvr.Enabled {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("clips.dir: %w", err)
+	}
+	clipSlots = make(chan struct{}, c.MaxJobs)
+	// Los trabajos terminados guardan su estado junto al fichero; los que se
+	// quedaron a medias al apagar se descartan
+	parts, _ := filepath.Glob(filepath.Join(c.Dir, "*.part"))
+	for _, path := range parts {
+		os.Remove(path)
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	restored := 0
+	for _, sidecar := range sidecars {
+		data, err := os.ReadFile(sidecar)
+		var st clipStatus
+		if err == nil {
+			err = json.Unmarshal(data, &st)
+		}
+		path := strings.TrimSuffix(sidecar, ".json")
+		if err != nil || st.State != clipDone || time.Now().After(st.ExpiresAt) {
+			os.Remove(sidecar)
+			os.Remove(path)
+			continue
+		}
+		clipJobs.m[st.ID] = finishedClipJob(path, st)
+		restored++
+	}
+	go clipJanitor()
+	logging.For("clips").Info("Exportación de clips activada", "dir", c.Dir, "retention", c.Retention, "restored", restored)
+	return nil
+}
+
+// clipJanitor borra los clips cuya retención ha vencido
+func clipJanitor() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dvrCtx.Done():
+			return
+		case now := <-ticker.C:
+			clipJobs.Lock()
+			for id, j := range clipJobs.m {
+				st := j.snapshot()
+				if !st.ExpiresAt.IsZero() && now.After(st.ExpiresAt) {
+					removeClipFiles(j.path)
+					delete(clipJobs.m, id)
+					logging.For("clips").Info("Clip caducado", "id", id, "channel", st.Code)
+				}
+			}
+			clipJobs.Unlock()
+		}
+	}
+}
+
+func removeClipFiles(path string) {
+	os.Remove(path)
+	os.Remove(path + ".part")
+	os.Remove(path + ".json")
+}
+
+// newClipID genera un identificador aleatorio para un trabajo
+func newClipID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startClip crea un trabajo que exporta [from, to] del canal y lo encola.
+// Devuelve errClipQueueFull si ya hay clips.max_jobs + clips.max_queued pendientes.
+func startClip(code string, b *dvrBuffer, from, to time.Time, format string) (*clipJob, error) {
+	clipJobs.Lock()
+	defer clipJobs.Unlock()
+	if clipJobs.pending >= serverConfig.Clips.MaxJobs+serverConfig.Clips.MaxQueued {
+		return nil, errClipQueueFull
+	}
+	ctx, cancel := context.WithCancel(dvrCtx)
+	id := newClipID()
+	j := &clipJob{
+		path:   filepath.Join(serverConfig.Clips.Dir, "clip-"+id+"."+format),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: clipStatus{
+			ID:        id,
+			Code:      code,
+			From:      from,
+			To:        to,
+			Format:    format,
+			State:     clipQueued,
+			CreatedAt: time.Now(),
+		},
+	}
+	clipJobs.m[id] = j
+	clipJobs.pending++
+	go j.run(ctx, b)
+	return j, nil
+}
+
+// run espera un hueco, exporta el clip y deja el resultado durante clips.retention
+func (j *clipJob) run(ctx context.Context, b *dvrBuffer) {
+	defer func() {
+		j.cancel()
+		clipJobs.Lock()
+		clipJobs.pending--
+		clipJobs.Unlock()
+		close(j.done)
+	}()
+	log := logging.For("clips")
+	st := j.snapshot()
+	select {
+	case clipSlots <- struct{}{}:
+		defer func() { <-clipSlots }()
+	case <-ctx.Done():
+		j.finish(ctx.Err())
+		return
+	}
+	j.update(func(st *clipStatus) { st.State = clipRunning })
+	log.Info("Exportando clip", "id", st.ID, "channel", st.Code, "from", st.From, "to", st.To, "format", st.Format)
+
+	err := j.export(ctx, b, st)
+	if err == nil {
+		err = os.Rename(j.path+".part", j.path)
+	}
+	if err != nil {
+		os.Remove(j.path + ".part")
+		log.Warn("No se pudo exportar el clip", "id", st.ID, "channel", st.Code, "err", err)
+	}
+	j.finish(err)
+}
+
+// export escribe el clip en path+".part"
+func (j *clipJob) export(ctx context.Context, b *dvrBuffer, st clipStatus) error {
+	total, first, last := b.countFrames(st.From, st.To)
+	if total == 0 {
+		return errors.New("no hay frames grabados en ese intervalo")
+	}
+	// Los frames llegan a ritmo variable; el clip usa la velocidad media
+	fps := 1.0
+	if span := last.Sub(first).Seconds(); total > 1 && span > 0 {
+		fps = float64(total-1) / span
+	}
+	written := 0
+	progress := func() {
+		written++
+		j.update(func(st *clipStatus) {
+			st.Frames = written
+			st.Progress = min(float64(written)/float64(total), 1)
+		})
+	}
+
+	if st.Format != "avi" {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(b.eachFrame(ctx, st.From, st.To, func(f dvrFrame) error {
+				if _, err := pw.Write(f.data); err != nil {
+					return err
+				}
+				progress()
+				return nil
+			}))
+		}()
+		err := transcoder.EncodeClip(ctx, serverConfig.FFmpeg, st.Format, fps, pr, j.path+".part")
+		pr.Close()
+		return err
+	}
+
+	file, err := os.Create(j.path + ".part")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var avi *transcoder.AVIWriter
+	err = b.eachFrame(ctx, st.From, st.To, func(f dvrFrame) error {
+		if avi == nil {
+			conf, err := jpeg.DecodeConfig(bytes.NewReader(f.data))
+			if err != nil {
+				return fmt.Errorf("JPEG ilegible: %w", err)
+			}
+			if avi, err = transcoder.NewAVIWriter(file, conf.Width, conf.Height); err != nil {
+				return err
+			}
+		}
+		if err := avi.WriteFrame(f.data); err != nil {
+			return err
+		}
+		progress()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if avi == nil {
+		return errors.New("los frames del intervalo han salido de la ventana DVR")
+	}
+	if err := avi.Close(fps); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// finish marca el trabajo como terminado y, si hay clip, guarda su estado
+// junto al fichero para recuperarlo tras un reinicio
+func (j *clipJob) finish(err error) {
+	now := time.Now()
+	j.update(func(st *clipStatus) {
+		st.FinishedAt = now
+		st.ExpiresAt = now.Add(serverConfig.Clips.Retention)
+		switch {
+		case errors.Is(err, context.Canceled):
+			st.State = clipCanceled
+		case err != nil:
+			st.State = clipFailed
+			st.Error = err.Error()
+		default:
+			st.State = clipDone
+			st.Progress = 1
+			st.Download = "/clips/download?code=" + url.QueryEscape(st.Code) + "&id=" + st.ID
+			if info, statErr := os.Stat(j.path); statErr == nil {
+				st.Bytes = info.Size()
+			}
+		}
+	})
+	st := j.snapshot()
+	if st.State != clipDone {
+		return
+	}
+	logging.For("clips").Info("Clip exportado", "id", st.ID, "channel", st.Code, "frames", st.Frames, "bytes", st.Bytes)
+	if data, err := json.Marshal(st); err == nil {
+		if err := os.WriteFile(j.path+".json", data, 0o644); err != nil {
+			logging.For("clips").Warn("No se pudo guardar el estado del clip", "id", st.ID, "err", err)
+		}
+	}
+}
+
+// clipRange lee el intervalo pedido: ?from=&to= (RFC 3339 o segundos Unix) o
+// ?offset= (cuánto hace que empieza, por defecto 30s) y ?duration= (por
+// defecto hasta ahora). El final nunca pasa de ahora.
+func clipRange(r *http.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	now := time.Now()
+	var from, to time.Time
+	if v := q.Get("from"); v != "" {
+		t, err := parseDVRTime(v)
+		if err != nil {
+			return from, to, fmt.Errorf("from: %w", err)
+		}
+		from = t
+	} else {
+		offset := 30 * time.Second
+		if v := q.Get("offset"); v != "" {
+			d, err := parseDVROffset(v)
+			if err != nil {
+				return from, to, fmt.Errorf("offset: %w", err)
+			}
+			offset = d
+		}
+		from = now.Add(-offset)
+	}
+	to = now
+	if v := q.Get("to"); v != "" {
+		t, err := parseDVRTime(v)
+		if err != nil {
+			return from, to, fmt.Errorf("to: %w", err)
+		}
+		to = t
+	} else if v := q.Get("duration"); v != "" {
+		d, err := parseDVROffset(v)
+		if err != nil {
+			return from, to, fmt.Errorf("duration: %w", err)
+		}
+		to = from.Add(d)
+	}
+	if to.After(now) {
+		to = now
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("el intervalo está vacío")
+	}
+	// Lo que ya salió de la ventana DVR no se puede exportar
+	if cutoff := now.Add(-serverConfig.DVR.Window); from.Before(cutoff) {
+		if !to.After(cutoff) {
+			return from, to, errors.New("el intervalo está fuera de la ventana DVR")
+		}
+		from = cutoff
+	}
+	if to.Sub(from) > serverConfig.Clips.MaxDuration {
+		return from, to, fmt.Errorf("el clip dura más que clips.max_duration (%v)", serverConfig.Clips.MaxDuration)
+	}
+	return from, to, nil
+}
+
+// clipsHandler gestiona los trabajos de exportación de clips:
+// POST /clips?code=&from=&to=|offset=&duration=&format=avi|mp4|webm (202),
+// GET /clips[?code=|id=] y DELETE /clips?id= (cancela y borra). POST cuenta
+// para limits.per_ip y limits.global y responde 429 si la cola está llena.
+func clipsHandler(w http.ResponseWriter, r *http.Request) {
+	if !serverConfig.DVR.Enabled {
+		http.Error(w, "Los clips se exportan del buffer DVR (dvr.enabled)", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		id, code := q.Get("id"), q.Get("code")
+		clipJobs.Lock()
+		statuses := make([]clipStatus, 0, len(clipJobs.m))
+		for _, j := range clipJobs.m {
+			st := j.snapshot()
+			if (id == "" || st.ID == id) && (code == "" || st.Code == code) {
+				statuses = append(statuses, st)
+			}
+		}
+		clipJobs.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if id != "" {
+			if len(statuses) == 0 {
+				http.Error(w, "Clip no encontrado", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(statuses[0])
+			return
+		}
+		slices.SortFunc(statuses, func(a, b clipStatus) int { return a.CreatedAt.Compare(b.CreatedAt) })
+		json.NewEncoder(w).Encode(statuses)
+	case http.MethodPost:
+		if !allowRequest(w, r) {
+			return
+		}
+		code := q.Get("code")
+		b := dvrSource(w, code)
+		if b == nil {
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "avi"
+		}
+		if !slices.Contains(transcoder.ClipFormats, format) {
+			http.Error(w, fmt.Sprintf("formato %q no soportado (%s)", format, strings.Join(transcoder.ClipFormats, ", ")), http.StatusBadRequest)
+			return
+		}
+		from, to, err := clipRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		j, err := startClip(code, b, from, to, format)
+		if err != nil {
+			writeLimitError(w, http.StatusTooManyRequests, limitClips, err.Error(), 0)
+			return
+		}
+		st := j.snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/clips?id="+st.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(st)
+	case http.MethodDelete:
+		id := q.Get("id")
+		clipJobs.Lock()
+		j, exists := clipJobs.m[id]
+		delete(clipJobs.m, id)
+		clipJobs.Unlock()
+		if !exists {
+			http.Error(w, "Clip no encontrado", http.StatusNotFound)
+			return
+		}
+		// La exportación puede estar escribiendo todavía el .part o el .json
+		j.cancel()
+		<-j.done
+		removeClipFiles(j.path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// clipDownloadHandler descarga un clip terminado: GET /clips/download?code=&id=
+func clipDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireWatcher(w, r) {
+		return
+	}
+	clipJobs.Lock()
+	j, exists := clipJobs.m[r.URL.Query().Get("id")]
+	clipJobs.Unlock()
+	if !exists {
+		http.Error(w, "Clip no encontrado", http.StatusNotFound)
+		return
+	}
+	st := j.snapshot()
+	// Con share.required el viewer sólo descarga clips de su canal
+	if serverConfig.Share.Required && r.URL.Query().Get("code") != st.Code {
+		http.Error(w, "Clip no encontrado", http.StatusNotFound)
+		return
+	}
+	if st.State != clipDone {
+		http.Error(w, fmt.Sprintf("El clip no está listo (%s)", st.State), http.StatusConflict)
+		return
+	}
+	file, err := os.Open(j.path)
+	if err != nil {
+		http.Error(w, "Clip no disponible", http.StatusGone)
+		return
+	}
+	defer file.Close()
+	name := fmt.Sprintf("%s-%s.%s", st.Code, st.From.Format("20060102-150405"), st.Format)
+	w.Header().Set("Content-Type", clipContentTypes[st.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, st.FinishedAt, file)
+}
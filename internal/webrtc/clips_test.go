@@ -0,0 +1,110 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// La cola de exportación está acotada y DELETE espera a que el trabajo suelte
+// sus ficheros antes de borrarlos
+func TestClipsQueueAndDelete(t *testing.T) {
+	saved := serverConfig
+	savedSlots := clipSlots
+	defer func() {
+		serverConfig = saved
+		clipSlots = savedSlots
+	}()
+	serverConfig.DVR.Enabled = true
+	serverConfig.Clips.Dir = t.TempDir()
+	serverConfig.Clips.MaxJobs = 1
+	serverConfig.Clips.MaxQueued = 1
+	clipSlots = make(chan struct{}, 1)
+
+	const code = "TEST-CLIPS"
+	var frame bytes.Buffer
+	jpeg.Encode(&frame, image.NewGray(image.Rect(0, 0, 16, 16)), nil)
+	b := &dvrBuffer{code: code}
+	start := time.Now().Add(-10 * time.Second)
+	for i := 0; i < 10; i++ {
+		b.add(start.Add(time.Duration(i)*time.Second), frame.Bytes())
+	}
+	dvrBuffers.Lock()
+	dvrBuffers.m[code] = b
+	dvrBuffers.Unlock()
+	defer func() {
+		dvrBuffers.Lock()
+		delete(dvrBuffers.m, code)
+		dvrBuffers.Unlock()
+	}()
+
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		clipsHandler(rec, httptest.NewRequest("POST", "/clips?code="+code+"&offset=20s", nil))
+		return rec
+	}
+	// Con el único hueco ocupado los trabajos se quedan en cola
+	clipSlots <- struct{}{}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rec := post()
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST %d = %d %s", i, rec.Code, rec.Body)
+		}
+		var st clipStatus
+		json.NewDecoder(rec.Body).Decode(&st)
+		ids = append(ids, st.ID)
+	}
+	if rec := post(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST con la cola llena = %d, se esperaba 429", rec.Code)
+	} else {
+		var body limitError
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Limit != limitClips {
+			t.Errorf("límite %q, se esperaba %q", body.Limit, limitClips)
+		}
+	}
+
+	// Cancelar un trabajo en cola libera su plaza
+	rec := httptest.NewRecorder()
+	clipsHandler(rec, httptest.NewRequest("DELETE", "/clips?id="+ids[0], nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	clipJobs.Lock()
+	pending := clipJobs.pending
+	clipJobs.Unlock()
+	if pending != 1 {
+		t.Fatalf("%d trabajos pendientes tras DELETE, se esperaba 1", pending)
+	}
+
+	// Al liberar el hueco se exporta el que queda
+	<-clipSlots
+	clipJobs.Lock()
+	j := clipJobs.m[ids[1]]
+	clipJobs.Unlock()
+	select {
+	case <-j.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("el clip no terminó")
+	}
+	if st := j.snapshot(); st.State != clipDone || st.Frames != 10 {
+		t.Fatalf("estado final %+v", st)
+	}
+	rec = httptest.NewRecorder()
+	clipsHandler(rec, httptest.NewRequest("DELETE", "/clips?id="+ids[1], nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	for _, suffix := range []string{"", ".part", ".json"} {
+		if _, err := os.Stat(j.path + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s sigue en disco", j.path+suffix)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package webrtc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+// videoRTCPFeedback es el feedback RTCP anunciado para todos los códecs de vídeo
+var videoRTCPFeedback = []webrtc.RTCPFeedback{{Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+
+// videoCodecs son los códecs de vídeo aceptados, en el orden de preferencia por defecto.
+// H.264 se registra con varios perfiles (baseline, constrained baseline, main y high)
+// para que Safari/iOS negocien el que usan por hardware.
+var videoCodecs = []webrtc.RTPCodecParameters{
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback}, PayloadType: 96},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoRTCPFeedback}, PayloadType: 106},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoRTCPFeedback}, PayloadType: 102},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", RTCPFeedback: videoRTCPFeedback}, PayloadType: 127},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f", RTCPFeedback: videoRTCPFeedback}, PayloadType: 112},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback}, PayloadType: 98},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=2", RTCPFeedback: videoRTCPFeedback}, PayloadType: 100},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback}, PayloadType: 45},
+}
+
+// rtxPayloadTypes asigna a cada payload type de vídeo el de su flujo de
+// retransmisión RTX (RFC 4588), usado para recuperar los paquetes pedidos por NACK
+var rtxPayloadTypes = map[webrtc.PayloadType]webrtc.PayloadType{
+	96: 97, 106: 107, 102: 103, 127: 125, 112: 113, 98: 99, 100: 101, 45: 46,
+}
+
+// rtxCodecs devuelve los códecs RTX asociados a videoCodecs
+func rtxCodecs() []webrtc.RTPCodecParameters {
+	codecs := make([]webrtc.RTPCodecParameters, 0, len(videoCodecs))
+	for _, codec := range videoCodecs {
+		codecs = append(codecs, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeRTX, ClockRate: 90000, SDPFmtpLine: fmt.Sprintf("apt=%d", codec.PayloadType)},
+			PayloadType:        rtxPayloadTypes[codec.PayloadType],
+		})
+	}
+	return codecs
+}
+
+// audioCodecs son los códecs de audio aceptados
+var audioCodecs = []webrtc.RTPCodecParameters{
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}, PayloadType: 111},
+}
+
+// registerCodecs registra en el MediaEngine todos los códecs soportados
+func registerCodecs(m *webrtc.MediaEngine) error {
+	for _, codec := range append(append([]webrtc.RTPCodecParameters{}, videoCodecs...), rtxCodecs()...) {
+		if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	for _, codec := range audioCodecs {
+		if err := m.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// codecMimeType traduce un nombre corto (vp8, h264, vp9, av1) a su MIME type de vídeo
+func codecMimeType(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "vp8":
+		return webrtc.MimeTypeVP8, nil
+	case "h264":
+		return webrtc.MimeTypeH264, nil
+	case "vp9":
+		return webrtc.MimeTypeVP9, nil
+	case "av1":
+		return webrtc.MimeTypeAV1, nil
+	}
+	return "", fmt.Errorf("códec %q no soportado (vp8, h264, vp9, av1)", name)
+}
+
+// channelMediaPolicy devuelve la política del canal, completada con los valores por defecto de la configuración
+func channelMediaPolicy(code string) relay.MediaPolicy {
+	policy := relay.MediaPolicy{}
+	if channel, exists := connectionManager.ValidateChannel(code); exists {
+		policy = channel.GetMediaPolicy()
+	}
+	if len(policy.CodecPreference) == 0 {
+		for _, name := range serverConfig.Media.CodecPreference {
+			if mime, err := codecMimeType(name); err == nil {
+				policy.CodecPreference = append(policy.CodecPreference, mime)
+			}
+		}
+	}
+	if policy.MaxBitrateKbps == 0 {
+		policy.MaxBitrateKbps = serverConfig.Media.MaxBitrateKbps
+	}
+	if policy.Transcoder == "" {
+		policy.Transcoder = serverConfig.Transcoder.Backend
+	}
+	return policy
+}
+
+// preferredVideoCodecs ordena los códecs de vídeo según la preferencia del canal.
+// Los códecs no mencionados se mantienen al final en su orden por defecto.
+func preferredVideoCodecs(preference []string) []webrtc.RTPCodecParameters {
+	ordered := make([]webrtc.RTPCodecParameters, 0, len(videoCodecs))
+	used := make([]bool, len(videoCodecs))
+	for _, mime := range preference {
+		for i, codec := range videoCodecs {
+			if !used[i] && strings.EqualFold(codec.MimeType, mime) {
+				ordered = append(ordered, codec)
+				used[i] = true
+			}
+		}
+	}
+	for i, codec := range videoCodecs {
+		if !used[i] {
+			ordered = append(ordered, codec)
+		}
+	}
+	// Las preferencias deben incluir RTX o pion no lo negocia
+	return append(ordered, rtxCodecs()...)
+}
+
+// applyMaxBitrate añade b=AS y b=TIAS a la sección de vídeo del SDP para limitar el bitrate del publicador
+func applyMaxBitrate(sdpText string, maxKbps int) (string, error) {
+	if maxKbps <= 0 {
+		return sdpText, nil
+	}
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.UnmarshalString(sdpText); err != nil {
+		return "", err
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "video" {
+			continue
+		}
+		media.Bandwidth = append(media.Bandwidth,
+			sdp.Bandwidth{Type: "AS", Bandwidth: uint64(maxKbps)},
+			sdp.Bandwidth{Type: "TIAS", Bandwidth: uint64(maxKbps) * 1000},
+		)
+	}
+	out, err := parsed.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
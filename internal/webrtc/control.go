@@ -0,0 +1,273 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+// controlLabel es la etiqueta del data channel por el que el publicador recibe órdenes
+const controlLabel = "control"
+
+// controlCommand es una orden de un viewer tal y como se envía al publicador
+type controlCommand struct {
+	ID       int64           `json:"id"`
+	Command  string          `json:"command"`
+	Args     json.RawMessage `json:"args,omitempty"`
+	ClientID int             `json:"client_id"`
+}
+
+// controlAck es la confirmación del publicador con el resultado de la orden
+type controlAck struct {
+	ID     int64           `json:"id"`
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// controlArgs valida y normaliza los argumentos de cada orden. keyframe no
+// llega al publicador: el servidor pide el keyframe con un PLI.
+var controlArgs = map[string]func(raw json.RawMessage) (any, error){
+	"camera": func(raw json.RawMessage) (any, error) {
+		var a struct {
+			Facing   string `json:"facing,omitempty"`
+			DeviceID string `json:"device_id,omitempty"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		if a.DeviceID == "" && a.Facing != "user" && a.Facing != "environment" {
+			return nil, errors.New("camera requiere facing (user o environment) o device_id")
+		}
+		return a, nil
+	},
+	"torch": func(raw json.RawMessage) (any, error) {
+		var a struct {
+			On *bool `json:"on"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		if a.On == nil {
+			return nil, errors.New("torch requiere on (true o false)")
+		}
+		return a, nil
+	},
+	"resolution": func(raw json.RawMessage) (any, error) {
+		var a struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		if a.Width < 160 || a.Width > 3840 || a.Height < 120 || a.Height > 2160 {
+			return nil, errors.New("resolution requiere width (160-3840) y height (120-2160)")
+		}
+		return a, nil
+	},
+	"mute": func(raw json.RawMessage) (any, error) {
+		var a struct {
+			Muted *bool `json:"muted"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		if a.Muted == nil {
+			return nil, errors.New("mute requiere muted (true o false)")
+		}
+		return a, nil
+	},
+	"keyframe": func(json.RawMessage) (any, error) { return nil, nil },
+}
+
+// controlSession es el data channel de control de un publicador y las órdenes
+// que esperan su confirmación
+type controlSession struct {
+	dc      *webrtc.DataChannel
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan controlAck
+}
+
+// controlSessions indexa las sesiones de control por canal y stream
+var controlSessions = struct {
+	sync.Mutex
+	m map[string]map[int]*controlSession
+}{m: make(map[string]map[int]*controlSession)}
+
+// getControlSession devuelve la sesión de control del stream indicado
+func getControlSession(code string, streamID int) *controlSession {
+	controlSessions.Lock()
+	defer controlSessions.Unlock()
+	return controlSessions.m[code][streamID]
+}
+
+// attachPublisherControl registra el data channel "control" del publicador y
+// entrega a quien espera cada confirmación que llega por él
+func attachPublisherControl(code string, streamID int, dc *webrtc.DataChannel) {
+	logger := logging.For("control").With("channel", code, "stream_id", streamID)
+	s := &controlSession{dc: dc, pending: make(map[int64]chan controlAck)}
+	dc.OnOpen(func() {
+		controlSessions.Lock()
+		if controlSessions.m[code] == nil {
+			controlSessions.m[code] = make(map[int]*controlSession)
+		}
+		controlSessions.m[code][streamID] = s
+		controlSessions.Unlock()
+		logger.Info("Canal de control del publicador abierto")
+	})
+	dc.OnClose(func() {
+		controlSessions.Lock()
+		if controlSessions.m[code][streamID] == s {
+			delete(controlSessions.m[code], streamID)
+			if len(controlSessions.m[code]) == 0 {
+				delete(controlSessions.m, code)
+			}
+		}
+		controlSessions.Unlock()
+		// Las órdenes pendientes ya no recibirán confirmación
+		s.mu.Lock()
+		for id, ch := range s.pending {
+			ch <- controlAck{ID: id, Error: "el publicador se ha desconectado"}
+			delete(s.pending, id)
+		}
+		s.mu.Unlock()
+	})
+	dc.OnMessage(func(m webrtc.DataChannelMessage) {
+		var ack controlAck
+		if err := json.Unmarshal(m.Data, &ack); err != nil {
+			logger.Warn("Confirmación de control mal formada", "err", err)
+			return
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[ack.ID]
+		delete(s.pending, ack.ID)
+		s.mu.Unlock()
+		if ok {
+			ch <- ack
+		}
+	})
+}
+
+// send envía la orden al publicador y espera su confirmación hasta que vence ctx
+func (s *controlSession) send(ctx context.Context, cmd controlCommand) (controlAck, error) {
+	ch := make(chan controlAck, 1)
+	s.mu.Lock()
+	s.nextID++
+	cmd.ID = s.nextID
+	s.pending[cmd.ID] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, cmd.ID)
+		s.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return controlAck{}, err
+	}
+	if err := s.dc.SendText(string(data)); err != nil {
+		return controlAck{}, fmt.Errorf("enviando la orden: %w", err)
+	}
+	select {
+	case ack := <-ch:
+		return ack, nil
+	case <-ctx.Done():
+		return controlAck{ID: cmd.ID}, ctx.Err()
+	}
+}
+
+// controlHandler envía una orden al publicador activo del canal y devuelve su
+// confirmación: POST /control?code=ABC&clientID=3 con
+// {"command": "torch", "args": {"on": true}}
+func controlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	channel, client, ok := requestViewer(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Command string          `json:"command"`
+		Args    json.RawMessage `json:"args"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	validate, known := controlArgs[in.Command]
+	if !known {
+		http.Error(w, fmt.Sprintf("orden %q no soportada", in.Command), http.StatusBadRequest)
+		return
+	}
+	role := client.GetRole()
+	if role != relay.ClientRoleOperator && !slices.Contains(serverConfig.Control.ViewerCommands, in.Command) {
+		http.Error(w, fmt.Sprintf("la orden %q está reservada al operador del canal", in.Command), http.StatusForbidden)
+		return
+	}
+	if len(in.Args) == 0 {
+		in.Args = json.RawMessage("{}")
+	}
+	args, err := validate(in.Args)
+	if err != nil {
+		http.Error(w, "Argumentos inválidos: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	active := channel.GetActiveStreamID()
+	if active == nil {
+		http.Error(w, "No hay publicador en el canal", http.StatusConflict)
+		return
+	}
+	streamID := *active
+	logger := logging.For("control").With("channel", channel.Code, "stream_id", streamID, "client_id", client.ID, "command", in.Command)
+
+	var ack controlAck
+	if in.Command == "keyframe" {
+		layers := 0
+		if hub := lookupLayerHub(channel.Code); hub != nil {
+			layers = hub.requestKeyframes(streamID)
+		}
+		ack = controlAck{OK: layers > 0, Result: json.RawMessage(fmt.Sprintf(`{"layers":%d}`, layers))}
+		if layers == 0 {
+			ack.Error = "el publicador aún no envía vídeo"
+		}
+	} else {
+		session := getControlSession(channel.Code, streamID)
+		if session == nil {
+			http.Error(w, "El publicador no acepta órdenes", http.StatusConflict)
+			return
+		}
+		cmd := controlCommand{Command: in.Command, ClientID: client.ID}
+		if cmd.Args, err = json.Marshal(args); err != nil {
+			http.Error(w, "Argumentos inválidos", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), serverConfig.Control.Timeout)
+		defer cancel()
+		ack, err = session.send(ctx, cmd)
+		if err != nil {
+			logger.Warn("Orden sin confirmar", "err", err)
+			ack.Error = "el publicador no ha confirmado la orden"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(ack)
+			return
+		}
+	}
+	logger.Info("Orden de control", "role", role, "ok", ack.OK, "error", ack.Error)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ack)
+}
@@ -0,0 +1,541 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// dvrSegmentDuration es el tiempo que abarca cada fichero de frames volcados a disco
+const dvrSegmentDuration = time.Minute
+
+// dvrFrame es un JPEG del canal con la hora a la que salió del transcodificador
+type dvrFrame struct {
+	at   time.Time
+	data []byte
+}
+
+// dvrIndexEntry localiza un frame dentro de un segmento en disco
+type dvrIndexEntry struct {
+	at     time.Time
+	offset int64
+	size   int
+}
+
+// dvrSegment es un fichero con frames volcados desde memoria, uno tras otro
+type dvrSegment struct {
+	path  string
+	file  *os.File
+	size  int64
+	index []dvrIndexEntry
+}
+
+func (s *dvrSegment) remove() {
+	s.file.Close()
+	os.Remove(s.path)
+}
+
+// dvrBuffer guarda los frames de los últimos dvr.window de un canal: los más
+// recientes en memoria y, al pasar de dvr.memory_mb, los más antiguos en disco
+type dvrBuffer struct {
+	code string
+
+	mu        sync.Mutex
+	memory    []dvrFrame    // frames más recientes, ordenados por hora
+	memBytes  int           // bytes en memory
+	segments  []*dvrSegment // frames más antiguos, ordenados por hora
+	diskBytes int64
+	lastFrame time.Time
+	spillErr  bool // ya se avisó de que no se puede escribir en disco
+}
+
+// dvrStatus es el estado del buffer DVR de un canal que se expone en /dvr
+type dvrStatus struct {
+	Code         string    `json:"code"`
+	Start        time.Time `json:"start,omitempty"`
+	End          time.Time `json:"end,omitempty"`
+	MemoryFrames int       `json:"memoryFrames"`
+	MemoryBytes  int       `json:"memoryBytes"`
+	DiskFrames   int       `json:"diskFrames"`
+	DiskBytes    int64     `json:"diskBytes"`
+	Segments     int       `json:"segments"`
+}
+
+// dvrBuffers indexa los buffers DVR por código de canal. Sobreviven al canal
+// hasta que sus frames salen de la ventana, para poder rebobinar aunque el
+// publicador ya se haya ido.
+var dvrBuffers = struct {
+	sync.Mutex
+	m map[string]*dvrBuffer
+}{m: make(map[string]*dvrBuffer)}
+
+// dvrCtx se cancela al apagar para cortar las reproducciones en curso
+var dvrCtx, dvrCancel = context.WithCancel(context.Background())
+
+// initDVR prepara el directorio de volcado y arranca la limpieza periódica
+func initDVR(c cfg.DVRConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Dir != "" {
+		if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+			return fmt.Errorf("dvr.dir: %w", err)
+		}
+		// Los segmentos de una ejecución anterior no tienen índice: no se pueden reproducir
+		matches, _ := filepath.Glob(filepath.Join(c.Dir, "*.mjpeg"))
+		for _, path := range matches {
+			os.Remove(path)
+		}
+	}
+	go dvrJanitor(dvrCtx, c.Window)
+	logging.For("dvr").Info("DVR activado", "window", c.Window, "memory_mb", c.MemoryMB, "dir", c.Dir)
+	return nil
+}
+
+// dvrJanitor descarta los frames que salen de la ventana aunque el canal ya
+// no reciba vídeo, y olvida los buffers que se quedan vacíos
+func dvrJanitor(ctx context.Context, window time.Duration) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			dvrBuffers.Lock()
+			for code, b := range dvrBuffers.m {
+				b.mu.Lock()
+				b.prune(now.Add(-window))
+				empty := len(b.memory) == 0 && len(b.segments) == 0
+				b.mu.Unlock()
+				if empty {
+					delete(dvrBuffers.m, code)
+					logging.For("dvr").Debug("Buffer DVR vacío descartado", "channel", code)
+				}
+			}
+			dvrBuffers.Unlock()
+		}
+	}
+}
+
+// closeDVR corta las reproducciones y borra los segmentos en disco al apagar
+func closeDVR() {
+	dvrCancel()
+	dvrBuffers.Lock()
+	defer dvrBuffers.Unlock()
+	for code, b := range dvrBuffers.m {
+		b.mu.Lock()
+		for _, seg := range b.segments {
+			seg.remove()
+		}
+		b.segments = nil
+		b.mu.Unlock()
+		delete(dvrBuffers.m, code)
+	}
+}
+
+// dvrRecord añade un JPEG del canal al buffer DVR
+func dvrRecord(code string, frame []byte) {
+	if !serverConfig.DVR.Enabled {
+		return
+	}
+	dvrBuffers.Lock()
+	b, exists := dvrBuffers.m[code]
+	if !exists {
+		b = &dvrBuffer{code: code}
+		dvrBuffers.m[code] = b
+	}
+	dvrBuffers.Unlock()
+	b.add(time.Now(), frame)
+}
+
+// lookupDVR devuelve el buffer DVR del canal, o nil si no tiene
+func lookupDVR(code string) *dvrBuffer {
+	dvrBuffers.Lock()
+	defer dvrBuffers.Unlock()
+	return dvrBuffers.m[code]
+}
+
+func (b *dvrBuffer) add(at time.Time, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Las búsquedas por hora necesitan horas estrictamente crecientes
+	if !at.After(b.lastFrame) {
+		at = b.lastFrame.Add(time.Microsecond)
+	}
+	b.lastFrame = at
+	b.memory = append(b.memory, dvrFrame{at: at, data: data})
+	b.memBytes += len(data)
+	limit := serverConfig.DVR.MemoryMB << 20
+	for b.memBytes > limit && len(b.memory) > 1 {
+		f := b.memory[0]
+		b.memory[0] = dvrFrame{}
+		b.memory = b.memory[1:]
+		b.memBytes -= len(f.data)
+		if err := b.spill(f); err != nil {
+			if !b.spillErr {
+				logging.For("dvr").Warn("No se pudo volcar el DVR a disco, se pierden los frames más antiguos", "channel", b.code, "err", err)
+			}
+			b.spillErr = true
+		} else {
+			b.spillErr = false
+		}
+	}
+	b.prune(at.Add(-serverConfig.DVR.Window))
+}
+
+// spill escribe en disco un frame que sale de memoria (sin dvr.dir se descarta)
+func (b *dvrBuffer) spill(f dvrFrame) error {
+	dir := serverConfig.DVR.Dir
+	if dir == "" {
+		return nil
+	}
+	var seg *dvrSegment
+	if n := len(b.segments); n > 0 {
+		seg = b.segments[n-1]
+	}
+	if seg == nil || (len(seg.index) > 0 && f.at.Sub(seg.index[0].at) >= dvrSegmentDuration) {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d.mjpeg", hex.EncodeToString([]byte(b.code)), f.at.UnixNano()))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		seg = &dvrSegment{path: path, file: file}
+		b.segments = append(b.segments, seg)
+	}
+	n, err := seg.file.WriteAt(f.data, seg.size)
+	if err != nil {
+		return err
+	}
+	seg.index = append(seg.index, dvrIndexEntry{at: f.at, offset: seg.size, size: n})
+	seg.size += int64(n)
+	b.diskBytes += int64(n)
+	return nil
+}
+
+// prune descarta los frames anteriores a cutoff. Los segmentos se borran
+// enteros cuando su último frame sale de la ventana.
+func (b *dvrBuffer) prune(cutoff time.Time) {
+	for len(b.segments) > 0 {
+		seg := b.segments[0]
+		if n := len(seg.index); n > 0 && !seg.index[n-1].at.Before(cutoff) {
+			break
+		}
+		seg.remove()
+		b.diskBytes -= seg.size
+		b.segments[0] = nil
+		b.segments = b.segments[1:]
+	}
+	i := sort.Search(len(b.memory), func(i int) bool { return !b.memory[i].at.Before(cutoff) })
+	for _, f := range b.memory[:i] {
+		b.memBytes -= len(f.data)
+	}
+	clear(b.memory[:i])
+	b.memory = b.memory[i:]
+}
+
+// frameAfter devuelve el primer frame posterior a t (o a partir de t si
+// inclusive) dentro de la ventana
+func (b *dvrBuffer) frameAfter(t time.Time, inclusive bool) (dvrFrame, bool) {
+	b.mu.Lock()
+	if cutoff := time.Now().Add(-serverConfig.DVR.Window); t.Before(cutoff) {
+		t, inclusive = cutoff, true
+	}
+	match := func(at time.Time) bool {
+		if inclusive {
+			return !at.Before(t)
+		}
+		return at.After(t)
+	}
+	for _, seg := range b.segments {
+		n := len(seg.index)
+		if n == 0 || !match(seg.index[n-1].at) {
+			continue
+		}
+		e := seg.index[sort.Search(n, func(i int) bool { return match(seg.index[i].at) })]
+		file := seg.file
+		b.mu.Unlock()
+		// Si el segmento se borra mientras tanto, la lectura falla y se
+		// reintenta más tarde desde la nueva ventana
+		data := make([]byte, e.size)
+		if _, err := file.ReadAt(data, e.offset); err != nil {
+			return dvrFrame{}, false
+		}
+		return dvrFrame{at: e.at, data: data}, true
+	}
+	defer b.mu.Unlock()
+	i := sort.Search(len(b.memory), func(i int) bool { return match(b.memory[i].at) })
+	if i == len(b.memory) {
+		return dvrFrame{}, false
+	}
+	return b.memory[i], true
+}
+
+// countFrames cuenta los frames grabados entre from y to (ambos incluidos) y
+// devuelve la hora del primero y del último
+func (b *dvrBuffer) countFrames(from, to time.Time) (int, time.Time, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	var first, last time.Time
+	count := func(at time.Time) {
+		if at.Before(from) || at.After(to) {
+			return
+		}
+		if n == 0 {
+			first = at
+		}
+		last = at
+		n++
+	}
+	for _, seg := range b.segments {
+		for _, e := range seg.index {
+			count(e.at)
+		}
+	}
+	for _, f := range b.memory {
+		count(f.at)
+	}
+	return n, first, last
+}
+
+// eachFrame entrega a fn los frames grabados entre from y to en orden
+func (b *dvrBuffer) eachFrame(ctx context.Context, from, to time.Time, fn func(dvrFrame) error) error {
+	f, ok := b.frameAfter(from, true)
+	for ok && !f.at.After(to) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+		f, ok = b.frameAfter(f.at, false)
+	}
+	return nil
+}
+
+func (b *dvrBuffer) snapshot() dvrStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := dvrStatus{
+		Code:         b.code,
+		MemoryFrames: len(b.memory),
+		MemoryBytes:  b.memBytes,
+		DiskBytes:    b.diskBytes,
+		Segments:     len(b.segments),
+	}
+	for _, seg := range b.segments {
+		st.DiskFrames += len(seg.index)
+		if st.Start.IsZero() && len(seg.index) > 0 {
+			st.Start = seg.index[0].at
+		}
+	}
+	if len(b.memory) > 0 {
+		if st.Start.IsZero() {
+			st.Start = b.memory[0].at
+		}
+		st.End = b.memory[len(b.memory)-1].at
+	}
+	// El primer segmento puede conservar frames que ya salieron de la ventana
+	if cutoff := time.Now().Add(-serverConfig.DVR.Window); !st.Start.IsZero() && st.Start.Before(cutoff) {
+		st.Start = cutoff
+	}
+	return st
+}
+
+// dvrPosition lee la posición pedida: ?offset= (duración como 2m o segundos)
+// hacia atrás desde ahora, o ?at= (RFC 3339 o segundos Unix). Devuelve false
+// si no se pide ninguna o si la posición es el directo.
+func dvrPosition(r *http.Request) (time.Time, bool, error) {
+	now := time.Now()
+	if offset := r.URL.Query().Get("offset"); offset != "" {
+		d, err := parseDVROffset(offset)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("offset: %w", err)
+		}
+		return now.Add(-d), d > 0, nil
+	}
+	if at := r.URL.Query().Get("at"); at != "" {
+		t, err := parseDVRTime(at)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("at: %w", err)
+		}
+		return t, t.Before(now), nil
+	}
+	return time.Time{}, false, nil
+}
+
+// parseDVROffset lee una duración positiva como 2m o en segundos
+func parseDVROffset(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, perr := strconv.ParseFloat(s, 64)
+		if perr != nil {
+			return 0, fmt.Errorf("duración %q inválida", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duración %q negativa", s)
+	}
+	return d, nil
+}
+
+// parseDVRTime lee una hora en RFC 3339 o en segundos Unix (con decimales)
+func parseDVRTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		secs, perr := strconv.ParseFloat(s, 64)
+		if perr != nil {
+			return time.Time{}, fmt.Errorf("hora %q inválida (RFC 3339 o segundos Unix)", s)
+		}
+		t = time.UnixMicro(int64(secs * 1e6))
+	}
+	return t, nil
+}
+
+// dvrSource valida la petición y devuelve el buffer DVR del canal
+func dvrSource(w http.ResponseWriter, code string) *dvrBuffer {
+	if !serverConfig.DVR.Enabled {
+		http.Error(w, "DVR desactivado (dvr.enabled)", http.StatusNotFound)
+		return nil
+	}
+	b := lookupDVR(code)
+	if b == nil {
+		http.Error(w, "El canal no tiene grabación DVR", http.StatusNotFound)
+		return nil
+	}
+	return b
+}
+
+// dvrWatch sirve en MJPEG los frames del buffer DVR desde start al ritmo al
+// que se grabaron, de modo que el viewer mantiene el mismo retraso respecto
+// al directo. Al alcanzar el final del buffer espera a los frames nuevos.
+func dvrWatch(w http.ResponseWriter, r *http.Request, code string, start time.Time) {
+	b := dvrSource(w, code)
+	if b == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming no soportado", http.StatusInternalServerError)
+		return
+	}
+	f, ok := b.frameAfter(start, true)
+	if !ok {
+		http.Error(w, "No hay frames desde esa hora", http.StatusNotFound)
+		return
+	}
+	logging.For("dvr").Info("Reproducción DVR", "channel", code, "from", f.at, "delay", time.Since(f.at).Round(time.Second))
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-DVR-Start", f.at.Format(time.RFC3339Nano))
+	w.WriteHeader(http.StatusOK)
+
+	wallStart, mediaStart := time.Now(), f.at
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		writeMJPEGFrame(w, f.data)
+		flusher.Flush()
+		var next dvrFrame
+		for {
+			if next, ok = b.frameAfter(f.at, false); ok {
+				break
+			}
+			timer.Reset(100 * time.Millisecond)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			case <-dvrCtx.Done():
+				return
+			}
+		}
+		if wait := time.Until(wallStart.Add(next.at.Sub(mediaStart))); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			case <-dvrCtx.Done():
+				return
+			}
+		}
+		f = next
+	}
+}
+
+// dvrHandler expone el estado de los buffers DVR: GET /dvr[?code=]
+func dvrHandler(w http.ResponseWriter, r *http.Request) {
+	if !serverConfig.DVR.Enabled {
+		http.Error(w, "DVR desactivado (dvr.enabled)", http.StatusNotFound)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	dvrBuffers.Lock()
+	buffers := make([]*dvrBuffer, 0, len(dvrBuffers.m))
+	for c, b := range dvrBuffers.m {
+		if code == "" || c == code {
+			buffers = append(buffers, b)
+		}
+	}
+	dvrBuffers.Unlock()
+	statuses := make([]dvrStatus, 0, len(buffers))
+	for _, b := range buffers {
+		statuses = append(statuses, b.snapshot())
+	}
+	slices.SortFunc(statuses, func(a, b dvrStatus) int { return strings.Compare(a.Code, b.Code) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// dvrFrameHandler devuelve el JPEG grabado en una posición (para pausar o
+// mirar qué pasaba hace un rato): GET /dvr/frame?code=&offset=|at=
+func dvrFrameHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireWatcher(w, r) {
+		return
+	}
+	b := dvrSource(w, r.URL.Query().Get("code"))
+	if b == nil {
+		return
+	}
+	at, _, err := dvrPosition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	f, ok := b.frameAfter(at, true)
+	if !ok {
+		// Posición en el directo o más allá: el último frame grabado
+		b.mu.Lock()
+		if n := len(b.memory); n > 0 {
+			f, ok = b.memory[n-1], true
+		}
+		b.mu.Unlock()
+	}
+	if !ok {
+		http.Error(w, "No hay frames desde esa hora", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-DVR-Time", f.at.Format(time.RFC3339Nano))
+	w.Write(f.data)
+}
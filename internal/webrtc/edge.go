@@ -0,0 +1,570 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+var (
+	errEdgeExists         = errors.New("el canal ya se recibe de un origen")
+	errEdgeLocalPublisher = errors.New("el canal ya tiene un publicador local")
+)
+
+// edgeStatus es el estado de un canal recibido de un origen que se expone en /edge
+type edgeStatus struct {
+	Code           string    `json:"code"`
+	Origin         string    `json:"origin"`
+	RemoteCode     string    `json:"remoteCode"`
+	Connected      bool      `json:"connected"`
+	StreamID       int       `json:"streamId,omitempty"`
+	ConnectedSince time.Time `json:"connectedSince,omitempty"`
+	Connects       int       `json:"connects"`
+	Failures       int       `json:"failures"`
+	LastError      string    `json:"lastError,omitempty"`
+}
+
+// edgePuller se suscribe a un canal del origen como un viewer WebRTC más y
+// entrega sus tracks a HandleTrack, como si vinieran de un publicador local:
+// los viewers WebRTC y MJPEG de este servidor no llegan nunca al origen.
+type edgePuller struct {
+	code   string
+	source relay.RemoteSource
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	clientID int    // viewer en el origen; se reutiliza al reconectar
+	token    string // token de cliente de ese viewer
+	status   edgeStatus
+}
+
+// edgePullers indexa los canales recibidos de un origen por su código local
+var edgePullers = struct {
+	sync.Mutex
+	m map[string]*edgePuller
+}{m: make(map[string]*edgePuller)}
+
+// edgeClient hace la señalización con los orígenes
+var edgeClient = &http.Client{Timeout: 15 * time.Second}
+
+// originError es una respuesta de error del origen
+type originError struct {
+	status int
+	msg    string
+}
+
+func (e *originError) Error() string {
+	return fmt.Sprintf("el origen respondió %d: %s", e.status, e.msg)
+}
+
+// initEdge prepara el cliente HTTP de los orígenes y empieza a recibir los
+// canales configurados
+func initEdge(c cfg.EdgeConfig) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("edge.ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("edge.ca_file %s no contiene certificados PEM", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	edgeClient = &http.Client{Timeout: 15 * time.Second, Transport: transport}
+
+	for _, ch := range c.Channels {
+		remote := ch.RemoteCode
+		if remote == "" {
+			remote = ch.Code
+		}
+		if err := startEdgePull(ch.Code, relay.RemoteSource{Origin: strings.TrimSuffix(ch.Origin, "/"), Code: remote, Share: ch.Share}); err != nil {
+			return fmt.Errorf("edge %s: %w", ch.Code, err)
+		}
+	}
+	return nil
+}
+
+// startEdgePull crea el canal local, lo marca como recibido de src y empieza
+// a tirar de él en segundo plano
+func startEdgePull(code string, src relay.RemoteSource) error {
+	edgePullers.Lock()
+	defer edgePullers.Unlock()
+	if _, exists := edgePullers.m[code]; exists {
+		return errEdgeExists
+	}
+	if channel, exists := connectionManager.ValidateChannel(code); exists && channel.GetActiveStreamID() != nil {
+		return errEdgeLocalPublisher
+	}
+	if err := connectionManager.CreateChannel(code); err != nil {
+		return err
+	}
+	channel, exists := connectionManager.ValidateChannel(code)
+	if !exists {
+		return fmt.Errorf("el canal %s ha desaparecido", code)
+	}
+	channel.SetRemoteSource(&src)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &edgePuller{
+		code:   code,
+		source: src,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: edgeStatus{Code: code, Origin: src.Origin, RemoteCode: src.Code},
+	}
+	edgePullers.m[code] = p
+	go p.run(ctx)
+	logging.For("edge").Info("Canal recibido de un origen", "channel", code, "origin", src.Origin, "remote_code", src.Code)
+	return nil
+}
+
+// stopEdgePull deja de tirar del canal; el canal se elimina si no le quedan
+// viewers. Devuelve false si el canal no se recibía de ningún origen.
+func stopEdgePull(code string) bool {
+	edgePullers.Lock()
+	p, exists := edgePullers.m[code]
+	delete(edgePullers.m, code)
+	edgePullers.Unlock()
+	if !exists {
+		return false
+	}
+	p.cancel()
+	<-p.done
+	if channel, exists := connectionManager.ValidateChannel(code); exists {
+		channel.SetRemoteSource(nil)
+		go channel.ChannelNeedToBeRemoved()
+	}
+	logging.For("edge").Info("Canal del origen detenido", "channel", code)
+	return true
+}
+
+// stopEdgePulls detiene todos los canales recibidos de un origen al apagar
+func stopEdgePulls() {
+	edgePullers.Lock()
+	codes := make([]string, 0, len(edgePullers.m))
+	for code := range edgePullers.m {
+		codes = append(codes, code)
+	}
+	edgePullers.Unlock()
+	for _, code := range codes {
+		stopEdgePull(code)
+	}
+}
+
+// run mantiene la suscripción al origen y la rehace con espera exponencial
+// cada vez que se pierde
+func (p *edgePuller) run(ctx context.Context) {
+	defer close(p.done)
+	log := logging.For("edge").With("channel", p.code, "origin", p.source.Origin, "remote_code", p.source.Code)
+	backoff := serverConfig.Edge.RetryBackoff
+	for {
+		connected, err := p.pull(ctx, log)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = serverConfig.Edge.RetryBackoff
+		}
+		p.mu.Lock()
+		p.status.Connected = false
+		p.status.StreamID = 0
+		p.status.Failures++
+		p.status.LastError = err.Error()
+		p.mu.Unlock()
+		log.Warn("Sin conexión con el origen, reintentando", "err", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, serverConfig.Edge.MaxRetryBackoff)
+	}
+}
+
+// pull hace una suscripción completa: negocia con el origen, publica el vídeo
+// en el canal local y espera hasta que la conexión se pierde o deja de llegar
+// vídeo. Indica si se llegó a conectar y siempre devuelve el motivo del final.
+func (p *edgePuller) pull(ctx context.Context, log *slog.Logger) (bool, error) {
+	if err := connectionManager.CreateChannel(p.code); err != nil {
+		return false, err
+	}
+	channel, exists := connectionManager.ValidateChannel(p.code)
+	if !exists {
+		return false, fmt.Errorf("el canal %s ha desaparecido", p.code)
+	}
+	src := p.source
+	channel.SetRemoteSource(&src)
+
+	streamID := generateStreamID()
+	api, session, err := newPublisherAPI(p.code, streamID)
+	if err != nil {
+		return false, err
+	}
+	pc, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers()})
+	if err != nil {
+		return false, err
+	}
+	defer pc.Close()
+	if _, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		return false, err
+	}
+	// Los PLI llegan al origen, que pide el keyframe a su publicador
+	requestKeyframe := func(ssrc uint32) {
+		if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil {
+			log.Debug("Error enviando PLI al origen", "ssrc", ssrc, "err", err)
+		}
+	}
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		go HandleTrack(track, receiver, p.code, streamID, requestKeyframe)
+		go sendInitialPLIs(pc, uint32(track.SSRC()), 5, 300*time.Millisecond)
+	})
+	connected := make(chan struct{})
+	closed := make(chan struct{})
+	var connectedOnce, closedOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectedOnce.Do(func() { close(connected) })
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			closedOnce.Do(func() { close(closed) })
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return false, err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(offer); err != nil {
+		return false, err
+	}
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	answer, err := p.subscribe(ctx, *pc.LocalDescription())
+	if err != nil {
+		return false, err
+	}
+
+	// El stream local existe desde que el origen acepta la suscripción
+	if _, err = channel.AttachStream(streamID); err != nil {
+		return false, err
+	}
+	defer func() {
+		session.unregister()
+		removeLayerStream(p.code, streamID)
+		if err := channel.StopStream(streamID); err != nil {
+			_ = channel.RemoveStream(streamID)
+		}
+	}()
+	if err = channel.AssociateStreamPeerConnection(streamID, pc); err != nil {
+		return false, err
+	}
+	if err = pc.SetRemoteDescription(answer); err != nil {
+		return false, err
+	}
+	session.register(pc)
+	if err = channel.StartStream(streamID); err != nil {
+		return false, err
+	}
+
+	select {
+	case <-connected:
+	case <-closed:
+		return false, errors.New("la conexión ICE con el origen ha fallado")
+	case <-time.After(serverConfig.Edge.IdleTimeout):
+		return false, fmt.Errorf("sin conexión con el origen tras %v", serverConfig.Edge.IdleTimeout)
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	connectedAt := time.Now()
+	p.mu.Lock()
+	p.status.Connected = true
+	p.status.StreamID = streamID
+	p.status.ConnectedSince = connectedAt
+	p.status.Connects++
+	p.mu.Unlock()
+	log.Info("Conectado al origen", "stream_id", streamID)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-closed:
+			return true, errors.New("conexión con el origen cerrada")
+		case <-ticker.C:
+			last := session.lastPacket()
+			if last.IsZero() {
+				last = connectedAt
+			}
+			if time.Since(last) > serverConfig.Edge.IdleTimeout {
+				return true, fmt.Errorf("sin vídeo del origen durante %v", serverConfig.Edge.IdleTimeout)
+			}
+		}
+	}
+}
+
+// subscribe envía la oferta al /watchrtc del origen y devuelve su answer. El
+// viewer registrado en el origen se reutiliza entre reconexiones; si el
+// origen ya no lo conoce (p. ej. tras reiniciarse) se registra otro.
+func (p *edgePuller) subscribe(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	p.mu.Lock()
+	clientID, token := p.clientID, p.token
+	p.mu.Unlock()
+	fresh := clientID == 0
+	if fresh {
+		id, tok, err := p.register(ctx)
+		if err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+		clientID, token = id, tok
+	}
+	answer, err := p.watch(ctx, clientID, token, offer)
+	var oe *originError
+	if err != nil && !fresh && errors.As(err, &oe) && (oe.status == http.StatusBadRequest || oe.status == http.StatusForbidden || oe.status == http.StatusNotFound) {
+		id, tok, rerr := p.register(ctx)
+		if rerr != nil {
+			return webrtc.SessionDescription{}, rerr
+		}
+		clientID, token = id, tok
+		answer, err = p.watch(ctx, clientID, token, offer)
+	}
+	p.mu.Lock()
+	p.clientID, p.token = clientID, token
+	p.mu.Unlock()
+	return answer, err
+}
+
+// register da de alta este servidor como viewer del canal en el origen y
+// devuelve su clientID y su token de cliente
+func (p *edgePuller) register(ctx context.Context) (int, string, error) {
+	q := url.Values{"code": {p.source.Code}, "edge": {"1"}}
+	if p.source.Share != "" {
+		q.Set("share", p.source.Share)
+	}
+	body, header, err := p.originRequest(ctx, http.MethodGet, "/register?"+q.Encode(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	clientID, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		return 0, "", fmt.Errorf("clientID del origen inválido: %q", body)
+	}
+	return clientID, header.Get("X-Client-Token"), nil
+}
+
+// watch negocia la sesión WebRTC de viewer con el origen
+func (p *edgePuller) watch(ctx context.Context, clientID int, token string, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	payload, err := json.Marshal(SDPMessage{Type: offer.Type.String(), SDP: offer.SDP})
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	q := url.Values{"code": {p.source.Code}, "clientID": {strconv.Itoa(clientID)}, "token": {token}}
+	body, _, err := p.originRequest(ctx, http.MethodPost, "/watchrtc?"+q.Encode(), payload)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	var answer SDPMessage
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("answer del origen inválido: %w", err)
+	}
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}, nil
+}
+
+// originRequest hace una petición al origen y devuelve el cuerpo y las
+// cabeceras de la respuesta
+func (p *edgePuller) originRequest(ctx context.Context, method, path string, payload []byte) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.source.Origin+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := edgeClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, &originError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return body, resp.Header, nil
+}
+
+// snapshot devuelve una copia del estado
+func (p *edgePuller) snapshot() edgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// edgeAdmin comprueba el token de administración que exigen POST y DELETE
+// /edge (cabecera X-Admin-Token); sin edge.admin_token están desactivados
+func edgeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := serverConfig.Edge.AdminToken
+	if token == "" {
+		http.Error(w, "POST y DELETE /edge están desactivados: configura edge.admin_token", http.StatusForbidden)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+		http.Error(w, "Token de administración incorrecto", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// edgeOriginAllowed indica si POST /edge puede tirar de origin: sólo los
+// orígenes de edge.allowed_origins o de edge.channels
+func edgeOriginAllowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	same := func(allowed string) bool {
+		a, err := url.Parse(allowed)
+		return err == nil && strings.EqualFold(a.Scheme, u.Scheme) && strings.EqualFold(a.Host, u.Host)
+	}
+	for _, allowed := range serverConfig.Edge.AllowedOrigins {
+		if same(allowed) {
+			return true
+		}
+	}
+	for _, ch := range serverConfig.Edge.Channels {
+		if same(ch.Origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// edgeHandler gestiona los canales recibidos de otros servidores:
+//
+//	GET    /edge                                            estado de todos
+//	POST   /edge?code=OFI2&origin=https://origen:8080&remoteCode=ABC[&share=<token>]
+//	DELETE /edge?code=OFI2
+//
+// POST y DELETE exigen el token de edge.admin_token.
+func edgeHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !edgeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		edgePullers.Lock()
+		pullers := make([]*edgePuller, 0, len(edgePullers.m))
+		for _, p := range edgePullers.m {
+			pullers = append(pullers, p)
+		}
+		edgePullers.Unlock()
+		statuses := make([]edgeStatus, 0, len(pullers))
+		for _, p := range pullers {
+			if code == "" || p.code == code {
+				statuses = append(statuses, p.snapshot())
+			}
+		}
+		slices.SortFunc(statuses, func(a, b edgeStatus) int { return strings.Compare(a.Code, b.Code) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	case http.MethodPost:
+		origin := strings.TrimSuffix(r.URL.Query().Get("origin"), "/")
+		if code == "" {
+			http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+			return
+		}
+		if err := cfg.ValidateOrigin(origin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !edgeOriginAllowed(origin) {
+			http.Error(w, "Origen no permitido: añádelo a edge.allowed_origins", http.StatusForbidden)
+			return
+		}
+		remote := r.URL.Query().Get("remoteCode")
+		if remote == "" {
+			remote = code
+		}
+		persist := r.URL.Query().Get("persist") == "1"
+		if _, err := storeDB(); persist && err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := startEdgePull(code, relay.RemoteSource{Origin: origin, Code: remote, Share: r.URL.Query().Get("share")}); err != nil {
+			if errors.Is(err, errEdgeExists) || errors.Is(err, errEdgeLocalPublisher) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeRelayError(w, "", err)
+			return
+		}
+		// ?persist=1 guarda el canal para volver a tirar del origen tras un reinicio
+		if persist {
+			key, err := persistChannel(code, r.URL.Query().Get("name"))
+			if err != nil {
+				logging.For("store").Error("No se pudo guardar el canal", "channel", code, "err", err)
+				http.Error(w, "No se pudo guardar el canal: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if key != "" {
+				w.Header().Set("X-Operator-Key", key)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(edgeStatus{Code: code, Origin: origin, RemoteCode: remote})
+	case http.MethodDelete:
+		// Un edge guardado dejaría de recibirse sólo hasta el siguiente reinicio
+		if rec, found, err := loadChannelRecord(code); err == nil && found && rec.Origin != "" {
+			if _, err := forgetChannel(code); err != nil {
+				http.Error(w, "No se pudo borrar el canal guardado: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if !stopEdgePull(code) {
+			http.Error(w, "El canal no se recibe de ningún origen", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,215 @@
+package webrtc
+
+import (
+	"errors"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+// frameSize obtiene la resolución de un payload RTP que lleva la cabecera de
+// un keyframe: VP8, VP9 con estructura de escalabilidad o H.264 con SPS.
+// Para el resto (AV1, frames intermedios) devuelve ok = false.
+func frameSize(mimeType string, payload []byte) (width, height int, ok bool) {
+	switch mimeType {
+	case webrtc.MimeTypeVP8:
+		vp8 := &codecs.VP8Packet{}
+		if _, err := vp8.Unmarshal(payload); err != nil || vp8.S != 1 || vp8.PID != 0 {
+			return 0, 0, false
+		}
+		// Cabecera de keyframe: 3 bytes de frame tag, código de inicio 9d 01 2a y
+		// ancho y alto de 14 bits en little endian
+		p := vp8.Payload
+		if len(p) < 10 || p[0]&0x01 != 0 || p[3] != 0x9d || p[4] != 0x01 || p[5] != 0x2a {
+			return 0, 0, false
+		}
+		return int(p[6]) | int(p[7]&0x3f)<<8, int(p[8]) | int(p[9]&0x3f)<<8, true
+	case webrtc.MimeTypeVP9:
+		vp9 := &codecs.VP9Packet{}
+		if _, err := vp9.Unmarshal(payload); err != nil || len(vp9.Width) == 0 {
+			return 0, 0, false
+		}
+		// Con varias capas espaciales la última es la de mayor resolución
+		last := len(vp9.Width) - 1
+		return int(vp9.Width[last]), int(vp9.Height[last]), true
+	case webrtc.MimeTypeH264:
+		if sps := h264FindSPS(payload); sps != nil {
+			w, h, err := h264SPSSize(sps)
+			return w, h, err == nil
+		}
+	}
+	return 0, 0, false
+}
+
+// h264FindSPS devuelve la NALU SPS de un payload RTP H.264 (single o STAP-A)
+func h264FindSPS(payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	switch payload[0] & 0x1F {
+	case 7:
+		return payload
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if size == 0 || i+size > len(payload) {
+				break
+			}
+			if payload[i]&0x1F == 7 {
+				return payload[i : i+size]
+			}
+			i += size
+		}
+	}
+	return nil
+}
+
+// bitReader lee los campos de un SPS bit a bit, incluidos los Exp-Golomb
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+var errShortSPS = errors.New("SPS truncado")
+
+func (r *bitReader) bit() uint {
+	if r.pos >= len(r.data)*8 {
+		r.err = errShortSPS
+		return 0
+	}
+	b := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint(b)
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+// ue lee un entero sin signo Exp-Golomb
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bit() == 0 && r.err == nil {
+		zeros++
+		if zeros > 31 {
+			r.err = errShortSPS
+			return 0
+		}
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se lee un entero con signo Exp-Golomb
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+// h264SPSSize calcula la resolución visible a partir de un SPS (ITU-T H.264 7.3.2.1.1)
+func h264SPSSize(nal []byte) (int, int, error) {
+	if len(nal) < 2 {
+		return 0, 0, errShortSPS
+	}
+	// Quitar los bytes de prevención de emulación (00 00 03)
+	rbsp := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal[1:] {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	r := &bitReader{data: rbsp}
+	profile := r.bits(8)
+	r.bits(16) // constraint flags y level_idc
+	r.ue()     // seq_parameter_set_id
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit()
+		r.se()
+		r.se()
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMaps := r.ue() + 1
+	frameMbsOnly := r.bit()
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.bit() == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	// Unidades de recorte según el submuestreo de croma (tabla 6-1)
+	cropX, cropY := uint(1), 2-frameMbsOnly
+	switch chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+	width := widthMbs*16 - (cropLeft+cropRight)*cropX
+	height := (2-frameMbsOnly)*heightMaps*16 - (cropTop+cropBottom)*cropY
+	return int(width), int(height), nil
+}
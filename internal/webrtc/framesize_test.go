@@ -0,0 +1,135 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// spsWriter escribe los campos de un SPS bit a bit, incluidos los Exp-Golomb
+type spsWriter struct {
+	bits []byte
+}
+
+func (w *spsWriter) u(n int, v uint) {
+	for i := n - 1; i >= 0; i-- {
+		w.bits = append(w.bits, byte(v>>i&1))
+	}
+}
+
+func (w *spsWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.u(n, 0)
+	w.u(n+1, v)
+}
+
+// nal devuelve la NALU con su cabecera, el bit de parada y los bytes de
+// prevención de emulación
+func (w *spsWriter) nal() []byte {
+	bits := append(w.bits, 1)
+	for len(bits)%8 != 0 {
+		bits = append(bits, 0)
+	}
+	out := []byte{0x67}
+	zeros := 0
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b = b<<1 | bit
+		}
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// testSPS construye un SPS con frame_mbs_only y el recorte indicado (en unidades de croma 4:2:0)
+func testSPS(profile, widthMbs, heightMbs, cropRight, cropBottom uint) []byte {
+	w := &spsWriter{}
+	w.u(8, profile)
+	w.u(16, 0x001f) // constraint flags y level_idc
+	w.ue(0)         // seq_parameter_set_id
+	if profile == 100 {
+		w.ue(1)   // chroma_format_idc 4:2:0
+		w.ue(0)   // bit_depth_luma_minus8
+		w.ue(0)   // bit_depth_chroma_minus8
+		w.u(1, 0) // qpprime_y_zero_transform_bypass_flag
+		w.u(1, 0) // seq_scaling_matrix_present_flag
+	}
+	w.ue(0)   // log2_max_frame_num_minus4
+	w.ue(2)   // pic_order_cnt_type
+	w.ue(1)   // max_num_ref_frames
+	w.u(1, 0) // gaps_in_frame_num_value_allowed_flag
+	w.ue(widthMbs - 1)
+	w.ue(heightMbs - 1)
+	w.u(1, 1) // frame_mbs_only_flag
+	w.u(1, 1) // direct_8x8_inference_flag
+	if cropRight > 0 || cropBottom > 0 {
+		w.u(1, 1)
+		w.ue(0)
+		w.ue(cropRight)
+		w.ue(0)
+		w.ue(cropBottom)
+	} else {
+		w.u(1, 0)
+	}
+	w.u(1, 0) // vui_parameters_present_flag
+	return w.nal()
+}
+
+func TestH264SPSSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		nal     []byte
+		wantW   int
+		wantH   int
+		wantErr bool
+	}{
+		{"baseline 640x480", testSPS(66, 40, 30, 0, 0), 640, 480, false},
+		{"high 1920x1080 con recorte", testSPS(100, 120, 68, 0, 4), 1920, 1080, false},
+		{"baseline 1280x720", testSPS(66, 80, 45, 0, 0), 1280, 720, false},
+		{"recorte lateral", testSPS(66, 20, 15, 4, 0), 312, 240, false},
+		{"truncado", testSPS(66, 40, 30, 0, 0)[:5], 0, 0, true},
+		{"sólo cabecera", []byte{0x67}, 0, 0, true},
+		{"vacío", nil, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, err := h264SPSSize(tt.nal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("h264SPSSize = %v, error esperado: %v", err, tt.wantErr)
+			}
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("h264SPSSize = %dx%d, se esperaba %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestFrameSizeH264STAPA(t *testing.T) {
+	sps := testSPS(66, 40, 30, 0, 0)
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	payload := []byte{24}
+	for _, nal := range [][]byte{sps, pps} {
+		payload = append(payload, byte(len(nal)>>8), byte(len(nal)))
+		payload = append(payload, nal...)
+	}
+	if w, h, ok := frameSize(webrtc.MimeTypeH264, payload); !ok || w != 640 || h != 480 {
+		t.Errorf("frameSize = %dx%d %v, se esperaba 640x480", w, h, ok)
+	}
+	if _, _, ok := frameSize(webrtc.MimeTypeH264, pps); ok {
+		t.Error("frameSize encontró resolución en un paquete sin SPS")
+	}
+}
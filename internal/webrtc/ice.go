@@ -0,0 +1,68 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"net/http"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/turnserver"
+)
+
+// turnServer es el servidor TURN embebido (nil si está desactivado)
+var turnServer *turnserver.Server
+
+// iceServerJSON sigue el formato de RTCIceServer del navegador
+type iceServerJSON struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// iceConfigResponse es la respuesta de /ice, utilizable directamente como RTCConfiguration
+type iceConfigResponse struct {
+	ICEServers []iceServerJSON `json:"iceServers"`
+	TTL        int             `json:"ttl,omitempty"`
+}
+
+// iceHandler devuelve los servidores ICE que debe usar el navegador. Si el
+// servidor TURN embebido está activo se emiten credenciales efímeras nuevas.
+// Sólo se entregan para un canal existente (?code=): los viewers las piden
+// ya registrados, con su clientID y token; el publicador, que las pide antes
+// de conectar, sólo necesita que el canal exista.
+func iceHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("clientID") {
+		if _, _, ok := requestViewer(w, r); !ok {
+			return
+		}
+	} else if _, exists := connectionManager.ValidateChannel(code); !exists {
+		http.Error(w, "Canal no encontrado", http.StatusNotFound)
+		return
+	}
+	resp := iceConfigResponse{}
+	for _, s := range serverConfig.ICEServers {
+		resp.ICEServers = append(resp.ICEServers, toICEServerJSON(s))
+	}
+	if turnServer != nil {
+		creds, err := turnServer.Credentials(code)
+		if err != nil {
+			logging.For("turn").Error("Error generando credenciales", "err", err)
+			http.Error(w, "Error generando credenciales TURN", http.StatusInternalServerError)
+			return
+		}
+		resp.ICEServers = append(resp.ICEServers, toICEServerJSON(creds))
+		resp.TTL = int(turnServer.TTL().Seconds())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func toICEServerJSON(s cfg.ICEServer) iceServerJSON {
+	return iceServerJSON{URLs: s.URLs, Username: s.Username, Credential: s.Credential}
+}
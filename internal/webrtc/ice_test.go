@@ -0,0 +1,45 @@
+package webrtc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// /ice sólo responde para canales existentes y, si se indica clientID, a
+// viewers registrados con su token
+func TestICEHandlerAccess(t *testing.T) {
+	const code = "TEST-ICE"
+	if err := connectionManager.CreateChannel(code); err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.RemoveChannel(code)
+	channel, _ := connectionManager.ValidateChannel(code)
+	viewer, err := channel.AddClient(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(viewer.ID)
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"sin código", "", http.StatusBadRequest},
+		{"canal inexistente", "?code=NO-EXISTE", http.StatusNotFound},
+		{"publicador de un canal existente", "?code=" + code, http.StatusOK},
+		{"viewer sin token", "?code=" + code + "&clientID=" + id, http.StatusForbidden},
+		{"viewer no registrado", "?code=" + code + "&clientID=99&token=" + viewer.Token, http.StatusForbidden},
+		{"viewer con token", "?code=" + code + "&clientID=" + id + "&token=" + viewer.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			iceHandler(rec, httptest.NewRequest("GET", "/ice"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET /ice%s = %d, se esperaba %d", tt.query, rec.Code, tt.want)
+			}
+		})
+	}
+}
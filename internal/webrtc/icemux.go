@@ -0,0 +1,173 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// Muxes ICE compartidos por todos los PeerConnections (nil si están desactivados)
+var (
+	iceUDPMux ice.UDPMux
+	iceTCPMux ice.TCPMux
+)
+
+// initICEMux abre los puertos UDP/TCP únicos de ICE según la configuración.
+// Debe llamarse una sola vez al arrancar el servidor.
+func initICEMux() error {
+	muxCfg := serverConfig.ICEMux
+	checkContainerNAT()
+	if muxCfg.UDPPort != 0 {
+		udpMux, err := ice.NewMultiUDPMuxFromPort(muxCfg.UDPPort,
+			ice.UDPMuxFromPortWithInterfaceFilter(interfaceFilter),
+			ice.UDPMuxFromPortWithIPFilter(ipFilter),
+			ice.UDPMuxFromPortWithNetworks(ice.NetworkTypeUDP4, ice.NetworkTypeUDP6),
+		)
+		if err != nil {
+			return fmt.Errorf("no se pudo abrir el mux UDP de ICE en el puerto %d: %w", muxCfg.UDPPort, err)
+		}
+		iceUDPMux = udpMux
+		logging.For("ice").Info("Mux UDP compartido", "port", muxCfg.UDPPort)
+	}
+	if muxCfg.TCPPort != 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: muxCfg.TCPPort})
+		if err != nil {
+			return fmt.Errorf("no se pudo abrir el mux TCP de ICE en el puerto %d: %w", muxCfg.TCPPort, err)
+		}
+		iceTCPMux = webrtc.NewICETCPMux(nil, listener, 8)
+		logging.For("ice").Info("Mux TCP compartido", "port", muxCfg.TCPPort)
+	}
+	return nil
+}
+
+// dockerBridge es el rango del que Docker asigna por defecto las IPs de sus redes bridge
+var dockerBridge = &net.IPNet{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)}
+
+// checkContainerNAT avisa si el mux de ICE corre en un contenedor con red
+// bridge sin nat_1to1_ips: sus únicas IPs parecen las internas de Docker, que
+// ningún cliente alcanza directamente. No es un error porque STUN/TURN o una red
+// privada propia en 172.16.0.0/12 pueden funcionar igual.
+func checkContainerNAT() {
+	muxCfg := serverConfig.ICEMux
+	if muxCfg.UDPPort == 0 && muxCfg.TCPPort == 0 {
+		return
+	}
+	if len(muxCfg.NAT1To1IPs) > 0 || !inContainer() {
+		return
+	}
+	if ips := candidateIPs(); onlyBridgeIPs(ips) {
+		logging.For("ice").Warn("Los candidatos ICE sólo llevan IPs internas del contenedor; si los clientes no conectan define GOPION_NAT_1TO1_IPS con la IP del host, usa --network host o STUN/TURN", "ips", ips)
+	}
+}
+
+// inContainer indica si el servidor corre dentro de un contenedor Docker o Podman
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateIPs devuelve las IPs locales que ICE anunciaría como candidatos host
+func candidateIPs() []net.IP {
+	var ips []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || !interfaceFilter(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() && ipFilter(ipNet.IP) {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	return ips
+}
+
+// onlyBridgeIPs indica si todas las IPs (y al menos una) son de redes bridge de Docker
+func onlyBridgeIPs(ips []net.IP) bool {
+	for _, ip := range ips {
+		if !dockerBridge.Contains(ip) {
+			return false
+		}
+	}
+	return len(ips) > 0
+}
+
+// closeICEMux cierra los sockets compartidos de ICE
+func closeICEMux() {
+	if iceUDPMux != nil {
+		_ = iceUDPMux.Close()
+	}
+	if iceTCPMux != nil {
+		_ = iceTCPMux.Close()
+	}
+}
+
+// applyICENetwork configura en el SettingEngine los muxes, la NAT 1:1 y los filtros de red
+func applyICENetwork(se *webrtc.SettingEngine) {
+	muxCfg := serverConfig.ICEMux
+	if iceUDPMux != nil {
+		se.SetICEUDPMux(iceUDPMux)
+	}
+	networkTypes := []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6}
+	if iceTCPMux != nil {
+		se.SetICETCPMux(iceTCPMux)
+		networkTypes = append(networkTypes, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6)
+	}
+	se.SetNetworkTypes(networkTypes)
+	if len(muxCfg.NAT1To1IPs) > 0 {
+		candidateType := webrtc.ICECandidateTypeHost
+		if muxCfg.NAT1To1CandidateType == "srflx" {
+			candidateType = webrtc.ICECandidateTypeSrflx
+		}
+		se.SetNAT1To1IPs(muxCfg.NAT1To1IPs, candidateType)
+	}
+	se.SetInterfaceFilter(interfaceFilter)
+	se.SetIPFilter(ipFilter)
+}
+
+// interfaceFilter decide si una interfaz de red puede usarse para candidatos ICE
+func interfaceFilter(name string) bool {
+	muxCfg := serverConfig.ICEMux
+	if len(muxCfg.Interfaces) > 0 && !matchAny(muxCfg.Interfaces, name) {
+		return false
+	}
+	return !matchAny(muxCfg.ExcludeInterfaces, name)
+}
+
+// ipFilter descarta las direcciones locales incluidas en los CIDR excluidos
+func ipFilter(ip net.IP) bool {
+	for _, cidr := range serverConfig.ICEMux.ExcludeCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny comprueba si name coincide con alguno de los patrones glob
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package webrtc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOnlyBridgeIPs(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+		want bool
+	}{
+		{"bridge por defecto", []string{"172.17.0.2"}, true},
+		{"red de compose", []string{"172.18.0.5", "172.31.255.1"}, true},
+		{"red del host", []string{"172.17.0.1", "192.168.1.20"}, false},
+		{"IPv6 global", []string{"172.17.0.2", "2001:db8::2"}, false},
+		{"sin IPs", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, s := range tt.ips {
+				ips = append(ips, net.ParseIP(s))
+			}
+			if got := onlyBridgeIPs(ips); got != tt.want {
+				t.Errorf("onlyBridgeIPs(%v) = %v, se esperaba %v", tt.ips, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// maxJitterPackets limita los paquetes retenidos aunque no venza la latencia
+const maxJitterPackets = 2048
+
+// registerPublisherInterceptors equivale a webrtc.RegisterDefaultInterceptors pero
+// configura el generador de NACKs según la configuración del jitter buffer y
+// negocia REMB para limitar el bitrate del publicador (ver bitrate.go).
+func registerPublisherInterceptors(m *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	generator, err := nack.NewGeneratorInterceptor(
+		nack.GeneratorSize(uint16(serverConfig.Jitter.NACKBufferSize)),
+		nack.GeneratorInterval(serverConfig.Jitter.NACKInterval),
+	)
+	if err != nil {
+		return err
+	}
+	responder, err := nack.NewResponderInterceptor()
+	if err != nil {
+		return err
+	}
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	if serverConfig.Congestion.REMB {
+		// El publicador toma el REMB como tope de su estimación de ancho de banda
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBGoogREMB}, webrtc.RTPCodecTypeVideo)
+	}
+	registry.Add(responder)
+	registry.Add(generator)
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return err
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(m); err != nil {
+		return err
+	}
+	return webrtc.ConfigureTWCCSender(m, registry)
+}
+
+// jitterStats son las estadísticas de un jitter buffer
+type jitterStats struct {
+	Received   uint64 `json:"received"`
+	Lost       uint64 `json:"lost"`
+	Late       uint64 `json:"late"`
+	Duplicates uint64 `json:"duplicates"`
+	Reordered  uint64 `json:"reordered"`
+	Buffered   int    `json:"buffered"`
+}
+
+type bufferedPacket struct {
+	pkt     *rtp.Packet
+	arrival time.Time
+}
+
+// jitterBuffer reordena los paquetes RTP de un track y los entrega en orden de
+// secuencia. Un hueco se espera como máximo latency (tiempo durante el que el
+// NACK/RTX puede recuperar el paquete); después se da por perdido.
+//
+// emit se llama sin mu tomado, para que /stats/jitter no espere al
+// transcodificador; emitMu mantiene el orden de entrega entre push y run.
+type jitterBuffer struct {
+	emitMu  sync.Mutex
+	mu      sync.Mutex
+	latency time.Duration
+	emit    func(*rtp.Packet)
+	packets map[uint16]bufferedPacket
+	nextSeq uint16
+	highest uint16
+	started bool
+	stats   jitterStats
+	done    chan struct{}
+}
+
+// newJitterBuffer crea un jitter buffer que entrega los paquetes ordenados a emit.
+// Con latency 0 los paquetes se entregan según llegan y sólo se recogen estadísticas.
+func newJitterBuffer(latency time.Duration, emit func(*rtp.Packet)) *jitterBuffer {
+	jb := &jitterBuffer{
+		latency: latency,
+		emit:    emit,
+		packets: make(map[uint16]bufferedPacket),
+		done:    make(chan struct{}),
+	}
+	if latency > 0 {
+		go jb.run()
+	}
+	return jb
+}
+
+// seqBefore indica si a es anterior a b teniendo en cuenta el wraparound de 16 bits
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// seqDistance devuelve la distancia entre dos números de secuencia en cualquier sentido
+func seqDistance(a, b uint16) int {
+	d := int(int16(a - b))
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// push añade un paquete. Con latency > 0 el paquete se copia, por lo que el
+// llamador puede reutilizar su buffer; con latency 0 se entrega a emit antes
+// de volver, sin copiar.
+func (jb *jitterBuffer) push(pkt *rtp.Packet) {
+	jb.emitMu.Lock()
+	defer jb.emitMu.Unlock()
+	jb.mu.Lock()
+	jb.stats.Received++
+	seq := pkt.SequenceNumber
+	var ready []*rtp.Packet
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = seq
+		jb.highest = seq
+	} else if seqDistance(seq, jb.nextSeq) > maxJitterPackets {
+		// Un salto así no es reordenación sino un reinicio de la secuencia (p. ej.
+		// el publicador reconecta con el mismo SSRC): se entrega lo retenido y se
+		// empieza de nuevo en seq en vez de descartar todo como tardío.
+		ready = jb.flush()
+		jb.nextSeq = seq
+		jb.highest = seq
+	}
+	if seqBefore(seq, jb.highest) {
+		jb.stats.Reordered++
+	} else {
+		jb.highest = seq
+	}
+	if jb.latency <= 0 {
+		late := seqBefore(seq, jb.nextSeq)
+		if late {
+			jb.stats.Late++
+		} else {
+			jb.stats.Lost += uint64(uint16(seq - jb.nextSeq))
+			jb.nextSeq = seq + 1
+		}
+		jb.mu.Unlock()
+		jb.emitAll(ready)
+		jb.emit(pkt)
+		return
+	}
+	switch {
+	case seqBefore(seq, jb.nextSeq):
+		// Ya se entregó o se dio por perdido: llega tarde
+		jb.stats.Late++
+	case jb.hasPacket(seq):
+		jb.stats.Duplicates++
+	default:
+		jb.packets[seq] = bufferedPacket{pkt: pkt.Clone(), arrival: time.Now()}
+		ready = jb.release(time.Now(), ready)
+	}
+	jb.mu.Unlock()
+	jb.emitAll(ready)
+}
+
+// hasPacket indica si seq ya está retenido. Debe llamarse con el mutex tomado.
+func (jb *jitterBuffer) hasPacket(seq uint16) bool {
+	_, ok := jb.packets[seq]
+	return ok
+}
+
+// emitAll entrega los paquetes en orden. Debe llamarse con emitMu tomado y sin mu.
+func (jb *jitterBuffer) emitAll(pkts []*rtp.Packet) {
+	for _, pkt := range pkts {
+		jb.emit(pkt)
+	}
+}
+
+// release añade a out los paquetes consecutivos y salta los huecos cuya espera
+// ha vencido. Debe llamarse con el mutex tomado.
+func (jb *jitterBuffer) release(now time.Time, out []*rtp.Packet) []*rtp.Packet {
+	for len(jb.packets) > 0 {
+		if bp, ok := jb.packets[jb.nextSeq]; ok {
+			delete(jb.packets, jb.nextSeq)
+			jb.nextSeq++
+			out = append(out, bp.pkt)
+			continue
+		}
+		oldestSeq, oldest := jb.oldest()
+		if now.Sub(oldest.arrival) < jb.latency && len(jb.packets) < maxJitterPackets {
+			return out
+		}
+		jb.stats.Lost += uint64(uint16(oldestSeq - jb.nextSeq))
+		jb.nextSeq = oldestSeq
+	}
+	return out
+}
+
+// flush vacía el buffer devolviendo los paquetes retenidos en orden de
+// secuencia, sin contar los huecos como pérdidas. Debe llamarse con el mutex tomado.
+func (jb *jitterBuffer) flush() []*rtp.Packet {
+	out := make([]*rtp.Packet, 0, len(jb.packets))
+	for len(jb.packets) > 0 {
+		seq, bp := jb.oldest()
+		delete(jb.packets, seq)
+		out = append(out, bp.pkt)
+	}
+	return out
+}
+
+// oldest devuelve el paquete retenido con menor número de secuencia
+func (jb *jitterBuffer) oldest() (uint16, bufferedPacket) {
+	var bestSeq uint16
+	var best bufferedPacket
+	first := true
+	for seq, bp := range jb.packets {
+		if first || seqBefore(seq, bestSeq) {
+			bestSeq, best, first = seq, bp, false
+		}
+	}
+	return bestSeq, best
+}
+
+// run libera periódicamente los huecos vencidos aunque no lleguen paquetes nuevos
+func (jb *jitterBuffer) run() {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-jb.done:
+			return
+		case now := <-ticker.C:
+			jb.emitMu.Lock()
+			jb.mu.Lock()
+			ready := jb.release(now, nil)
+			jb.mu.Unlock()
+			jb.emitAll(ready)
+			jb.emitMu.Unlock()
+		}
+	}
+}
+
+// close detiene el buffer descartando los paquetes pendientes
+func (jb *jitterBuffer) close() {
+	close(jb.done)
+}
+
+// snapshot devuelve una copia de las estadísticas
+func (jb *jitterBuffer) snapshot() jitterStats {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	s := jb.stats
+	s.Buffered = len(jb.packets)
+	return s
+}
+
+// trackJitter identifica el jitter buffer de un track de un stream
+type trackJitter struct {
+	Code     string      `json:"code"`
+	StreamID int         `json:"streamId"`
+	Kind     string      `json:"kind"`
+	RID      string      `json:"rid,omitempty"`
+	Stats    jitterStats `json:"stats"`
+	buffer   *jitterBuffer
+}
+
+// jitterBuffers registra los jitter buffers activos por track
+var jitterBuffers = struct {
+	sync.Mutex
+	m map[string]*trackJitter
+}{m: make(map[string]*trackJitter)}
+
+func jitterKey(code string, streamID int, kind, rid string) string {
+	return fmt.Sprintf("%s/%d/%s/%s", code, streamID, kind, rid)
+}
+
+// registerJitterBuffer publica las estadísticas del buffer de un track
+func registerJitterBuffer(code string, streamID int, kind, rid string, jb *jitterBuffer) {
+	jitterBuffers.Lock()
+	defer jitterBuffers.Unlock()
+	jitterBuffers.m[jitterKey(code, streamID, kind, rid)] = &trackJitter{Code: code, StreamID: streamID, Kind: kind, RID: rid, buffer: jb}
+}
+
+// unregisterJitterBuffer elimina el buffer del registro y registra sus estadísticas finales
+func unregisterJitterBuffer(code string, streamID int, kind, rid string) {
+	jitterBuffers.Lock()
+	key := jitterKey(code, streamID, kind, rid)
+	tj, ok := jitterBuffers.m[key]
+	delete(jitterBuffers.m, key)
+	jitterBuffers.Unlock()
+	if ok {
+		tj.buffer.close()
+		s := tj.buffer.snapshot()
+		logging.For("jitter").Info("Track finalizado", "channel", code, "stream_id", streamID, "kind", kind, "rid", rid,
+			"received", s.Received, "lost", s.Lost, "late", s.Late, "duplicates", s.Duplicates, "reordered", s.Reordered)
+	}
+}
+
+// jitterStatsHandler devuelve en JSON las estadísticas de pérdida y paquetes tardíos de un canal
+func jitterStatsHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	jitterBuffers.Lock()
+	out := make([]trackJitter, 0)
+	for _, tj := range jitterBuffers.m {
+		if tj.Code == code {
+			out = append(out, *tj)
+		}
+	}
+	jitterBuffers.Unlock()
+	for i := range out {
+		out[i].Stats = out[i].buffer.snapshot()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return jitterKey(out[i].Code, out[i].StreamID, out[i].Kind, out[i].RID) < jitterKey(out[j].Code, out[j].StreamID, out[j].Kind, out[j].RID)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
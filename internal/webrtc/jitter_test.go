@@ -0,0 +1,138 @@
+package webrtc
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// jitterRecorder guarda los números de secuencia entregados por un jitter buffer
+type jitterRecorder struct {
+	mu   sync.Mutex
+	seqs []uint16
+}
+
+func (r *jitterRecorder) emit(pkt *rtp.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seqs = append(r.seqs, pkt.SequenceNumber)
+}
+
+func (r *jitterRecorder) got() []uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.seqs)
+}
+
+func TestJitterBuffer(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency time.Duration
+		input   []uint16
+		want    []uint16
+		stats   jitterStats
+	}{
+		{
+			name:    "en orden",
+			latency: 50 * time.Millisecond,
+			input:   []uint16{1, 2, 3},
+			want:    []uint16{1, 2, 3},
+			stats:   jitterStats{Received: 3},
+		},
+		{
+			name:    "reordenados",
+			latency: 50 * time.Millisecond,
+			input:   []uint16{10, 12, 11, 13},
+			want:    []uint16{10, 11, 12, 13},
+			stats:   jitterStats{Received: 4, Reordered: 1},
+		},
+		{
+			name:    "hueco vencido",
+			latency: 20 * time.Millisecond,
+			input:   []uint16{1, 2, 4, 5},
+			want:    []uint16{1, 2, 4, 5},
+			stats:   jitterStats{Received: 4, Lost: 1},
+		},
+		{
+			name:    "duplicado y tardío",
+			latency: 20 * time.Millisecond,
+			input:   []uint16{1, 3, 3, 4},
+			want:    []uint16{1, 3, 4},
+			stats:   jitterStats{Received: 4, Lost: 1, Duplicates: 1},
+		},
+		{
+			name:    "wraparound",
+			latency: 50 * time.Millisecond,
+			input:   []uint16{65534, 0, 65535, 1},
+			want:    []uint16{65534, 65535, 0, 1},
+			stats:   jitterStats{Received: 4, Reordered: 1},
+		},
+		{
+			name:    "reinicio de secuencia",
+			latency: 50 * time.Millisecond,
+			input:   []uint16{100, 101, 40000, 40001},
+			want:    []uint16{100, 101, 40000, 40001},
+			stats:   jitterStats{Received: 4},
+		},
+		{
+			name:    "sin latencia",
+			latency: 0,
+			input:   []uint16{1, 3, 2, 4},
+			want:    []uint16{1, 3, 2, 4},
+			stats:   jitterStats{Received: 4, Lost: 1, Late: 1, Reordered: 1},
+		},
+		{
+			name:    "sin latencia tras reinicio",
+			latency: 0,
+			input:   []uint16{5000, 5001, 7, 8},
+			want:    []uint16{5000, 5001, 7, 8},
+			stats:   jitterStats{Received: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &jitterRecorder{}
+			jb := newJitterBuffer(tt.latency, rec.emit)
+			defer jb.close()
+			for _, seq := range tt.input {
+				jb.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+			}
+			deadline := time.Now().Add(time.Second)
+			for len(rec.got()) < len(tt.want) && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if got := rec.got(); !slices.Equal(got, tt.want) {
+				t.Errorf("entregados %v, se esperaban %v", got, tt.want)
+			}
+			if got := jb.snapshot(); got != tt.stats {
+				t.Errorf("estadísticas %+v, se esperaban %+v", got, tt.stats)
+			}
+		})
+	}
+}
+
+// Con latencia el buffer copia el paquete: el llamador puede reutilizar el suyo
+func TestJitterBufferClonesPackets(t *testing.T) {
+	rec := &jitterRecorder{}
+	var payloads [][]byte
+	jb := newJitterBuffer(50*time.Millisecond, func(pkt *rtp.Packet) {
+		rec.emit(pkt)
+		payloads = append(payloads, pkt.Payload)
+	})
+	defer jb.close()
+	pkt := &rtp.Packet{Header: rtp.Header{SequenceNumber: 1}, Payload: []byte{1}}
+	jb.push(pkt)
+	pkt.SequenceNumber, pkt.Payload[0] = 3, 3
+	jb.push(pkt)
+	pkt.SequenceNumber, pkt.Payload[0] = 2, 2
+	jb.push(pkt)
+	if got := rec.got(); !slices.Equal(got, []uint16{1, 2, 3}) {
+		t.Fatalf("entregados %v, se esperaban [1 2 3]", got)
+	}
+	if payloads[2][0] != 3 {
+		t.Errorf("el paquete retenido cambió al reutilizar el buffer del llamador")
+	}
+}
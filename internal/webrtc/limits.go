@@ -0,0 +1,218 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+// Nombres de los límites que se devuelven en el campo "limit" de los errores
+const (
+	limitPerIP      = "per_ip"
+	limitGlobal     = "global"
+	limitChannels   = "channels"
+	limitViewers    = "viewers"
+	limitPublishers = "publishers"
+	limitPipelines  = "pipelines"
+	limitClips      = "clips"
+)
+
+// limitError es el cuerpo JSON de las respuestas 429 y 503
+type limitError struct {
+	Error      string `json:"error"`
+	Limit      string `json:"limit"`
+	RetryAfter int    `json:"retryAfter,omitempty"` // segundos
+}
+
+// limitRejections cuenta las peticiones rechazadas por cada límite (/metrics)
+var limitRejections = struct {
+	sync.Mutex
+	m map[string]uint64
+}{m: make(map[string]uint64)}
+
+// writeLimitError responde con el error JSON de un límite alcanzado
+func writeLimitError(w http.ResponseWriter, status int, limit, msg string, retryAfter time.Duration) {
+	limitRejections.Lock()
+	limitRejections.m[limit]++
+	limitRejections.Unlock()
+	body := limitError{Error: msg, Limit: limit}
+	if retryAfter > 0 {
+		body.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeRelayError responde a un error del relay: los límites de canales,
+// viewers y publicadores con un 503 JSON y el resto como error interno
+func writeRelayError(w http.ResponseWriter, prefix string, err error) {
+	switch {
+	case errors.Is(err, relay.ErrChannelLimit):
+		writeLimitError(w, http.StatusServiceUnavailable, limitChannels, err.Error(), 0)
+	case errors.Is(err, relay.ErrViewerLimit):
+		writeLimitError(w, http.StatusServiceUnavailable, limitViewers, err.Error(), 0)
+	case errors.Is(err, relay.ErrPublisherLimit):
+		writeLimitError(w, http.StatusServiceUnavailable, limitPublishers, err.Error(), 0)
+	default:
+		http.Error(w, prefix+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// rateBucket es un token bucket de peticiones
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take consume un token si hay disponible; si no, devuelve cuánto falta para el siguiente
+func (b *rateBucket) take(now time.Time, l cfg.RateLimit) (bool, time.Duration) {
+	b.tokens = min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimiter limita el ritmo de peticiones por IP y en total
+type rateLimiter struct {
+	mu        sync.Mutex
+	perIP     map[string]*rateBucket
+	global    rateBucket
+	lastPrune time.Time
+}
+
+var signalingLimiter = &rateLimiter{perIP: make(map[string]*rateBucket)}
+
+// allow decide si se atiende una petición de ip; si no, indica el límite
+// alcanzado y cuándo reintentar
+func (l *rateLimiter) allow(ip string, c cfg.LimitsConfig) (bool, string, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Los buckets llenos de nuevo no aportan nada: se olvidan cada minuto
+	if now.Sub(l.lastPrune) > time.Minute {
+		for key, b := range l.perIP {
+			if c.PerIP.Rate <= 0 || now.Sub(b.last).Seconds()*c.PerIP.Rate >= float64(c.PerIP.Burst) {
+				delete(l.perIP, key)
+			}
+		}
+		l.lastPrune = now
+	}
+	if c.PerIP.Rate > 0 {
+		b, ok := l.perIP[ip]
+		if !ok {
+			b = &rateBucket{tokens: float64(c.PerIP.Burst), last: now}
+			l.perIP[ip] = b
+		}
+		if ok, wait := b.take(now, c.PerIP); !ok {
+			return false, limitPerIP, wait
+		}
+	}
+	if c.Global.Rate > 0 {
+		if l.global.last.IsZero() {
+			l.global = rateBucket{tokens: float64(c.Global.Burst), last: now}
+		}
+		if ok, wait := l.global.take(now, c.Global); !ok {
+			return false, limitGlobal, wait
+		}
+	}
+	return true, "", 0
+}
+
+// remoteHost devuelve la IP de la conexión, sin el puerto
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromLoopback indica si la conexión llega desde loopback (túnel
+// ngrok/cloudflared o proxy local), el único origen cuyas cabeceras
+// X-Forwarded-* se creen
+func fromLoopback(r *http.Request) bool {
+	ip := net.ParseIP(remoteHost(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+// clientIP devuelve la IP del cliente. Si la conexión llega desde loopback
+// se usa la última IP de X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if fromLoopback(r) {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if last := strings.TrimSpace(parts[len(parts)-1]); last != "" {
+				return last
+			}
+		}
+	}
+	return host
+}
+
+// allowRequest aplica limits.per_ip y limits.global a una petición; si no se
+// admite responde 429 y devuelve false
+func allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	ip := clientIP(r)
+	if ok, limit, wait := signalingLimiter.allow(ip, serverConfig.Limits); !ok {
+		logging.For("server").Debug("Petición rechazada por límite de ritmo", "ip", ip, "path", r.URL.Path, "limit", limit)
+		writeLimitError(w, http.StatusTooManyRequests, limit, "demasiadas peticiones, reintenta más tarde", wait)
+		return false
+	}
+	return true
+}
+
+// rateLimited aplica limits.per_ip y limits.global a un handler de señalización o de viewers
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowRequest(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// activePipelines cuenta los transcodificadores MJPEG en marcha para limits.max_pipelines
+var activePipelines = struct {
+	sync.Mutex
+	n int
+}{}
+
+// pipelinesFull indica si no se puede arrancar otro transcodificador
+func pipelinesFull() bool {
+	activePipelines.Lock()
+	defer activePipelines.Unlock()
+	return serverConfig.Limits.MaxPipelines > 0 && activePipelines.n >= serverConfig.Limits.MaxPipelines
+}
+
+// acquirePipeline reserva un transcodificador; devuelve false si se ha alcanzado el límite
+func acquirePipeline() bool {
+	activePipelines.Lock()
+	defer activePipelines.Unlock()
+	if serverConfig.Limits.MaxPipelines > 0 && activePipelines.n >= serverConfig.Limits.MaxPipelines {
+		return false
+	}
+	activePipelines.n++
+	return true
+}
+
+// releasePipeline libera el transcodificador reservado con acquirePipeline
+func releasePipeline() {
+	activePipelines.Lock()
+	defer activePipelines.Unlock()
+	activePipelines.n--
+}
@@ -0,0 +1,87 @@
+package webrtc
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits cfg.LimitsConfig
+		ips    []string
+		// resultado esperado de cada petición: "" = admitida, o el límite alcanzado
+		want []string
+	}{
+		{
+			name:   "sin límites",
+			limits: cfg.LimitsConfig{},
+			ips:    []string{"a", "a", "a", "a"},
+			want:   []string{"", "", "", ""},
+		},
+		{
+			name:   "ráfaga por IP",
+			limits: cfg.LimitsConfig{PerIP: cfg.RateLimit{Rate: 0.001, Burst: 2}},
+			ips:    []string{"a", "a", "a", "b"},
+			want:   []string{"", "", limitPerIP, ""},
+		},
+		{
+			name:   "límite global",
+			limits: cfg.LimitsConfig{Global: cfg.RateLimit{Rate: 0.001, Burst: 3}},
+			ips:    []string{"a", "b", "c", "d"},
+			want:   []string{"", "", "", limitGlobal},
+		},
+		{
+			name: "la IP se agota antes que el total",
+			limits: cfg.LimitsConfig{
+				PerIP:  cfg.RateLimit{Rate: 0.001, Burst: 1},
+				Global: cfg.RateLimit{Rate: 0.001, Burst: 2},
+			},
+			ips:  []string{"a", "a", "b", "c"},
+			want: []string{"", limitPerIP, "", limitGlobal},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rateLimiter{perIP: make(map[string]*rateBucket)}
+			for i, ip := range tt.ips {
+				ok, limit, wait := l.allow(ip, tt.limits)
+				if ok != (tt.want[i] == "") || limit != tt.want[i] {
+					t.Fatalf("petición %d de %s: allow = (%v, %q), se esperaba %q", i, ip, ok, limit, tt.want[i])
+				}
+				if !ok && wait <= 0 {
+					t.Errorf("petición %d rechazada sin tiempo de espera", i)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"directa", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"X-Forwarded-For ignorado desde fuera", "203.0.113.5:4000", "10.0.0.1", "203.0.113.5"},
+		{"proxy local", "127.0.0.1:5000", "198.51.100.7", "198.51.100.7"},
+		{"proxy local con cadena", "[::1]:5000", "1.1.1.1, 198.51.100.7", "198.51.100.7"},
+		{"proxy local sin cabecera", "127.0.0.1:5000", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/register", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, se esperaba %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package webrtc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// promMetric es una métrica en el formato de texto de Prometheus con todas sus muestras
+type promMetric struct {
+	name    string
+	help    string
+	kind    string // gauge o counter
+	samples []promSample
+}
+
+// promSample es una muestra con sus etiquetas como pares nombre, valor
+type promSample struct {
+	labels []string
+	value  float64
+}
+
+func (m *promMetric) add(value float64, labels ...string) {
+	m.samples = append(m.samples, promSample{labels: labels, value: value})
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *promMetric) write(w io.Writer) {
+	if len(m.samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, s := range m.samples {
+		var b strings.Builder
+		b.WriteString(m.name)
+		if len(s.labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, `%s="%s"`, s.labels[i], promEscaper.Replace(s.labels[i+1]))
+			}
+			b.WriteByte('}')
+		}
+		fmt.Fprintf(w, "%s %s\n", b.String(), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// metricsHandler expone para Prometheus las estadísticas de red de cada
+// publicador junto a las de su pipeline, para distinguir una red mala de un
+// transcodificador que no da abasto: GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	channels := &promMetric{name: "gopion_channels", help: "Canales creados.", kind: "gauge"}
+	viewers := &promMetric{name: "gopion_viewers", help: "Viewers registrados por canal.", kind: "gauge"}
+	codes := connectionManager.ListAllChannels()
+	slices.Sort(codes)
+	channels.add(float64(len(codes)))
+	for _, code := range codes {
+		viewers.add(float64(len(connectionManager.ListClients(code))), "channel", code)
+	}
+
+	rtt := &promMetric{name: "gopion_publisher_rtt_seconds", help: "RTT del par de candidatos ICE seleccionado.", kind: "gauge"}
+	bitrate := &promMetric{name: "gopion_publisher_bitrate_bits_per_second", help: "Bitrate recibido del publicador en el último segundo.", kind: "gauge"}
+	packets := &promMetric{name: "gopion_publisher_packets_received_total", help: "Paquetes RTP recibidos del publicador.", kind: "counter"}
+	lost := &promMetric{name: "gopion_publisher_packets_lost", help: "Paquetes RTP perdidos según los números de secuencia.", kind: "gauge"}
+	jitter := &promMetric{name: "gopion_publisher_jitter_seconds", help: "Jitter entre llegadas de los paquetes RTP.", kind: "gauge"}
+	nack := &promMetric{name: "gopion_publisher_nack_total", help: "NACK enviados al publicador.", kind: "counter"}
+	pli := &promMetric{name: "gopion_publisher_pli_total", help: "PLI enviados al publicador.", kind: "counter"}
+	fir := &promMetric{name: "gopion_publisher_fir_total", help: "FIR enviados al publicador.", kind: "counter"}
+	width := &promMetric{name: "gopion_publisher_frame_width_pixels", help: "Ancho del vídeo recibido.", kind: "gauge"}
+	height := &promMetric{name: "gopion_publisher_frame_height_pixels", help: "Alto del vídeo recibido.", kind: "gauge"}
+	fps := &promMetric{name: "gopion_publisher_frames_per_second", help: "Frames por segundo recibidos.", kind: "gauge"}
+	for _, s := range sessionsSnapshot("") {
+		stream := strconv.Itoa(s.StreamID)
+		if p := s.CandidatePair; p != nil {
+			rtt.add(p.RTTMs/1000, "channel", s.Code, "stream", stream, "local_type", p.LocalType, "remote_type", p.RemoteType, "protocol", p.Protocol)
+		}
+		for _, t := range s.Tracks {
+			labels := []string{"channel", s.Code, "stream", stream, "ssrc", strconv.FormatUint(uint64(t.SSRC), 10), "kind", t.Kind, "rid", t.RID}
+			bitrate.add(t.BitrateKbps*1000, labels...)
+			packets.add(float64(t.PacketsReceived), labels...)
+			lost.add(float64(t.PacketsLost), labels...)
+			jitter.add(t.JitterMs/1000, labels...)
+			nack.add(float64(t.NACKCount), labels...)
+			pli.add(float64(t.PLICount), labels...)
+			fir.add(float64(t.FIRCount), labels...)
+			if t.Kind == "video" {
+				width.add(float64(t.Width), labels...)
+				height.add(float64(t.Height), labels...)
+				fps.add(t.FPS, labels...)
+			}
+		}
+	}
+
+	target := &promMetric{name: "gopion_publisher_target_bitrate_bits_per_second", help: "Bitrate pedido al publicador con REMB.", kind: "gauge"}
+	bitrateControllers.Lock()
+	var controllers []*bitrateController
+	for _, streams := range bitrateControllers.m {
+		for _, c := range streams {
+			controllers = append(controllers, c)
+		}
+	}
+	bitrateControllers.Unlock()
+	slices.SortFunc(controllers, func(a, b *bitrateController) int {
+		if n := strings.Compare(a.code, b.code); n != 0 {
+			return n
+		}
+		return a.status.StreamID - b.status.StreamID
+	})
+	for _, c := range controllers {
+		st := c.snapshot()
+		target.add(float64(st.TargetKbps)*1000, "channel", c.code, "stream", strconv.Itoa(st.StreamID))
+	}
+
+	running := &promMetric{name: "gopion_pipeline_running", help: "1 si el transcodificador del canal está en marcha.", kind: "gauge"}
+	stalled := &promMetric{name: "gopion_pipeline_stalled", help: "1 si el transcodificador ha dejado de producir JPEGs.", kind: "gauge"}
+	restarts := &promMetric{name: "gopion_pipeline_restarts_total", help: "Reinicios del transcodificador.", kind: "counter"}
+	frames := &promMetric{name: "gopion_pipeline_frames_total", help: "JPEGs producidos por el transcodificador.", kind: "counter"}
+	queue := &promMetric{name: "gopion_pipeline_queue_ratio", help: "Ocupación de la cola de entrada del transcodificador.", kind: "gauge"}
+	dropped := &promMetric{name: "gopion_pipeline_dropped_packets_total", help: "Paquetes descartados con la cola de entrada llena.", kind: "counter"}
+	pipelines.Lock()
+	supervisors := make([]*pipelineSupervisor, 0, len(pipelines.m))
+	for _, s := range pipelines.m {
+		supervisors = append(supervisors, s)
+	}
+	pipelines.Unlock()
+	slices.SortFunc(supervisors, func(a, b *pipelineSupervisor) int { return strings.Compare(a.code, b.code) })
+	boolValue := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	for _, s := range supervisors {
+		s.mu.Lock()
+		st := s.status
+		s.mu.Unlock()
+		labels := []string{"channel", s.code, "stream", strconv.Itoa(s.streamID), "backend", s.backend}
+		running.add(boolValue(st.Running), labels...)
+		stalled.add(boolValue(st.Stalled), labels...)
+		restarts.add(float64(st.Restarts), labels...)
+		frames.add(float64(st.Frames), labels...)
+		q, d := s.feed.backlog()
+		queue.add(q, labels...)
+		dropped.add(float64(d), labels...)
+	}
+
+	active := &promMetric{name: "gopion_pipelines_active", help: "Transcodificadores MJPEG en marcha.", kind: "gauge"}
+	activePipelines.Lock()
+	active.add(float64(activePipelines.n))
+	activePipelines.Unlock()
+	rejections := &promMetric{name: "gopion_limit_rejections_total", help: "Peticiones rechazadas por límites de ritmo o de recursos.", kind: "counter"}
+	limitRejections.Lock()
+	limits := make([]string, 0, len(limitRejections.m))
+	for limit := range limitRejections.m {
+		limits = append(limits, limit)
+	}
+	slices.Sort(limits)
+	for _, limit := range limits {
+		rejections.add(float64(limitRejections.m[limit]), "limit", limit)
+	}
+	limitRejections.Unlock()
+
+	dvrBytes := &promMetric{name: "gopion_dvr_buffer_bytes", help: "Bytes de JPEG guardados en el buffer DVR.", kind: "gauge"}
+	dvrBuffers.Lock()
+	buffers := make([]*dvrBuffer, 0, len(dvrBuffers.m))
+	for _, b := range dvrBuffers.m {
+		buffers = append(buffers, b)
+	}
+	dvrBuffers.Unlock()
+	slices.SortFunc(buffers, func(a, b *dvrBuffer) int { return strings.Compare(a.code, b.code) })
+	for _, b := range buffers {
+		st := b.snapshot()
+		dvrBytes.add(float64(st.MemoryBytes), "channel", st.Code, "storage", "memory")
+		dvrBytes.add(float64(st.DiskBytes), "channel", st.Code, "storage", "disk")
+	}
+
+	motionActive := &promMetric{name: "gopion_motion_active", help: "1 si hay movimiento en curso en el canal.", kind: "gauge"}
+	motionEvents := &promMetric{name: "gopion_motion_events_total", help: "Eventos de movimiento por tipo.", kind: "counter"}
+	motionDetectors.Lock()
+	detectors := make([]*motionDetector, 0, len(motionDetectors.m))
+	for _, d := range motionDetectors.m {
+		detectors = append(detectors, d)
+	}
+	motionDetectors.Unlock()
+	slices.SortFunc(detectors, func(a, b *motionDetector) int { return strings.Compare(a.code, b.code) })
+	for _, d := range detectors {
+		d.mu.Lock()
+		motionActive.add(boolValue(d.active), "channel", d.code)
+		for _, t := range []string{motionStart, motionEnd} {
+			motionEvents.add(float64(d.counts[t]), "channel", d.code, "type", t)
+		}
+		d.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range []*promMetric{channels, viewers, rtt, bitrate, target, packets, lost, jitter, nack, pli, fir, width, height, fps, running, stalled, restarts, frames, queue, dropped, active, rejections, dvrBytes, motionActive, motionEvents} {
+		m.write(w)
+	}
+}
@@ -7,9 +7,16 @@ import (
 )
 
 func watchHandler(w http.ResponseWriter, r *http.Request, code string, clientID int) {
-	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.WriteHeader(http.StatusOK)
+	// ?offset= o ?at= reproducen el buffer DVR en lugar del directo
+	start, rewind, err := dvrPosition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rewind {
+		dvrWatch(w, r, code, start)
+		return
+	}
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -20,26 +27,20 @@ func watchHandler(w http.ResponseWriter, r *http.Request, code string, clientID
 	var client *relay.Client
 
 	if clientID == 0 {
-		connectionManager.CreateChannel(code)
-		client = connectionManager.AddClient(code, 0)
-		if client == nil {
-			http.Error(w, "Error al añadir cliente al canal", http.StatusInternalServerError)
+		var err error
+		if err = connectionManager.CreateChannel(code); err == nil {
+			client, err = connectionManager.AddClient(code, 0)
+		}
+		if err != nil {
+			writeRelayError(w, "Error al añadir cliente al canal: ", err)
 			return
 		}
 		clientID = client.ID
 	} else {
-		if channel, exists := connectionManager.ValidateChannel(code); exists {
-			var err error
-			client, err = channel.GetClient(clientID)
-			if err != nil {
-				client = connectionManager.AddClient(code, clientID)
-				if client == nil {
-					http.Error(w, "Error al añadir cliente al canal", http.StatusInternalServerError)
-					return
-				}
-			}
-		} else {
-			http.Error(w, "Canal no encontrado", http.StatusNotFound)
+		// Sólo se sirve a viewers ya registrados: crear aquí el clientID pedido
+		// permitiría ocupar los IDs que /register va a asignar después
+		var ok bool
+		if _, client, ok = requestViewer(w, r); !ok {
 			return
 		}
 	}
@@ -48,18 +49,36 @@ func watchHandler(w http.ResponseWriter, r *http.Request, code string, clientID
 		connectionManager.RemoveClient(code, clientID)
 	}()
 
+	// Las cabeceras se envían una vez reservado el viewer para poder responder
+	// con el error de límite si no hay hueco
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
 	for {
 		select {
 		case frame := <-client.Chan:
-			_, _ = w.Write([]byte("--frame\r\n"))
-			_, _ = w.Write([]byte("Content-Type: image/jpeg\r\n\r\n"))
-			_, _ = w.Write(frame)
-			_, _ = w.Write([]byte("\r\n"))
+			writeMJPEGFrame(w, frame)
 			flusher.Flush()
 		case <-client.Done:
+			// Entregar el último frame pendiente (p. ej. la imagen de fuera de servicio)
+			select {
+			case frame := <-client.Chan:
+				writeMJPEGFrame(w, frame)
+				flusher.Flush()
+			default:
+			}
 			return
 		case <-r.Context().Done():
 			return
 		}
 	}
 }
+
+// writeMJPEGFrame escribe un JPEG como parte del multipart/x-mixed-replace
+func writeMJPEGFrame(w http.ResponseWriter, frame []byte) {
+	_, _ = w.Write([]byte("--frame\r\n"))
+	_, _ = w.Write([]byte("Content-Type: image/jpeg\r\n\r\n"))
+	_, _ = w.Write(frame)
+	_, _ = w.Write([]byte("\r\n"))
+}
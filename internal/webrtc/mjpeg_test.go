@@ -0,0 +1,44 @@
+package webrtc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// /watch no crea viewers con el clientID pedido: sólo sirve a los registrados
+// con su token
+func TestWatchHandlerRejectsUnregistered(t *testing.T) {
+	const code = "TEST-WATCH"
+	if err := connectionManager.CreateChannel(code); err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.RemoveChannel(code)
+	channel, _ := connectionManager.ValidateChannel(code)
+	viewer, err := channel.AddClient(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(viewer.ID)
+	tests := []struct {
+		name     string
+		clientID int
+		query    string
+	}{
+		{"viewer no registrado", 99, "?code=" + code + "&clientID=99&token=" + viewer.Token},
+		{"viewer sin token", viewer.ID, "?code=" + code + "&clientID=" + id},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			watchHandler(rec, httptest.NewRequest("GET", "/watch"+tt.query, nil), code, tt.clientID)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("GET /watch%s = %d, se esperaba %d", tt.query, rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+	if _, err := channel.GetClient(99); err == nil {
+		t.Error("/watch registró un clientID que no existía")
+	}
+}
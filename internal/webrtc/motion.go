@@ -0,0 +1,695 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// motionGridWidth es el ancho de la rejilla de luminancia a la que se reduce cada frame
+const motionGridWidth = 64
+
+// motionStartFrames es el número de frames analizados seguidos con cambios que
+// hacen falta para empezar un evento (uno solo suele ser ruido del códec)
+const motionStartFrames = 2
+
+// motionWebhookQueue es el número de eventos pendientes de enviar a los webhooks
+const motionWebhookQueue = 64
+
+// Tipos de evento de movimiento
+const (
+	motionStart = "motion_start"
+	motionEnd   = "motion_end"
+)
+
+// motionEvent es un inicio o fin de movimiento en un canal. La captura se
+// sirve en Snapshot mientras el evento siga en el historial del canal.
+type motionEvent struct {
+	ID    int64     `json:"id"`
+	Type  string    `json:"type"`
+	Code  string    `json:"code"`
+	Time  time.Time `json:"time"`
+	Score float64   `json:"score"` // fracción de la zona más activa que ha cambiado
+	Zones []string  `json:"zones"`
+	// Duration es lo que ha durado el movimiento (sólo en motion_end)
+	Duration float64 `json:"durationSeconds,omitempty"`
+	Snapshot string  `json:"snapshot"`
+	snapshot []byte
+}
+
+// motionSettings es la configuración de detección de un canal, que parte de
+// la de motion y se cambia con POST /motion
+type motionSettings struct {
+	Enabled     bool             `json:"enabled"`
+	Sensitivity int              `json:"sensitivity"`
+	Zones       []cfg.MotionZone `json:"zones"`
+}
+
+// motionStatus es el estado de detección de un canal que se expone en /motion
+type motionStatus struct {
+	Code string `json:"code"`
+	motionSettings
+	Active bool          `json:"active"`
+	Since  time.Time     `json:"since,omitempty"`
+	Score  float64       `json:"score"` // del último frame analizado
+	Events []motionEvent `json:"events"`
+}
+
+// motionGrid es un frame reducido a su luminancia media por celda
+type motionGrid struct {
+	w, h int
+	luma []float32
+}
+
+// motionDetector analiza los frames de un canal en su propia goroutine. Los
+// frames llegan por un buzón de una plaza: si el análisis anterior no ha
+// terminado, el frame se descarta en vez de frenar el broadcast.
+type motionDetector struct {
+	code   string
+	frames chan []byte
+
+	mu           sync.Mutex
+	settings     motionSettings
+	lastAccepted time.Time
+	prev         *motionGrid
+	lastJPEG     []byte
+	positives    int
+	score        float64
+	active       bool
+	since        time.Time
+	lastMotion   time.Time
+	peak         float64
+	zones        []string
+	events       []motionEvent // historial, del más antiguo al más reciente
+	counts       map[string]int
+}
+
+// motionDetectors indexa los detectores por código de canal. Se crean con el
+// primer frame y se descartan cuando el canal desaparece.
+var motionDetectors = struct {
+	sync.Mutex
+	m map[string]*motionDetector
+}{m: make(map[string]*motionDetector)}
+
+// motionSubscribers son los feeds SSE que reciben los eventos de un canal
+var motionSubscribers = struct {
+	sync.Mutex
+	m map[chan motionEvent]string
+}{m: make(map[chan motionEvent]string)}
+
+// motionEventID numera los eventos de todos los canales
+var motionEventID atomic.Int64
+
+// motionWebhooks es la cola de eventos pendientes de enviar a motion.webhooks
+var motionWebhooks = make(chan motionEvent, motionWebhookQueue)
+
+// motionCtx se cancela al apagar para parar los detectores y los webhooks
+var motionCtx, motionCancel = context.WithCancel(context.Background())
+
+// initMotion comprueba las zonas por defecto y arranca el envío de webhooks
+func initMotion(c cfg.MotionConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Webhooks) > 0 {
+		go motionWebhookWorker(motionCtx, c)
+	}
+	logging.For("motion").Info("Detección de movimiento activada", "fps", c.FPS, "sensitivity", c.Sensitivity, "zones", len(c.Zones), "webhooks", len(c.Webhooks))
+	return nil
+}
+
+// closeMotion para los detectores y el envío de webhooks al apagar
+func closeMotion() {
+	motionCancel()
+}
+
+// motionObserve ofrece un JPEG del canal al detector. Sólo se analizan hasta
+// motion.fps frames por segundo; el resto se ignora sin copiarlo.
+func motionObserve(code string, frame []byte) {
+	if !serverConfig.Motion.Enabled {
+		return
+	}
+	d := motionDetectorFor(code)
+	now := time.Now()
+	d.mu.Lock()
+	if !d.settings.Enabled || now.Sub(d.lastAccepted) < time.Duration(float64(time.Second)/serverConfig.Motion.FPS) {
+		d.mu.Unlock()
+		return
+	}
+	d.lastAccepted = now
+	d.mu.Unlock()
+	select {
+	case d.frames <- frame:
+	default:
+	}
+}
+
+// motionDetectorFor devuelve el detector del canal, creándolo si no existe
+func motionDetectorFor(code string) *motionDetector {
+	motionDetectors.Lock()
+	defer motionDetectors.Unlock()
+	d, exists := motionDetectors.m[code]
+	if !exists {
+		c := serverConfig.Motion
+		d = &motionDetector{
+			code:   code,
+			frames: make(chan []byte, 1),
+			settings: motionSettings{
+				Enabled:     true,
+				Sensitivity: c.Sensitivity,
+				Zones:       namedMotionZones(c.Zones),
+			},
+			counts: make(map[string]int),
+		}
+		motionDetectors.m[code] = d
+		go d.run(motionCtx)
+	}
+	return d
+}
+
+// currentSettings devuelve una copia de la configuración de detección del canal
+func (d *motionDetector) currentSettings() motionSettings {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.settings
+	s.Zones = slices.Clone(s.Zones)
+	return s
+}
+
+// setMotionSettings cambia la configuración de detección del canal y vuelve a
+// empezar la comparación desde el siguiente frame
+func setMotionSettings(code string, s motionSettings) {
+	if !serverConfig.Motion.Enabled {
+		return
+	}
+	d := motionDetectorFor(code)
+	d.mu.Lock()
+	d.settings = s
+	d.prev, d.positives = nil, 0
+	d.mu.Unlock()
+}
+
+// lookupMotion devuelve el detector del canal, o nil si no tiene
+func lookupMotion(code string) *motionDetector {
+	motionDetectors.Lock()
+	defer motionDetectors.Unlock()
+	return motionDetectors.m[code]
+}
+
+// namedMotionZones copia las zonas poniendo nombre a las que no lo tienen
+func namedMotionZones(zones []cfg.MotionZone) []cfg.MotionZone {
+	named := slices.Clone(zones)
+	for i := range named {
+		if named[i].Name == "" {
+			named[i].Name = fmt.Sprintf("zona%d", i+1)
+		}
+	}
+	return named
+}
+
+// run analiza los frames del buzón y cada segundo comprueba si el movimiento
+// ha terminado o si el canal ya no existe
+func (d *motionDetector) run(ctx context.Context) {
+	logger := logging.For("motion").With("channel", d.code)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame := <-d.frames:
+			grid, err := decodeMotionGrid(frame)
+			if err != nil {
+				logger.Debug("Frame no analizable", "err", err)
+				continue
+			}
+			d.analyze(time.Now(), frame, grid)
+		case now := <-ticker.C:
+			d.checkEnd(now)
+			if _, exists := connectionManager.ValidateChannel(d.code); !exists && d.idle() {
+				motionDetectors.Lock()
+				if motionDetectors.m[d.code] == d {
+					delete(motionDetectors.m, d.code)
+				}
+				motionDetectors.Unlock()
+				logger.Debug("Detector de movimiento descartado")
+				return
+			}
+		}
+	}
+}
+
+// idle indica si el detector no tiene un movimiento en curso
+func (d *motionDetector) idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.active
+}
+
+// analyze compara el frame con el anterior y empieza un evento si hay
+// cambios en motionStartFrames frames seguidos
+func (d *motionDetector) analyze(now time.Time, frame []byte, grid *motionGrid) {
+	d.mu.Lock()
+	prev := d.prev
+	d.prev = grid
+	d.lastJPEG = frame
+	if prev == nil || prev.w != grid.w || prev.h != grid.h {
+		d.mu.Unlock()
+		return
+	}
+	score, zones := compareMotionGrids(prev, grid, d.settings)
+	d.score = score
+	if len(zones) == 0 {
+		d.positives = 0
+		d.mu.Unlock()
+		return
+	}
+	d.positives++
+	d.lastMotion = now
+	if d.active {
+		d.peak = max(d.peak, score)
+		for _, z := range zones {
+			if !slices.Contains(d.zones, z) {
+				d.zones = append(d.zones, z)
+			}
+		}
+		d.mu.Unlock()
+		return
+	}
+	if d.positives < motionStartFrames {
+		d.mu.Unlock()
+		return
+	}
+	d.active, d.since, d.peak, d.zones = true, now, score, zones
+	ev := d.record(motionEvent{Type: motionStart, Time: now, Score: score, Zones: slices.Clone(zones), snapshot: frame})
+	d.mu.Unlock()
+	publishMotion(ev)
+}
+
+// checkEnd termina el evento en curso tras motion.cooldown sin cambios
+func (d *motionDetector) checkEnd(now time.Time) {
+	d.mu.Lock()
+	if !d.active || now.Sub(d.lastMotion) < serverConfig.Motion.Cooldown {
+		d.mu.Unlock()
+		return
+	}
+	d.active = false
+	d.positives = 0
+	ev := d.record(motionEvent{
+		Type:     motionEnd,
+		Time:     now,
+		Score:    d.peak,
+		Zones:    d.zones,
+		Duration: d.lastMotion.Sub(d.since).Seconds(),
+		snapshot: d.lastJPEG,
+	})
+	d.zones = nil
+	d.mu.Unlock()
+	publishMotion(ev)
+}
+
+// record numera el evento y lo guarda en el historial del canal (con d.mu tomado)
+func (d *motionDetector) record(ev motionEvent) motionEvent {
+	ev.ID = motionEventID.Add(1)
+	ev.Code = d.code
+	ev.Snapshot = fmt.Sprintf("/motion/snapshot?code=%s&id=%d", url.QueryEscape(d.code), ev.ID)
+	d.events = append(d.events, ev)
+	if over := len(d.events) - serverConfig.Motion.History; over > 0 {
+		clear(d.events[:over])
+		d.events = d.events[over:]
+	}
+	d.counts[ev.Type]++
+	return ev
+}
+
+// snapshot copia el estado del detector para /motion
+func (d *motionDetector) snapshot() motionStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := motionStatus{
+		Code:           d.code,
+		motionSettings: d.settings,
+		Active:         d.active,
+		Score:          d.score,
+		Events:         slices.Clone(d.events),
+	}
+	st.Zones = slices.Clone(d.settings.Zones)
+	if d.active {
+		st.Since = d.since
+	}
+	return st
+}
+
+// publishMotion envía el evento a los feeds SSE del canal y a los webhooks
+func publishMotion(ev motionEvent) {
+	logging.For("motion").Info("Evento de movimiento", "channel", ev.Code, "type", ev.Type, "id", ev.ID, "score", ev.Score, "zones", ev.Zones)
+	motionSubscribers.Lock()
+	for ch, code := range motionSubscribers.m {
+		if code == ev.Code {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+	motionSubscribers.Unlock()
+	if len(serverConfig.Motion.Webhooks) == 0 {
+		return
+	}
+	select {
+	case motionWebhooks <- ev:
+	default:
+		logging.For("motion").Warn("Cola de webhooks llena, se descarta el evento", "channel", ev.Code, "id", ev.ID)
+	}
+}
+
+// subscribeMotion devuelve los eventos de movimiento del canal. Sin detección
+// de movimiento devuelve un canal nil, que nunca recibe nada.
+func subscribeMotion(code string) (<-chan motionEvent, func()) {
+	if !serverConfig.Motion.Enabled {
+		return nil, func() {}
+	}
+	ch := make(chan motionEvent, 8)
+	motionSubscribers.Lock()
+	motionSubscribers.m[ch] = code
+	motionSubscribers.Unlock()
+	return ch, func() {
+		motionSubscribers.Lock()
+		delete(motionSubscribers.m, ch)
+		motionSubscribers.Unlock()
+	}
+}
+
+// decodeMotionGrid decodifica el JPEG y lo reduce a una rejilla de
+// motionGridWidth de ancho con la luminancia media de cada celda
+func decodeMotionGrid(frame []byte) (*motionGrid, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, errors.New("frame vacío")
+	}
+	var luma func(x, y int) uint8
+	switch m := img.(type) {
+	case *image.YCbCr:
+		luma = func(x, y int) uint8 { return m.Y[m.YOffset(x, y)] }
+	case *image.Gray:
+		luma = func(x, y int) uint8 { return m.Pix[m.PixOffset(x, y)] }
+	default:
+		luma = func(x, y int) uint8 { return color.GrayModel.Convert(m.At(x, y)).(color.Gray).Y }
+	}
+	g := &motionGrid{w: min(motionGridWidth, b.Dx())}
+	g.h = max(1, b.Dy()*g.w/b.Dx())
+	g.luma = make([]float32, g.w*g.h)
+	for gy := 0; gy < g.h; gy++ {
+		y0, y1 := b.Min.Y+gy*b.Dy()/g.h, b.Min.Y+(gy+1)*b.Dy()/g.h
+		for gx := 0; gx < g.w; gx++ {
+			x0, x1 := b.Min.X+gx*b.Dx()/g.w, b.Min.X+(gx+1)*b.Dx()/g.w
+			var sum, n int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += int(luma(x, y))
+					n++
+				}
+			}
+			g.luma[gy*g.w+gx] = float32(sum) / float32(max(n, 1))
+		}
+	}
+	return g, nil
+}
+
+// motionThresholds traduce la sensibilidad (1-100) a la diferencia de
+// luminancia que cuenta como cambio en una celda y a la fracción de celdas
+// de una zona que tienen que cambiar
+func motionThresholds(sensitivity int) (delta, area float64) {
+	s := float64(100 - sensitivity)
+	return 8 + s*0.32, 0.001 + s*0.0004
+}
+
+// compareMotionGrids devuelve la fracción de celdas cambiadas de la zona más
+// activa y las zonas que superan el umbral. Antes de comparar se resta el
+// cambio medio de luminancia para no confundir un cambio de luz con movimiento.
+func compareMotionGrids(prev, cur *motionGrid, s motionSettings) (float64, []string) {
+	delta, area := motionThresholds(s.Sensitivity)
+	var mean float64
+	for i := range cur.luma {
+		mean += float64(cur.luma[i] - prev.luma[i])
+	}
+	mean /= float64(len(cur.luma))
+	zones := s.Zones
+	if len(zones) == 0 {
+		zones = []cfg.MotionZone{{Name: "frame", W: 1, H: 1}}
+	}
+	var score float64
+	var triggered []string
+	for _, z := range zones {
+		x0, x1 := int(z.X*float64(cur.w)), int(math.Ceil((z.X+z.W)*float64(cur.w)))
+		y0, y1 := int(z.Y*float64(cur.h)), int(math.Ceil((z.Y+z.H)*float64(cur.h)))
+		x1, y1 = min(x1, cur.w), min(y1, cur.h)
+		var changed, total int
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				i := y*cur.w + x
+				if math.Abs(float64(cur.luma[i]-prev.luma[i])-mean) >= delta {
+					changed++
+				}
+				total++
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		fraction := float64(changed) / float64(total)
+		score = max(score, fraction)
+		if fraction >= area {
+			triggered = append(triggered, z.Name)
+		}
+	}
+	return score, triggered
+}
+
+// motionWebhookPayload es el cuerpo que se envía a los webhooks: el evento
+// con la captura en JPEG codificada en base64
+type motionWebhookPayload struct {
+	motionEvent
+	SnapshotJPEG []byte `json:"snapshotJpeg"`
+}
+
+// motionWebhookWorker envía los eventos de la cola a cada webhook, de uno en
+// uno para que lleguen en orden
+func motionWebhookWorker(ctx context.Context, c cfg.MotionConfig) {
+	client := &http.Client{Timeout: c.WebhookTimeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-motionWebhooks:
+			body, err := json.Marshal(motionWebhookPayload{motionEvent: ev, SnapshotJPEG: ev.snapshot})
+			if err != nil {
+				continue
+			}
+			for _, hook := range c.Webhooks {
+				if err := sendMotionWebhook(ctx, client, hook, c.WebhookSecret, ev.Type, body); err != nil {
+					logging.For("motion").Warn("Error enviando el webhook de movimiento", "channel", ev.Code, "id", ev.ID, "url", hook, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// sendMotionWebhook hace el POST del evento, firmado si hay secreto
+func sendMotionWebhook(ctx context.Context, client *http.Client, hook, secret, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-pion-stream")
+	req.Header.Set("X-Gopion-Event", eventType)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Gopion-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("respuesta %s", resp.Status)
+	}
+	return nil
+}
+
+// parseMotionZones lee zonas con el formato nombre:x,y,w,h (nombre opcional,
+// fracciones de 0 a 1). Sin ninguna zona se analiza el frame entero.
+func parseMotionZones(values []string) ([]cfg.MotionZone, error) {
+	var zones []cfg.MotionZone
+	for _, part := range values {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var z cfg.MotionZone
+		if name, rect, found := strings.Cut(part, ":"); found {
+			z.Name, part = strings.TrimSpace(name), rect
+		}
+		fields := strings.Split(part, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("zona %q: se esperan x,y,w,h", part)
+		}
+		rect := make([]float64, 4)
+		for i, f := range fields {
+			v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil {
+				return nil, fmt.Errorf("zona %q: %w", part, err)
+			}
+			rect[i] = v
+		}
+		z.X, z.Y, z.W, z.H = rect[0], rect[1], rect[2], rect[3]
+		if err := z.Validate(); err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return namedMotionZones(zones), nil
+}
+
+// motionHandler consulta o cambia la detección de movimiento:
+// GET /motion[?code=] devuelve el estado y los últimos eventos, y
+// POST /motion?code=&enabled=&sensitivity=&zone=&zone= cambia la de un canal
+// (zone= vacío vuelve al frame entero). Cambiarla es cosa del operador del
+// canal (clientID y token de un cliente operador, o ?key=).
+func motionHandler(w http.ResponseWriter, r *http.Request) {
+	if !serverConfig.Motion.Enabled {
+		http.Error(w, "Detección de movimiento desactivada (motion.enabled)", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	code := q.Get("code")
+	switch r.Method {
+	case http.MethodGet:
+		if code != "" {
+			if _, exists := connectionManager.ValidateChannel(code); !exists && lookupMotion(code) == nil {
+				http.Error(w, "Canal no encontrado", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(motionDetectorFor(code).snapshot())
+			return
+		}
+		motionDetectors.Lock()
+		detectors := make([]*motionDetector, 0, len(motionDetectors.m))
+		for _, d := range motionDetectors.m {
+			detectors = append(detectors, d)
+		}
+		motionDetectors.Unlock()
+		statuses := make([]motionStatus, 0, len(detectors))
+		for _, d := range detectors {
+			statuses = append(statuses, d.snapshot())
+		}
+		slices.SortFunc(statuses, func(a, b motionStatus) int { return strings.Compare(a.Code, b.Code) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	case http.MethodPost:
+		channel, exists := connectionManager.ValidateChannel(code)
+		if !exists {
+			http.Error(w, "Canal no encontrado", http.StatusNotFound)
+			return
+		}
+		if !requestOperator(r, code) {
+			http.Error(w, "Sólo el operador del canal puede cambiar su detección de movimiento", http.StatusForbidden)
+			return
+		}
+		d := motionDetectorFor(code)
+		settings := d.currentSettings()
+		if v := q.Get("enabled"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "enabled debe ser true o false", http.StatusBadRequest)
+				return
+			}
+			settings.Enabled = b
+		}
+		if v := q.Get("sensitivity"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "sensitivity debe estar entre 1 y 100", http.StatusBadRequest)
+				return
+			}
+			settings.Sensitivity = n
+		}
+		if q.Has("zone") {
+			zones, err := parseMotionZones(q["zone"])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			settings.Zones = zones
+		}
+		setMotionSettings(code, settings)
+		// Los canales guardados conservan su detección tras un reinicio
+		if channel.IsPersistent() {
+			if err := updateChannelRecord(code, func(rec *channelRecord) { rec.Motion = &settings }); err != nil {
+				logging.For("store").Warn("No se pudo guardar la detección de movimiento del canal", "channel", code, "err", err)
+			}
+		}
+		logging.For("motion").Info("Detección de movimiento actualizada", "channel", code, "enabled", settings.Enabled, "sensitivity", settings.Sensitivity, "zones", len(settings.Zones))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.snapshot())
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// motionSnapshotHandler devuelve la captura de un evento del historial:
+// GET /motion/snapshot?code=&id=
+func motionSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireWatcher(w, r) {
+		return
+	}
+	d := lookupMotion(r.URL.Query().Get("code"))
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if d == nil || err != nil {
+		http.Error(w, "Captura no encontrada", http.StatusNotFound)
+		return
+	}
+	d.mu.Lock()
+	var snapshot []byte
+	for _, ev := range d.events {
+		if ev.ID == id {
+			snapshot = ev.snapshot
+		}
+	}
+	d.mu.Unlock()
+	if snapshot == nil {
+		http.Error(w, "Captura no encontrada", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(snapshot)
+}
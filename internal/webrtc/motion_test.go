@@ -0,0 +1,121 @@
+package webrtc
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+)
+
+// testGrid crea una rejilla de 10x10 con luminancia base y cambia en delta
+// las celdas de las columnas x0 a x1-1 y de las filas y0 a y1-1
+func testGrid(base, delta float32, x0, y0, x1, y1 int) *motionGrid {
+	g := &motionGrid{w: 10, h: 10, luma: make([]float32, 100)}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			g.luma[y*g.w+x] = base
+			if x >= x0 && x < x1 && y >= y0 && y < y1 {
+				g.luma[y*g.w+x] += delta
+			}
+		}
+	}
+	return g
+}
+
+func TestCompareMotionGrids(t *testing.T) {
+	halves := []cfg.MotionZone{
+		{Name: "izquierda", X: 0, Y: 0, W: 0.5, H: 1},
+		{Name: "derecha", X: 0.5, Y: 0, W: 0.5, H: 1},
+	}
+	prev := testGrid(100, 0, 0, 0, 0, 0)
+	tests := []struct {
+		name      string
+		cur       *motionGrid
+		settings  motionSettings
+		wantScore float64
+		wantZones []string
+	}{
+		{"sin cambios", testGrid(100, 0, 0, 0, 0, 0), motionSettings{Sensitivity: 50}, 0, nil},
+		{"cambio de luz global", testGrid(140, 0, 0, 0, 0, 0), motionSettings{Sensitivity: 100}, 0, nil},
+		{"objeto en el frame", testGrid(100, 80, 0, 0, 3, 3), motionSettings{Sensitivity: 50}, 0.09, []string{"frame"}},
+		{"objeto en una zona", testGrid(100, 80, 0, 0, 3, 3), motionSettings{Sensitivity: 50, Zones: halves}, 0.18, []string{"izquierda"}},
+		{"objeto en las dos zonas", testGrid(100, 80, 4, 0, 6, 10), motionSettings{Sensitivity: 50, Zones: halves}, 0.2, []string{"izquierda", "derecha"}},
+		{"cambio leve, sensibilidad baja", testGrid(100, 15, 0, 0, 5, 1), motionSettings{Sensitivity: 1}, 0, nil},
+		{"cambio leve, sensibilidad alta", testGrid(100, 15, 0, 0, 5, 1), motionSettings{Sensitivity: 100}, 0.05, []string{"frame"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, zones := compareMotionGrids(prev, tt.cur, tt.settings)
+			if math.Abs(score-tt.wantScore) > 1e-9 {
+				t.Errorf("score = %v, se esperaba %v", score, tt.wantScore)
+			}
+			if !slices.Equal(zones, tt.wantZones) {
+				t.Errorf("zonas = %v, se esperaban %v", zones, tt.wantZones)
+			}
+		})
+	}
+}
+
+func TestParseMotionZones(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []cfg.MotionZone
+		wantErr bool
+	}{
+		{"ninguna", nil, nil, false},
+		{"vacía", []string{""}, nil, false},
+		{"con nombre", []string{"puerta:0,0,0.5,1"}, []cfg.MotionZone{{Name: "puerta", W: 0.5, H: 1}}, false},
+		{"sin nombre", []string{"0.5, 0, 0.5, 1"}, []cfg.MotionZone{{Name: "zona1", X: 0.5, W: 0.5, H: 1}}, false},
+		{"faltan campos", []string{"0,0,1"}, nil, true},
+		{"no numérico", []string{"0,0,uno,1"}, nil, true},
+		{"fuera del frame", []string{"0.6,0,0.5,1"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMotionZones(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMotionZones = %v, error esperado: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("parseMotionZones = %+v, se esperaba %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Sólo el operador del canal cambia su detección de movimiento
+func TestMotionPostRequiresOperator(t *testing.T) {
+	saved := serverConfig
+	defer func() { serverConfig = saved }()
+	serverConfig.Motion.Enabled = true
+
+	const code = "TEST-MOTION"
+	if err := connectionManager.CreateChannel(code); err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.RemoveChannel(code)
+	channel, _ := connectionManager.ValidateChannel(code)
+	viewer, err := channel.AddClient(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, query := range map[string]string{
+		"anónimo":         "",
+		"viewer":          "&clientID=7&token=" + viewer.Token,
+		"token ajeno":     "&clientID=7&token=otro",
+		"clave inventada": "&key=0123456789abcdef",
+	} {
+		rec := httptest.NewRecorder()
+		motionHandler(rec, httptest.NewRequest("POST", "/motion?code="+code+"&sensitivity=80"+query, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("POST /motion %s = %d, se esperaba 403", name, rec.Code)
+		}
+	}
+	if lookupMotion(code) != nil {
+		t.Error("una petición rechazada creó el detector del canal")
+	}
+}
@@ -0,0 +1,181 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// transcoderQueueSize es el número de paquetes que pueden esperar a ser escritos
+// en la entrada del transcodificador antes de empezar a descartarlos
+const transcoderQueueSize = 1024
+
+// rtpFrameWriter depaquetiza RTP y escribe frames completos en un contenedor
+type rtpFrameWriter interface {
+	WriteRTP(*rtp.Packet) error
+	Close() error
+}
+
+// newRTPFrameWriter elige el contenedor para el códec negociado: IVF para VP8,
+// VP9 y AV1, y Annex-B para H.264. Devuelve también el formato de entrada del transcodificador.
+func newRTPFrameWriter(codec webrtc.RTPCodecParameters, w io.Writer) (rtpFrameWriter, string, error) {
+	mime := strings.ToLower(codec.MimeType)
+	switch mime {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return h264writer.NewWith(w), "h264", nil
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeVP9), strings.ToLower(webrtc.MimeTypeAV1):
+		canonical := map[string]string{
+			strings.ToLower(webrtc.MimeTypeVP8): webrtc.MimeTypeVP8,
+			strings.ToLower(webrtc.MimeTypeVP9): webrtc.MimeTypeVP9,
+			strings.ToLower(webrtc.MimeTypeAV1): webrtc.MimeTypeAV1,
+		}[mime]
+		writer, err := ivfwriter.NewWith(w, ivfwriter.WithCodec(canonical))
+		if err != nil {
+			return nil, "", err
+		}
+		return writer, "ivf", nil
+	}
+	return nil, "", fmt.Errorf("códec %s no soportado por el transcodificador", codec.MimeType)
+}
+
+// errFeedClosed indica que el track que alimentaba al transcodificador ha terminado
+var errFeedClosed = errors.New("la entrada del transcodificador está cerrada")
+
+// transcoderFeed recibe los paquetes RTP de vídeo en orden y los escribe,
+// ya depaquetizados, en el extremo de escritura de la tubería conectada a la
+// entrada estándar del transcodificador. La escritura se hace en su propia
+// goroutine para que un transcodificador lento no frene a los viewers WebRTC.
+// Cada vez que se (re)lanza el transcodificador se crea una tubería nueva con attach.
+type transcoderFeed struct {
+	codec           webrtc.RTPCodecParameters
+	log             *slog.Logger
+	packets         chan *rtp.Packet
+	requestKeyframe func()
+	closeOnce       sync.Once
+	dropping        bool
+	dropped         atomic.Uint64
+
+	mu     sync.Mutex
+	writer rtpFrameWriter
+	pw     *os.File
+	closed bool
+}
+
+// newTranscoderFeed crea la cola de entrada para el códec indicado. Hasta que
+// se llama a attach los paquetes se descartan.
+func newTranscoderFeed(code string, codec webrtc.RTPCodecParameters, requestKeyframe func()) (*transcoderFeed, error) {
+	if _, _, err := newRTPFrameWriter(codec, io.Discard); err != nil {
+		return nil, err
+	}
+	feed := &transcoderFeed{
+		codec:           codec,
+		log:             logging.For("transcoder").With("channel", code),
+		packets:         make(chan *rtp.Packet, transcoderQueueSize),
+		requestKeyframe: requestKeyframe,
+	}
+	go feed.run()
+	return feed, nil
+}
+
+// attach crea una tubería nueva, sustituyendo a la anterior, y devuelve el
+// extremo de lectura, que debe pasarse como stdin al transcodificador, junto
+// con el formato de entrada que éste debe usar. El escritor nuevo espera a un
+// keyframe antes de escribir frames.
+func (f *transcoderFeed) attach() (*os.File, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, "", errFeedClosed
+	}
+	f.detachLocked()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, "", err
+	}
+	writer, format, err := newRTPFrameWriter(f.codec, pw)
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return nil, "", err
+	}
+	f.writer, f.pw = writer, pw
+	return pr, format, nil
+}
+
+// detachLocked cierra la tubería actual; el transcodificador recibe EOF
+func (f *transcoderFeed) detachLocked() {
+	if f.writer != nil {
+		f.writer.Close()
+		f.pw.Close()
+		f.writer, f.pw = nil, nil
+	}
+}
+
+// isClosed indica si el track ya no va a enviar más paquetes
+func (f *transcoderFeed) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// push encola una copia del paquete sin bloquear. Si la cola está llena el
+// paquete se descarta y se pide un keyframe para que el decodificador se recupere.
+func (f *transcoderFeed) push(pkt *rtp.Packet) {
+	select {
+	case f.packets <- pkt.Clone():
+		f.dropping = false
+	default:
+		dropped := f.dropped.Add(1)
+		if !f.dropping {
+			f.dropping = true
+			f.log.Warn("Cola de entrada llena, descartando paquetes", "dropped", dropped)
+			if f.requestKeyframe != nil {
+				f.requestKeyframe()
+			}
+		}
+	}
+}
+
+// backlog devuelve la ocupación de la cola de entrada (0-1) y el total de
+// paquetes descartados; sirve para saber si el transcodificador va con retraso
+func (f *transcoderFeed) backlog() (float64, uint64) {
+	return float64(len(f.packets)) / float64(cap(f.packets)), f.dropped.Load()
+}
+
+// close cierra la cola; la goroutine de escritura cierra la tubería al vaciarla
+// y el transcodificador recibe EOF.
+func (f *transcoderFeed) close() {
+	f.closeOnce.Do(func() { close(f.packets) })
+}
+
+func (f *transcoderFeed) run() {
+	defer func() {
+		f.mu.Lock()
+		f.closed = true
+		f.detachLocked()
+		f.mu.Unlock()
+	}()
+	for pkt := range f.packets {
+		f.mu.Lock()
+		if f.writer != nil {
+			if err := f.writer.WriteRTP(pkt); err != nil {
+				// El transcodificador ha terminado: se descarta hasta el próximo attach
+				f.log.Warn("Error escribiendo en la entrada del transcodificador", "err", err)
+				f.detachLocked()
+			}
+		}
+		f.mu.Unlock()
+	}
+}
@@ -0,0 +1,133 @@
+package webrtc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// qrCacheSize es el número máximo de imágenes QR que se guardan en memoria
+const qrCacheSize = 256
+
+// qrCache guarda las imágenes ya generadas por contenido, formato y tamaño.
+// Como la clave incluye la URL completa, un cambio de URL pública no sirve QRs viejos.
+var qrCache = struct {
+	sync.Mutex
+	m     map[string][]byte
+	order []string // orden de inserción para descartar las más antiguas
+}{m: make(map[string][]byte)}
+
+// qrHandler genera el QR de un enlace:
+//
+//	/qr?target=publisher&code=ABC          página del publicador con el código
+//	/qr?target=viewer&code=ABC             visor MJPEG (viewer-rtc para WebRTC)
+//	/qr?target=share&token=...             enlace para compartir creado con /share
+//
+// con format=png|svg (png por defecto) y size en píxeles para PNG (64-1024).
+func qrHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := q.Get("target")
+	if target == "" {
+		target = "publisher"
+	}
+	base := publicBaseURL(r)
+	var content string
+	switch {
+	case target == "share":
+		token := q.Get("token")
+		if _, err := parseShareToken(token); err != nil {
+			http.Error(w, "Token no válido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		content = base + "/s/" + token
+	case linkTargets[target] != "":
+		content = targetURL(base, target, q.Get("code"))
+	default:
+		http.Error(w, fmt.Sprintf("target %q no soportado (publisher, viewer, viewer-rtc o share)", target), http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, "format debe ser png o svg", http.StatusBadRequest)
+		return
+	}
+	size := 256
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 64 || n > 1024 {
+			http.Error(w, "size debe estar entre 64 y 1024", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	img, err := cachedQR(content, format, size)
+	if err != nil {
+		http.Error(w, "Error generando el QR", http.StatusInternalServerError)
+		return
+	}
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	// El contenido depende de la URL pública, que puede cambiar: no cachear mucho
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-QR-Content", content)
+	w.Write(img)
+}
+
+// cachedQR devuelve la imagen del QR, generándola si no está en caché
+func cachedQR(content, format string, size int) ([]byte, error) {
+	key := format + "|" + strconv.Itoa(size) + "|" + content
+	qrCache.Lock()
+	img, ok := qrCache.m[key]
+	qrCache.Unlock()
+	if ok {
+		return img, nil
+	}
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	if format == "svg" {
+		img = qrSVG(code.Bitmap())
+	} else if img, err = code.PNG(size); err != nil {
+		return nil, err
+	}
+	qrCache.Lock()
+	defer qrCache.Unlock()
+	if _, ok := qrCache.m[key]; !ok {
+		if len(qrCache.order) >= qrCacheSize {
+			delete(qrCache.m, qrCache.order[0])
+			qrCache.order = qrCache.order[1:]
+		}
+		qrCache.order = append(qrCache.order, key)
+		qrCache.m[key] = img
+	}
+	return img, nil
+}
+
+// qrSVG dibuja la matriz del QR (que ya incluye el margen) como un SVG escalable
+func qrSVG(bitmap [][]bool) []byte {
+	n := len(bitmap)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
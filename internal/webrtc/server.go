@@ -1,16 +1,22 @@
 package webrtc
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"html/template"
-	"log"
 	"math/rand"
-	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/tlscert"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/transcoder"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/turnserver"
 	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
 
 	"github.com/pion/interceptor"
@@ -22,14 +28,17 @@ import (
 // Global instance of ConnectionManager
 var connectionManager = relay.NewConnectionManager()
 
+// serverConfig guarda la configuración con la que se arrancó el servidor
+var serverConfig = cfg.Default()
+
 // watchUIHandler sirve el visor MJPEG HTML
 func watchUIHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/watch.html")
+	serveAsset(w, r, "watch.html")
 }
 
 // logUIHandler sirve el visor de logs HTML
 func logUIHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/log.html")
+	serveAsset(w, r, "log.html")
 }
 
 // Función para generar códigos aleatorios
@@ -37,15 +46,74 @@ func generateRandomCode() string {
 	return fmt.Sprintf("%06X", rand.Intn(0xFFFFFF))
 }
 
-// Function to generate client IDs based on the length of all clients
+// lastClientID es el último ID de cliente asignado; los IDs no se reutilizan
+var lastClientID atomic.Int64
+
+// lastStreamID es el último ID de stream asignado a un publicador u origen
+var lastStreamID atomic.Int64
+
+// generateStreamID devuelve un ID de stream nuevo; los IDs no se reutilizan
+func generateStreamID() int {
+	return int(lastStreamID.Add(1))
+}
+
+// generateClientID devuelve un ID de cliente nuevo. Es público (va en las URLs
+// de los viewers): lo que autoriza a actuar como cliente es su token.
 func generateClientID() int {
-	return len(connectionManager.ListAllClients()) + 1
+	return int(lastClientID.Add(1))
 }
 
-// StartWebRTCServer inicia el servidor HTTP y configura las rutas principales
-func StartWebRTCServer(port int) {
+// httpServer es el servidor HTTP en marcha; Shutdown lo detiene
+var httpServer *http.Server
+
+// StartWebRTCServer inicia el servidor HTTP y configura las rutas principales.
+// Bloquea hasta que el servidor se detiene; tras un Shutdown devuelve nil.
+func StartWebRTCServer(c cfg.Config) error {
+	serverConfig = c
+	connectionManager.SetLimits(c.Limits.MaxChannels, c.Limits.MaxViewersPerChannel, c.Limits.MaxPublishersPerChannel)
+	connectionManager.SetChatLimits(chatLimits(c.Chat))
+	if c.TURN.Enabled {
+		ts, err := turnserver.Start(c.TURN)
+		if err != nil {
+			return fmt.Errorf("no se pudo arrancar el servidor TURN: %w", err)
+		}
+		turnServer = ts
+	}
+	if err := initICEMux(); err != nil {
+		return err
+	}
+	if err := initShareSecret(c.Share); err != nil {
+		return err
+	}
+	if err := initAssets(c); err != nil {
+		return fmt.Errorf("no se pudo cargar la interfaz web: %w", err)
+	}
+	if err := initEdge(c.Edge); err != nil {
+		return err
+	}
+	if err := initStore(c.Store.Path); err != nil {
+		return err
+	}
+	if err := initDVR(c.DVR); err != nil {
+		return err
+	}
+	if err := initClips(c.Clips, c.DVR); err != nil {
+		return err
+	}
+	if err := initMotion(c.Motion); err != nil {
+		return err
+	}
+	// Cada JPEG difundido pasa también por el DVR y la detección de movimiento.
+	// Corre en la goroutine que difunde: la detección sólo encola el frame,
+	// pero el DVR lo vuelca a disco al llenarse la memoria y eso retrasa el
+	// siguiente frame del canal.
+	connectionManager.SetFrameObserver(func(code string, streamID int, frame []byte) {
+		dvrRecord(code, frame)
+		motionObserve(code, frame)
+	})
+
 	// Actualizar las rutas para manejar códigos de canal
-	http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/stream", rateLimited(func(w http.ResponseWriter, r *http.Request) {
 		code := r.URL.Query().Get("code")
 		_, valid := connectionManager.ValidateChannel(code)
 		if !valid {
@@ -62,14 +130,17 @@ func StartWebRTCServer(port int) {
 			_ = clientID // Only parse, do not log
 		}
 		handleWebRTCStream(w, r, code)
-	})
+	}))
 
-	http.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/watch", rateLimited(func(w http.ResponseWriter, r *http.Request) {
 		code := r.URL.Query().Get("code")
 		if code == "" {
 			http.Error(w, "Código de canal requerido", http.StatusBadRequest)
 			return
 		}
+		if !requireWatcher(w, r) {
+			return
+		}
 
 		clientIDParam := r.URL.Query().Get("clientID")
 		var clientID int
@@ -84,59 +155,167 @@ func StartWebRTCServer(port int) {
 
 		// Pasar code y clientID al watchHandler
 		watchHandler(w, r, code, clientID)
-	})
+	}))
+
+	http.HandleFunc("/watchrtc", rateLimited(func(w http.ResponseWriter, r *http.Request) {
+		code := r.URL.Query().Get("code")
+		clientID, err := strconv.Atoi(r.URL.Query().Get("clientID"))
+		if code == "" || err != nil {
+			http.Error(w, "code y clientID requeridos", http.StatusBadRequest)
+			return
+		}
+		if !requireWatcher(w, r) {
+			return
+		}
+		handleWebRTCWatch(w, r, code, clientID)
+	}))
+
+	http.HandleFunc("/streamui", streamHandler)       // servir HTML
+	http.HandleFunc("/watchui", watchUIHandler)       // servir visor MJPEG
+	http.HandleFunc("/watchrtcui", watchRTCUIHandler) // servir visor WebRTC
+	http.HandleFunc("/log", logUIHandler)             // servir visor de logs
 
-	http.HandleFunc("/streamui", streamHandler) // servir HTML
-	http.HandleFunc("/watchui", watchUIHandler) // servir visor MJPEG
-	http.HandleFunc("/log", logUIHandler)       // servir visor de logs
+	// Ficheros de la interfaz: embebidos en el binario o sustituidos desde static_dir
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServerFS(assets)))
 
 	// Nuevo handler para registrar códigos
-	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/webrtc_server.log", logFileHandler) // servir log por HTTP
+	http.HandleFunc("/register", rateLimited(registerHandler))
+	http.HandleFunc("/ice", rateLimited(iceHandler))           // servidores ICE y credenciales TURN efímeras
+	http.HandleFunc("/stats/jitter", jitterStatsHandler)       // pérdidas y paquetes tardíos por track
+	http.HandleFunc("/stats/bitrate", bitrateStatsHandler)     // tope y bitrate pedido a cada publicador
+	http.HandleFunc("/stats/session", sessionStatsHandler)     // bitrate, pérdidas, jitter, resolución y RTT de cada publicador
+	http.HandleFunc("/metrics", metricsHandler)                // métricas de publicadores y pipelines para Prometheus
+	http.HandleFunc("/pipeline", pipelineHandler)              // estado del pipeline y stderr del transcodificador
+	http.HandleFunc("/webrtc_server.log", logFileHandler)      // servir log por HTTP
+	http.HandleFunc("/ca", caUIHandler)                        // instrucciones para instalar la CA autofirmada
+	http.HandleFunc("/ca.crt", caCertHandler)                  // descarga de la CA autofirmada
+	http.HandleFunc("/healthz", healthzHandler)                // comprobación de salud (túnel static, balanceadores)
+	http.HandleFunc("/qr", qrHandler)                          // QR en PNG/SVG de los enlaces de publicador, viewer y compartidos
+	http.HandleFunc("/share", shareHandler)                    // crea enlaces para compartir con token
+	http.HandleFunc("/s/", shareRedirectHandler)               // abre un enlace compartido
+	http.HandleFunc("/chat", chatHandler)                      // mensaje de chat de un viewer
+	http.HandleFunc("/chat/events", chatEventsHandler)         // chat del canal y eventos de movimiento por SSE
+	http.HandleFunc("/control", controlHandler)                // órdenes de un viewer al publicador (cámara, linterna...)
+	http.HandleFunc("/edge", rateLimited(edgeHandler))         // canales recibidos de otro servidor (origen)
+	http.HandleFunc("/channels", channelsHandler)              // definiciones de canal guardadas entre reinicios
+	http.HandleFunc("/dvr", dvrHandler)                        // ventana grabada de cada canal para rebobinar
+	http.HandleFunc("/dvr/frame", dvrFrameHandler)             // JPEG grabado en una hora u offset
+	http.HandleFunc("/clips", clipsHandler)                    // exportación de clips del buffer DVR con progreso
+	http.HandleFunc("/clips/download", clipDownloadHandler)    // descarga de un clip exportado
+	http.HandleFunc("/motion", motionHandler)                  // detección de movimiento: estado, eventos, sensibilidad y zonas
+	http.HandleFunc("/motion/snapshot", motionSnapshotHandler) // captura de un evento de movimiento
 
 	// Endpoint principal explicativo con enlaces (usa template)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := template.ParseFiles("static/index.tmpl")
-		if err != nil {
-			http.Error(w, "Error cargando plantilla", http.StatusInternalServerError)
-			log.Printf("[TEMPLATE] Error al cargar index.tmpl: %v", err)
-			return
-		}
 		data := struct {
-			NgrokURL string
+			PublicURL string
+			Tunnel    string
 		}{
-			NgrokURL: cfg.GetNgrokPublicURL(),
+			PublicURL: cfg.PublicURL(),
+			Tunnel:    serverConfig.Tunnel.Provider,
 		}
-		if err := tmpl.Execute(w, data); err != nil {
+		if err := indexTmpl.Execute(w, data); err != nil {
 			http.Error(w, "Error procesando plantilla", http.StatusInternalServerError)
-			log.Printf("[TEMPLATE] Error al ejecutar index.tmpl: %v", err)
+			logging.For("server").Error("Error al ejecutar index.tmpl", "err", err)
 		}
 	})
 
-	addr := ":" + strconv.Itoa(port)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	httpServer = &http.Server{Addr: c.ListenAddr}
+	var err error
+	if c.TLS.Enabled {
+		certs, lerr := tlscert.Load(c.TLS)
+		if lerr != nil {
+			return lerr
+		}
+		caCert = certs.CACert
+		httpServer.TLSConfig = certs.TLSConfig
+		logging.For("tls").Info("Sirviendo HTTPS", "addr", c.ListenAddr)
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown apaga el servidor de forma ordenada: deja de aceptar conexiones,
+// envía la imagen de fuera de servicio a los viewers MJPEG, detiene los
+// streams y sus pipelines, cierra los PeerConnections y espera a que terminen
+// las peticiones en curso hasta que venza ctx.
+func Shutdown(ctx context.Context) error {
+	logging.For("server").Info("Apagando el servidor...")
+	done := make(chan error, 1)
+	go func() {
+		if httpServer == nil {
+			done <- nil
+			return
+		}
+		done <- httpServer.Shutdown(ctx)
+	}()
+	stopEdgePulls()
+	connectionManager.Shutdown("Servidor detenido")
+	closeViewerPeers()
+	closeChannelStore()
+	closeDVR()
+	closeMotion()
+	err := <-done
+	if err != nil {
+		logging.For("server").Warn("Error esperando a las peticiones en curso", "err", err)
+	}
+	if turnServer != nil {
+		if cerr := turnServer.Close(); cerr != nil {
+			logging.For("turn").Error("Error cerrando el servidor TURN", "err", cerr)
+		}
+	}
+	closeICEMux()
+	logging.For("server").Info("Servidor apagado")
+	return err
+}
+
+// healthzHandler responde 200 mientras el servidor está en marcha
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
 }
 
 // logFileHandler sirve el archivo de log bruto
 func logFileHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "webrtc_server.log")
+	http.ServeFile(w, r, serverConfig.LogPath)
 }
 
-// CreateWebRTCSession inicializa una sesión WebRTC, procesa la oferta y devuelve el answer
-func CreateWebRTCSession(offer SDPMessage, code string, streamID int) (*webrtc.PeerConnection, *webrtc.SessionDescription, error) {
-	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil,
-		},
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return nil, nil, err
+// newSettingEngine aplica la configuración de red de ICE compartida por todas las sesiones
+func newSettingEngine() (webrtc.SettingEngine, error) {
+	se := webrtc.SettingEngine{}
+	applyICENetwork(&se)
+	if r := serverConfig.UDPPorts; r.Min != 0 && iceUDPMux == nil {
+		if err := se.SetEphemeralUDPPortRange(r.Min, r.Max); err != nil {
+			return se, err
+		}
+	}
+	return se, nil
+}
+
+// iceServers convierte los servidores ICE configurados al formato de pion
+func iceServers() []webrtc.ICEServer {
+	servers := make([]webrtc.ICEServer, 0, len(serverConfig.ICEServers))
+	for _, s := range serverConfig.ICEServers {
+		server := webrtc.ICEServer{URLs: s.URLs}
+		if s.Username != "" {
+			server.Username = s.Username
+			server.Credential = s.Credential
+		}
+		servers = append(servers, server)
 	}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil,
-		},
-	}, webrtc.RTPCodecTypeAudio); err != nil {
+	return servers
+}
+
+// newPublisherAPI prepara la API de pion con la que se recibe el vídeo de un
+// publicador (o de un origen, en un edge) y la sesión de estadísticas asociada
+func newPublisherAPI(code string, streamID int) (*webrtc.API, *publisherSession, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := registerCodecs(mediaEngine); err != nil {
 		return nil, nil, err
 	}
 	interceptorRegistry := &interceptor.Registry{}
@@ -145,57 +324,93 @@ func CreateWebRTCSession(offer SDPMessage, code string, streamID int) (*webrtc.P
 		return nil, nil, err
 	}
 	interceptorRegistry.Add(intervalPliFactory)
-	if err = webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+	if err = registerPublisherInterceptors(mediaEngine, interceptorRegistry); err != nil {
 		return nil, nil, err
 	}
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(interceptorRegistry))
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
-	}
-	peerConnection, err := api.NewPeerConnection(config)
+	session, err := newPublisherSession(code, streamID, interceptorRegistry)
 	if err != nil {
 		return nil, nil, err
 	}
-	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+	settingEngine, err := newSettingEngine()
+	if err != nil {
 		return nil, nil, err
 	}
-	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(interceptorRegistry), webrtc.WithSettingEngine(settingEngine))
+	return api, session, nil
+}
+
+// CreateWebRTCSession inicializa una sesión WebRTC, procesa la oferta y devuelve
+// el answer. Si falla, cierra el PeerConnection que haya creado.
+func CreateWebRTCSession(offer SDPMessage, code string, streamID int) (_ *webrtc.PeerConnection, _ *webrtc.SessionDescription, err error) {
+	api, session, err := newPublisherAPI(code, streamID)
+	if err != nil {
 		return nil, nil, err
 	}
-	udpConns := map[string]*udpConn{
-		"audio": {port: 4000, payloadType: 111},
-		"video": {port: 4002, payloadType: 96},
+	config := webrtc.Configuration{
+		ICEServers: iceServers(),
 	}
-	configVals := cfg.Load()
-	udpBindIP := configVals.UDPBindIP
-	laddr, err := net.ResolveUDPAddr("udp", udpBindIP+":")
+	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
 		return nil, nil, err
 	}
-	for _, conn := range udpConns {
-		raddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", udpBindIP, conn.port))
+	bitrateCtx, stopBitrate := context.WithCancel(context.Background())
+	defer func() {
 		if err != nil {
-			return nil, nil, err
+			stopBitrate()
+			session.unregister()
+			peerConnection.Close()
 		}
-		conn.conn, err = net.DialUDP("udp", laddr, raddr)
-		if err != nil {
+	}()
+	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, nil, err
+	}
+	videoTransceiver, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := channelMediaPolicy(code)
+	if len(policy.CodecPreference) > 0 {
+		if err := videoTransceiver.SetCodecPreferences(preferredVideoCodecs(policy.CodecPreference)); err != nil {
 			return nil, nil, err
 		}
 	}
+	requestKeyframe := func(ssrc uint32) {
+		if err := peerConnection.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil {
+			logging.For("rtcp").Warn("Error enviando PLI", "channel", code, "stream_id", streamID, "ssrc", ssrc, "err", err)
+		}
+	}
+	// REMB periódico con el tope del canal, que baja si el pipeline va con retraso
+	var bitrate *bitrateController
+	if serverConfig.Congestion.REMB {
+		bitrate = newBitrateController(code, streamID, peerConnection)
+	}
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		go HandleTrack(track, udpConns, code, streamID)
+		go HandleTrack(track, receiver, code, streamID, requestKeyframe)
 		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			if bitrate != nil {
+				bitrate.addSSRC(uint32(track.SSRC()))
+			}
 			go func(pc *webrtc.PeerConnection, ssrc uint32) {
 				sendInitialPLIs(pc, ssrc, 5, 300*time.Millisecond)
 			}(peerConnection, uint32(track.SSRC()))
 		}
 	})
+	// El publicador abre un data channel "chat" para hablar con los viewers y
+	// otro "control" por el que recibe sus órdenes
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		switch dc.Label() {
+		case chatLabel:
+			attachPublisherChat(code, streamID, dc)
+		case controlLabel:
+			attachPublisherControl(code, streamID, dc)
+		}
+	})
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {})
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
-			for _, conn := range udpConns {
-				conn.conn.Close()
-			}
+			stopBitrate()
+			session.unregister()
+			removeLayerStream(code, streamID)
 			if channel, exists := connectionManager.ValidateChannel(code); exists {
 				_ = channel.StopStream(streamID)
 			}
@@ -217,29 +432,32 @@ func CreateWebRTCSession(offer SDPMessage, code string, streamID int) (*webrtc.P
 		return nil, nil, err
 	}
 	<-gatherComplete
-	return peerConnection, peerConnection.LocalDescription(), nil
+	// Limitar el bitrate del publicador según la política del canal. pion no
+	// admite modificar el answer local, así que sólo se altera la copia enviada.
+	localAnswer := *peerConnection.LocalDescription()
+	if localAnswer.SDP, err = applyMaxBitrate(localAnswer.SDP, policy.MaxBitrateKbps); err != nil {
+		return nil, nil, err
+	}
+	session.register(peerConnection)
+	if bitrate != nil {
+		go bitrate.run(bitrateCtx)
+	}
+	return peerConnection, &localAnswer, nil
 }
 
 // CreateWebRTCSessionWithPeerConnection inicializa una sesión WebRTC y devuelve el PeerConnection y el answer SDP
 func CreateWebRTCSessionWithPeerConnection(offer SDPMessage) (*webrtc.PeerConnection, *webrtc.SessionDescription, error) {
 	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil,
-		},
-	}, webrtc.RTPCodecTypeVideo); err != nil {
+	if err := registerCodecs(mediaEngine); err != nil {
 		return nil, nil, err
 	}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil,
-		},
-	}, webrtc.RTPCodecTypeAudio); err != nil {
+	settingEngine, err := newSettingEngine()
+	if err != nil {
 		return nil, nil, err
 	}
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
+		ICEServers: iceServers(),
 	}
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
@@ -274,16 +492,16 @@ func sendInitialPLIs(pc *webrtc.PeerConnection, mediaSSRC uint32, maxRetries int
 	for i := 0; i < maxRetries; i++ {
 		pli := &rtcp.PictureLossIndication{MediaSSRC: mediaSSRC}
 		if err := pc.WriteRTCP([]rtcp.Packet{pli}); err != nil {
-			log.Printf("[RTCP] Error enviando PLI (intento %d/%d) ssrc=%d: %v", i+1, maxRetries, mediaSSRC, err)
+			logging.For("rtcp").Warn("Error enviando PLI", "attempt", i+1, "max", maxRetries, "ssrc", mediaSSRC, "err", err)
 		} else {
-			log.Printf("[RTCP] PLI enviado (intento %d/%d) ssrc=%d", i+1, maxRetries, mediaSSRC)
+			logging.For("rtcp").Debug("PLI enviado", "attempt", i+1, "max", maxRetries, "ssrc", mediaSSRC)
 		}
 
 		// Esperar antes del siguiente intento (backoff exponencial)
 		time.Sleep(interval)
 		interval *= 2
 	}
-	log.Printf("[RTCP] Finalizados intentos de PLI para ssrc=%d (max %d intentos)", mediaSSRC, maxRetries)
+	logging.For("rtcp").Debug("Finalizados intentos de PLI", "ssrc", mediaSSRC, "max", maxRetries)
 }
 
 // Validar el código en el handler de /stream
@@ -291,7 +509,7 @@ func validateStreamHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	channel, exists := connectionManager.ValidateChannel(code)
 	if !exists {
-		log.Printf("[Signaling] Código de canal inválido: %s", code)
+		logging.For("signaling").Warn("Código de canal inválido", "channel", code)
 		http.Error(w, "Código de canal inválido", http.StatusBadRequest)
 		return
 	}
@@ -299,12 +517,12 @@ func validateStreamHandler(w http.ResponseWriter, r *http.Request) {
 	// Verificar si hay viewers activos en el canal
 	clients := channel.ListClients()
 	if len(clients) == 0 {
-		log.Printf("[Signaling] No hay viewers activos en el canal: %s", code)
+		logging.For("signaling").Warn("No hay viewers activos en el canal", "channel", code)
 		http.Error(w, "No hay viewers activos en el canal", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[Signaling] Código de canal válido y viewers activos: %s", code)
+	logging.For("signaling").Info("Código de canal válido y viewers activos", "channel", code)
 }
 
 // Handler para registrar códigos desde /register
@@ -315,19 +533,123 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Crear o validar el canal
-	connectionManager.CreateChannel(code)
+	policy, err := parseMediaPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// ?persist=1&name=... guarda la definición del canal para recrearlo tras un reinicio
+	persist := r.URL.Query().Get("persist") == "1"
+	if persist {
+		if _, err := storeDB(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Crear o validar el canal; quien lo crea es su operador. Los edges
+	// (?edge=1) sólo se unen a canales que ya existen.
+	operator := false
+	edge := r.URL.Query().Get("edge") == "1"
+	if edge {
+		if _, exists := connectionManager.ValidateChannel(code); !exists {
+			http.Error(w, "Canal no encontrado", http.StatusNotFound)
+			return
+		}
+	} else {
+		created, err := connectionManager.EnsureChannel(code)
+		if err != nil {
+			logging.For("signaling").Warn("No se pudo crear el canal", "channel", code, "err", err)
+			writeRelayError(w, "No se pudo crear el canal: ", err)
+			return
+		}
+		// En los canales guardados es operador quien presenta su clave (?key=)
+		operator = created || checkOperatorKey(code, requestOperatorKey(r))
+	}
+	// Con share.required el resto necesita un enlace compartido del canal
+	if !operator {
+		if err := checkShare(r, code); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	// Sólo el operador fija la política de medios del canal
+	if len(policy.CodecPreference) > 0 || policy.MaxBitrateKbps > 0 || policy.Transcoder != "" {
+		if !operator {
+			http.Error(w, "Sólo el operador del canal puede cambiar su política de medios", http.StatusForbidden)
+			return
+		}
+		if channel, exists := connectionManager.ValidateChannel(code); exists {
+			channel.SetMediaPolicy(policy)
+			logging.For("signaling").Info("Política de medios del canal", "channel", code, "codecs", policy.CodecPreference, "max_kbps", policy.MaxBitrateKbps, "transcoder", policy.Transcoder)
+			// El operador de un canal guardado actualiza también su definición
+			persist = persist || channel.IsPersistent()
+		}
+	}
+	if persist && !operator {
+		http.Error(w, "Sólo el operador del canal puede guardarlo", http.StatusForbidden)
+		return
+	}
+	if persist {
+		key, err := persistChannel(code, r.URL.Query().Get("name"))
+		if err != nil {
+			logging.For("store").Error("No se pudo guardar el canal", "channel", code, "err", err)
+			http.Error(w, "No se pudo guardar el canal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// La clave de operador sólo se entrega al guardar el canal por primera vez
+		if key != "" {
+			w.Header().Set("X-Operator-Key", key)
+		}
+	}
 
 	// Añadir el cliente al canal
 	clientID := generateClientID()
-	client := connectionManager.AddClient(code, clientID)
-	if client == nil {
-		log.Printf("[RegisterHandler] Error al añadir cliente al canal %s", code)
-		http.Error(w, "Error al añadir cliente al canal", http.StatusInternalServerError)
+	client, err := connectionManager.AddClient(code, clientID)
+	if err != nil {
+		logging.For("signaling").Warn("Error al añadir cliente al canal", "channel", code, "client_id", clientID, "err", err)
+		writeRelayError(w, "Error al añadir cliente al canal: ", err)
 		return
 	}
+	role := relay.ClientRoleViewer
+	if operator {
+		role = relay.ClientRoleOperator
+	}
+	client.SetRole(role)
 
-	log.Printf("[RegisterHandler] Viewer conectado exitosamente al canal %s con clientID %d", code, clientID)
+	logging.For("signaling").Info("Viewer conectado", "channel", code, "client_id", clientID, "role", role, "edge", edge)
+	w.Header().Set("X-Client-Role", role)
+	// El token se exige en /chat, /chat/events y /control (?token= o cabecera X-Client-Token)
+	w.Header().Set("X-Client-Token", client.Token)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("%d", clientID)))
 }
+
+// parseMediaPolicy lee la política de medios opcional de la petición:
+// ?codecs=h264,vp8&maxKbps=1500&transcoder=gstreamer
+func parseMediaPolicy(r *http.Request) (relay.MediaPolicy, error) {
+	var policy relay.MediaPolicy
+	if codecs := r.URL.Query().Get("codecs"); codecs != "" {
+		for _, name := range strings.Split(codecs, ",") {
+			mime, err := codecMimeType(name)
+			if err != nil {
+				return policy, err
+			}
+			policy.CodecPreference = append(policy.CodecPreference, mime)
+		}
+	}
+	if maxKbps := r.URL.Query().Get("maxKbps"); maxKbps != "" {
+		kbps, err := strconv.Atoi(maxKbps)
+		if err != nil || kbps < 0 {
+			return policy, errors.New("maxKbps inválido")
+		}
+		policy.MaxBitrateKbps = kbps
+	}
+	if backend := r.URL.Query().Get("transcoder"); backend != "" {
+		if !slices.Contains(transcoder.Backends(), backend) {
+			return policy, fmt.Errorf("transcoder %q no soportado (%s)", backend, strings.Join(transcoder.Backends(), ", "))
+		}
+		policy.Transcoder = backend
+	}
+	return policy, nil
+}
@@ -0,0 +1,221 @@
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cfg "github.com/rpacheco-blazquez/go-pion-stream/internal/config"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// linkTargets son las páginas a las que puede apuntar un enlace o un QR
+var linkTargets = map[string]string{
+	"publisher":  "/streamui",
+	"viewer":     "/watchui",
+	"viewer-rtc": "/watchrtcui",
+}
+
+// shareSecret firma los tokens de los enlaces para compartir
+var shareSecret []byte
+
+// initShareSecret toma el secreto configurado o genera uno aleatorio
+func initShareSecret(c cfg.ShareConfig) error {
+	if c.Secret != "" {
+		shareSecret = []byte(c.Secret)
+		return nil
+	}
+	shareSecret = make([]byte, 32)
+	if _, err := rand.Read(shareSecret); err != nil {
+		return err
+	}
+	logging.For("share").Info("No hay share.secret configurado: los enlaces compartidos caducan al reiniciar")
+	return nil
+}
+
+// shareToken es el contenido firmado de un enlace para compartir
+type shareToken struct {
+	Target  string
+	Code    string
+	Expires time.Time
+}
+
+// encode serializa el token como base64url(target|code|exp).base64url(hmac)
+func (t shareToken) encode() string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(t.Target + "|" + t.Code + "|" + strconv.FormatInt(t.Expires.Unix(), 10)))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signShare(payload))
+}
+
+// signShare calcula la firma truncada a 16 bytes, suficiente para un enlace
+func signShare(payload string) []byte {
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:16]
+}
+
+// parseShareToken valida la firma y la caducidad de un token
+func parseShareToken(s string) (shareToken, error) {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return shareToken{}, errors.New("token mal formado")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signShare(payload)) {
+		return shareToken{}, errors.New("firma inválida")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return shareToken{}, errors.New("token mal formado")
+	}
+	// El código puede contener "|": el target va antes del primero y la caducidad tras el último
+	target, rest, ok := strings.Cut(string(raw), "|")
+	sep := strings.LastIndex(rest, "|")
+	if !ok || sep < 0 {
+		return shareToken{}, errors.New("token mal formado")
+	}
+	exp, err := strconv.ParseInt(rest[sep+1:], 10, 64)
+	if err != nil {
+		return shareToken{}, errors.New("token mal formado")
+	}
+	t := shareToken{Target: target, Code: rest[:sep], Expires: time.Unix(exp, 0)}
+	if time.Now().After(t.Expires) {
+		return t, errors.New("enlace caducado")
+	}
+	return t, nil
+}
+
+// publicBaseURL devuelve la URL pública del servidor o, si no hay túnel, la
+// que ha usado el cliente para llegar a él. Host y X-Forwarded-Proto sólo se
+// creen si la conexión llega desde loopback (túnel o proxy local, como en
+// clientIP); si no, se usa la dirección local en la que se aceptó la conexión.
+func publicBaseURL(r *http.Request) string {
+	if u := cfg.PublicURL(); u != "" {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !fromLoopback(r) {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			return scheme + "://" + addr.String()
+		}
+		return scheme + "://" + r.Host
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// targetURL construye el enlace a una página, con el código de canal si se indica
+func targetURL(base, target, code string) string {
+	u := base + linkTargets[target]
+	if code != "" {
+		u += "?code=" + url.QueryEscape(code)
+	}
+	return u
+}
+
+// checkShare aplica share.required a /register: quien no es operador del
+// canal necesita un enlace compartido vigente para ese canal (?share=)
+func checkShare(r *http.Request, code string) error {
+	if !serverConfig.Share.Required {
+		return nil
+	}
+	t, err := parseShareToken(r.URL.Query().Get("share"))
+	if err != nil {
+		return fmt.Errorf("el canal exige un enlace compartido: %w", err)
+	}
+	if t.Code != code || (t.Target != "viewer" && t.Target != "viewer-rtc") {
+		return errors.New("el enlace compartido no es de este canal")
+	}
+	return nil
+}
+
+// requireWatcher aplica share.required a los endpoints que entregan vídeo o
+// frames del canal: exige code, clientID y token de un viewer registrado
+func requireWatcher(w http.ResponseWriter, r *http.Request) bool {
+	if !serverConfig.Share.Required {
+		return true
+	}
+	_, _, ok := requestViewer(w, r)
+	return ok
+}
+
+// shareHandler crea un enlace para compartir: POST /share?code=ABC&target=viewer&ttl=2h.
+// Con share.required sólo el operador del canal puede crearlos (clientID y
+// token de un cliente operador, o ?key=).
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	code := q.Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	if serverConfig.Share.Required && !requestOperator(r, code) {
+		http.Error(w, "Sólo el operador del canal puede crear enlaces compartidos", http.StatusForbidden)
+		return
+	}
+	target := q.Get("target")
+	if target == "" {
+		target = "viewer"
+	}
+	if _, ok := linkTargets[target]; !ok {
+		http.Error(w, fmt.Sprintf("target %q no soportado (publisher, viewer o viewer-rtc)", target), http.StatusBadRequest)
+		return
+	}
+	ttl := serverConfig.Share.TTL
+	if v := q.Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > serverConfig.Share.MaxTTL {
+			http.Error(w, fmt.Sprintf("ttl inválido (máximo %v)", serverConfig.Share.MaxTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	t := shareToken{Target: target, Code: code, Expires: time.Now().Add(ttl).Truncate(time.Second)}
+	token := t.encode()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token   string    `json:"token"`
+		URL     string    `json:"url"`
+		QR      string    `json:"qr"`
+		Expires time.Time `json:"expires"`
+	}{
+		Token:   token,
+		URL:     publicBaseURL(r) + "/s/" + token,
+		QR:      "/qr?target=share&token=" + url.QueryEscape(token),
+		Expires: t.Expires,
+	})
+}
+
+// shareRedirectHandler abre un enlace compartido: /s/<token> redirige a la
+// página del canal con el token, que la página presenta al registrarse
+func shareRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/s/")
+	t, err := parseShareToken(token)
+	if err != nil {
+		http.Error(w, "Enlace no válido: "+err.Error(), http.StatusGone)
+		return
+	}
+	if _, ok := linkTargets[t.Target]; !ok {
+		http.Error(w, "Enlace no válido", http.StatusGone)
+		return
+	}
+	http.Redirect(w, r, targetURL("", t.Target, t.Code)+"&share="+url.QueryEscape(token), http.StatusFound)
+}
@@ -0,0 +1,151 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+func TestParseShareToken(t *testing.T) {
+	shareSecret = []byte("secreto de prueba")
+	valid := shareToken{Target: "viewer", Code: "a|b", Expires: time.Now().Add(time.Hour).Truncate(time.Second)}
+	encoded := valid.encode()
+	payload, sig, _ := strings.Cut(encoded, ".")
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"válido", encoded, ""},
+		{"sin firma", payload, "mal formado"},
+		{"firma alterada", payload + "." + strings.Repeat("A", len(sig)), "firma inválida"},
+		{"payload alterado", "x" + encoded, "firma inválida"},
+		{"caducado", shareToken{Target: "viewer", Code: "ABC", Expires: time.Now().Add(-time.Minute)}.encode(), "caducado"},
+		{"vacío", "", "mal formado"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseShareToken(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseShareToken = %v, se esperaba un error con %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Target != valid.Target || got.Code != valid.Code || !got.Expires.Equal(valid.Expires) {
+				t.Errorf("parseShareToken = %+v, se esperaba %+v", got, valid)
+			}
+		})
+	}
+	// Un token firmado con otro secreto no vale
+	shareSecret = []byte("otro secreto")
+	if _, err := parseShareToken(encoded); err == nil {
+		t.Error("se aceptó un token firmado con otro secreto")
+	}
+}
+
+// Con share.required sólo el operador crea enlaces, los viewers necesitan uno
+// del canal para registrarse y los endpoints de vídeo exigen el token de cliente
+func TestShareRequired(t *testing.T) {
+	saved := serverConfig
+	defer func() { serverConfig = saved }()
+	serverConfig.Share.Required = true
+	shareSecret = []byte("secreto de prueba")
+
+	const code = "TEST-SHARE"
+	defer connectionManager.RemoveChannel(code)
+	register := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		registerHandler(rec, httptest.NewRequest("POST", "/register?code="+code+query, nil))
+		return rec
+	}
+	// Quien crea el canal es su operador y no necesita enlace
+	op := register("")
+	if op.Code != http.StatusOK || op.Header().Get("X-Client-Role") != relay.ClientRoleOperator {
+		t.Fatalf("registro del operador = %d %s", op.Code, op.Body)
+	}
+	opAuth := "&clientID=" + op.Body.String() + "&token=" + op.Header().Get("X-Client-Token")
+
+	share := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		shareHandler(rec, httptest.NewRequest("POST", "/share?code="+code+query, nil))
+		return rec
+	}
+	if rec := share(""); rec.Code != http.StatusForbidden {
+		t.Fatalf("POST /share sin operador = %d, se esperaba 403", rec.Code)
+	}
+	rec := share(opAuth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /share del operador = %d %s", rec.Code, rec.Body)
+	}
+	var created struct{ Token string }
+	json.NewDecoder(rec.Body).Decode(&created)
+
+	other := shareToken{Target: "viewer", Code: "OTRO", Expires: time.Now().Add(time.Hour)}.encode()
+	publisher := shareToken{Target: "publisher", Code: code, Expires: time.Now().Add(time.Hour)}.encode()
+	for name, query := range map[string]string{
+		"sin enlace":         "",
+		"enlace de otro":     "&share=" + url.QueryEscape(other),
+		"enlace de publicar": "&share=" + url.QueryEscape(publisher),
+	} {
+		if rec := register(query); rec.Code != http.StatusForbidden {
+			t.Errorf("registro %s = %d, se esperaba 403", name, rec.Code)
+		}
+	}
+	viewer := register("&share=" + url.QueryEscape(created.Token))
+	if viewer.Code != http.StatusOK || viewer.Header().Get("X-Client-Role") != relay.ClientRoleViewer {
+		t.Fatalf("registro con enlace = %d %s", viewer.Code, viewer.Body)
+	}
+
+	watch := func(query string) int {
+		rec := httptest.NewRecorder()
+		requireWatcher(rec, httptest.NewRequest("GET", "/watch?code="+code+query, nil))
+		return rec.Code
+	}
+	if got := watch("&clientID=" + viewer.Body.String()); got != http.StatusForbidden {
+		t.Errorf("/watch sin token = %d, se esperaba 403", got)
+	}
+	if got := watch("&clientID=" + viewer.Body.String() + "&token=" + viewer.Header().Get("X-Client-Token")); got != http.StatusOK {
+		t.Errorf("/watch con token = %d, se esperaba 200", got)
+	}
+	// Un viewer no puede crear más enlaces
+	if rec := share("&clientID=" + viewer.Body.String() + "&token=" + viewer.Header().Get("X-Client-Token")); rec.Code != http.StatusForbidden {
+		t.Errorf("POST /share de un viewer = %d, se esperaba 403", rec.Code)
+	}
+}
+
+// Host y X-Forwarded-Proto sólo se creen si la conexión llega desde loopback
+func TestPublicBaseURL(t *testing.T) {
+	local := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 8080}
+	tests := []struct {
+		name   string
+		remote string
+		want   string
+	}{
+		{"túnel local", "127.0.0.1:5000", "https://evil.example"},
+		{"conexión directa", "203.0.113.7:5000", "http://192.168.1.10:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/qr", nil)
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+			r.RemoteAddr = tt.remote
+			r.Host = "evil.example"
+			r.Header.Set("X-Forwarded-Proto", "https")
+			if got := publicBaseURL(r); got != tt.want {
+				t.Errorf("publicBaseURL = %q, se esperaba %q", got, tt.want)
+			}
+		})
+	}
+}
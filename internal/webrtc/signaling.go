@@ -2,7 +2,11 @@ package webrtc
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
 )
 
 // SDPMessage representa la estructura JSON intercambiada con el frontend
@@ -23,28 +27,48 @@ func handleWebRTCStream(w http.ResponseWriter, r *http.Request, code string) {
 		http.Error(w, "Canal no encontrado", http.StatusBadRequest)
 		return
 	}
+	if src := channel.GetRemoteSource(); src != nil {
+		http.Error(w, "El canal se recibe de otro servidor ("+src.Origin+") y no admite publicadores", http.StatusConflict)
+		return
+	}
+	// Los canales guardados esperan a su publicador aunque aún no tengan viewers
 	clients := connectionManager.ListClients(code)
-	if len(clients) == 0 {
+	if len(clients) == 0 && !channel.IsPersistent() {
 		connectionManager.RemoveChannel(code)
 		http.Error(w, "Ya no hay viewers activos en el canal. El canal ha sido eliminado.", http.StatusBadRequest)
 		return
 	}
-	streamID := len(connectionManager.ListAllStreams()) + 1
+	// Cada canal tiene un único pipeline MJPEG; el primer publicador lo arranca
+	if !channel.IsFFmpegMJPEGActive() && pipelinesFull() {
+		writeLimitError(w, http.StatusServiceUnavailable, limitPipelines, "se ha alcanzado el máximo de transcodificadores simultáneos", 0)
+		return
+	}
+	streamID := generateStreamID()
 	if _, err := channel.AttachStream(streamID); err != nil {
+		if errors.Is(err, relay.ErrPublisherLimit) {
+			writeRelayError(w, "", err)
+			return
+		}
 		http.Error(w, "Error interno creando el stream", http.StatusInternalServerError)
 		return
 	}
+	// Una oferta fallida no debe quedarse con la plaza de publicador
 	peerConnection, answer, err := CreateWebRTCSession(offerMsg, code, streamID)
 	if err != nil {
+		logging.For("signaling").Warn("Error creando la sesión del publicador", "channel", code, "stream_id", streamID, "err", err)
+		channel.DetachStream(streamID)
 		http.Error(w, "Error interno WebRTC", http.StatusInternalServerError)
 		return
 	}
 	if err := channel.AssociateStreamPeerConnection(streamID, peerConnection); err != nil {
+		peerConnection.Close()
+		channel.DetachStream(streamID)
 		http.Error(w, "Error interno asociando PeerConnection", http.StatusInternalServerError)
 		return
 	}
-	err = channel.StartStream(streamID)
-	if err != nil {
+	if err := channel.StartStream(streamID); err != nil {
+		peerConnection.Close()
+		channel.DetachStream(streamID)
 		http.Error(w, "Error interno iniciando el stream", http.StatusInternalServerError)
 		return
 	}
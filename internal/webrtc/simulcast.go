@@ -0,0 +1,345 @@
+package webrtc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+// layer es una codificación (RID) de vídeo recibida del publicador
+type layer struct {
+	rid      string
+	ssrc     uint32
+	streamID int
+	codec    webrtc.RTPCodecParameters
+	// order es la posición de la capa entre las codificaciones anunciadas por
+	// el publicador, que las envía de menor a mayor calidad (q, h, f)
+	order int
+	// bitrate medido en bits/s durante la última ventana de un segundo
+	bitrate     int
+	windowBytes int
+	windowStart time.Time
+}
+
+// layerSubscriber recibe los paquetes de una de las capas del canal
+type layerSubscriber struct {
+	rid   string
+	write func(pkt *rtp.Packet, l *layer)
+}
+
+// layerHub distribuye los paquetes RTP de cada capa simulcast de un canal a los
+// viewers WebRTC suscritos. Sólo se reenvían los paquetes del stream activo.
+type layerHub struct {
+	mu              sync.RWMutex
+	code            string
+	layers          map[string]*layer
+	subs            map[*layerSubscriber]struct{}
+	requestKeyframe func(ssrc uint32)
+}
+
+// layerHubs indexa los hubs por código de canal. Un hub existe mientras el
+// canal tiene capas de un publicador o viewers WebRTC suscritos; los cambios
+// de capas y suscriptores toman primero este lock y después el del hub.
+var layerHubs = struct {
+	sync.Mutex
+	m map[string]*layerHub
+}{m: make(map[string]*layerHub)}
+
+// lookupLayerHub devuelve el hub de capas de un canal, o nil si no tiene
+func lookupLayerHub(code string) *layerHub {
+	layerHubs.Lock()
+	defer layerHubs.Unlock()
+	return layerHubs.m[code]
+}
+
+// idle indica si el hub ya no tiene capas ni suscriptores. Debe llamarse con h.mu tomado.
+func (h *layerHub) idle() bool {
+	return len(h.layers) == 0 && len(h.subs) == 0
+}
+
+// addLayer registra una capa del publicador en el hub del canal, creándolo si
+// no existe, y lo devuelve. requestKeyframe permite pedir un keyframe al
+// publicador cuando un viewer cambia a esta capa.
+func addLayer(code string, streamID int, rid string, order int, ssrc uint32, codec webrtc.RTPCodecParameters, requestKeyframe func(ssrc uint32)) *layerHub {
+	layerHubs.Lock()
+	defer layerHubs.Unlock()
+	h, ok := layerHubs.m[code]
+	if !ok {
+		h = &layerHub{
+			code:   code,
+			layers: make(map[string]*layer),
+			subs:   make(map[*layerSubscriber]struct{}),
+		}
+		layerHubs.m[code] = h
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.layers[rid] = &layer{rid: rid, ssrc: ssrc, streamID: streamID, codec: codec, order: order, windowStart: time.Now()}
+	h.requestKeyframe = requestKeyframe
+	return h
+}
+
+// removeLayerStream elimina las capas del stream indicado y descarta el hub
+// del canal si se queda sin capas ni suscriptores
+func removeLayerStream(code string, streamID int) {
+	layerHubs.Lock()
+	defer layerHubs.Unlock()
+	h, ok := layerHubs.m[code]
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for rid, l := range h.layers {
+		if l.streamID == streamID {
+			delete(h.layers, rid)
+		}
+	}
+	if h.idle() {
+		delete(layerHubs.m, code)
+	}
+}
+
+// requestKeyframes pide un keyframe de cada capa del stream indicado y devuelve cuántas capas hay
+func (h *layerHub) requestKeyframes(streamID int) int {
+	h.mu.RLock()
+	var ssrcs []uint32
+	for _, l := range h.layers {
+		if l.streamID == streamID {
+			ssrcs = append(ssrcs, l.ssrc)
+		}
+	}
+	requestKeyframe := h.requestKeyframe
+	h.mu.RUnlock()
+	if requestKeyframe != nil {
+		for _, ssrc := range ssrcs {
+			requestKeyframe(ssrc)
+		}
+	}
+	return len(ssrcs)
+}
+
+// dispatch entrega un paquete de la capa rid a sus suscriptores y actualiza el bitrate medido
+func (h *layerHub) dispatch(streamID int, rid string, pkt *rtp.Packet) {
+	if channel, exists := connectionManager.ValidateChannel(h.code); exists {
+		if active := channel.GetActiveStreamID(); active == nil || *active != streamID {
+			return
+		}
+	}
+	h.mu.Lock()
+	l, ok := h.layers[rid]
+	if !ok || l.streamID != streamID {
+		h.mu.Unlock()
+		return
+	}
+	l.windowBytes += len(pkt.Payload) + 12
+	if elapsed := time.Since(l.windowStart); elapsed >= time.Second {
+		l.bitrate = int(float64(l.windowBytes*8) / elapsed.Seconds())
+		l.windowBytes = 0
+		l.windowStart = time.Now()
+	}
+	snapshot := *l
+	h.mu.Unlock()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if sub.rid == rid {
+			sub.write(pkt, &snapshot)
+		}
+	}
+}
+
+// subscribe añade un suscriptor a la capa rid. Si el hub se descartó mientras
+// el viewer negociaba y el canal ya tiene otro (el publicador ha vuelto a
+// conectar), devuelve false y el viewer debe reconectar.
+func (h *layerHub) subscribe(sub *layerSubscriber) bool {
+	layerHubs.Lock()
+	defer layerHubs.Unlock()
+	if current, ok := layerHubs.m[h.code]; ok && current != h {
+		return false
+	}
+	layerHubs.m[h.code] = h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	return true
+}
+
+// unsubscribe elimina un suscriptor y descarta el hub si se queda sin capas ni suscriptores
+func (h *layerHub) unsubscribe(sub *layerSubscriber) {
+	layerHubs.Lock()
+	defer layerHubs.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+	if h.idle() && layerHubs.m[h.code] == h {
+		delete(layerHubs.m, h.code)
+	}
+}
+
+// switchLayer mueve al suscriptor a otra capa y pide un keyframe de la nueva
+func (h *layerHub) switchLayer(sub *layerSubscriber, rid string) {
+	h.mu.Lock()
+	sub.rid = rid
+	l, ok := h.layers[rid]
+	requestKeyframe := h.requestKeyframe
+	h.mu.Unlock()
+	if ok && requestKeyframe != nil {
+		requestKeyframe(l.ssrc)
+	}
+}
+
+// currentLayer devuelve la capa a la que está suscrito sub
+func (h *layerHub) currentLayer(sub *layerSubscriber) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return sub.rid
+}
+
+// layersByBitrate devuelve las capas disponibles ordenadas de menor a mayor
+// bitrate. Mientras alguna capa no tenga bitrate medido (el primer segundo) se
+// ordenan como las anunció el publicador, para que un viewer nuevo empiece
+// por la capa más baja.
+func (h *layerHub) layersByBitrate() []layer {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]layer, 0, len(h.layers))
+	measured := true
+	for _, l := range h.layers {
+		out = append(out, *l)
+		if l.bitrate == 0 {
+			measured = false
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if measured && out[i].bitrate != out[j].bitrate {
+			return out[i].bitrate < out[j].bitrate
+		}
+		return out[i].order < out[j].order
+	})
+	return out
+}
+
+// layerOrder devuelve la posición de rid entre las capas anunciadas por el publicador
+func layerOrder(receiver *webrtc.RTPReceiver, rid string) int {
+	for i, t := range receiver.Tracks() {
+		if t.RID() == rid {
+			return i
+		}
+	}
+	return 0
+}
+
+// mjpegLayer elige la capa que alimenta el pipeline MJPEG: la configurada si el
+// publicador la envía o, si no, la capa intermedia de las anunciadas.
+func mjpegLayer(receiver *webrtc.RTPReceiver) string {
+	tracks := receiver.Tracks()
+	if len(tracks) <= 1 {
+		if len(tracks) == 1 {
+			return tracks[0].RID()
+		}
+		return ""
+	}
+	for _, t := range tracks {
+		if t.RID() == serverConfig.Simulcast.MJPEGLayer {
+			return t.RID()
+		}
+	}
+	return tracks[len(tracks)/2].RID()
+}
+
+// packetRewriter mantiene continuos los números de secuencia y timestamps
+// cuando un viewer cambia de capa, y descarta paquetes hasta el primer keyframe.
+type packetRewriter struct {
+	ssrc         uint32
+	seqOffset    uint16
+	tsOffset     uint32
+	lastSeq      uint16
+	lastTS       uint32
+	started      bool
+	waitKeyframe bool
+}
+
+// rewrite adapta pkt a la numeración de salida. Devuelve false si hay que descartarlo.
+func (w *packetRewriter) rewrite(pkt *rtp.Packet, l *layer) bool {
+	if pkt.SSRC != w.ssrc {
+		if !isKeyframe(l.codec.MimeType, pkt.Payload) {
+			w.waitKeyframe = true
+			return false
+		}
+		if w.started {
+			w.seqOffset = w.lastSeq + 1 - pkt.SequenceNumber
+			w.tsOffset = w.lastTS + 3000 - pkt.Timestamp
+		}
+		w.ssrc = pkt.SSRC
+		w.waitKeyframe = false
+	}
+	if w.waitKeyframe {
+		return false
+	}
+	pkt.SequenceNumber += w.seqOffset
+	pkt.Timestamp += w.tsOffset
+	w.lastSeq = pkt.SequenceNumber
+	w.lastTS = pkt.Timestamp
+	w.started = true
+	return true
+}
+
+// isKeyframe detecta si el payload RTP contiene (el inicio de) un keyframe
+func isKeyframe(mimeType string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	switch mimeType {
+	case webrtc.MimeTypeVP8:
+		vp8 := &codecs.VP8Packet{}
+		if _, err := vp8.Unmarshal(payload); err != nil || len(vp8.Payload) == 0 {
+			return false
+		}
+		return vp8.S == 1 && vp8.PID == 0 && vp8.Payload[0]&0x01 == 0
+	case webrtc.MimeTypeVP9:
+		vp9 := &codecs.VP9Packet{}
+		if _, err := vp9.Unmarshal(payload); err != nil {
+			return false
+		}
+		return vp9.B && !vp9.P
+	case webrtc.MimeTypeH264:
+		return h264HasKeyframe(payload)
+	case webrtc.MimeTypeAV1:
+		// Bit N del aggregation header: comienzo de una nueva secuencia de vídeo
+		return payload[0]&0x08 != 0
+	}
+	return true
+}
+
+// h264HasKeyframe busca NALUs IDR o SPS en un payload RTP H.264 (single, STAP-A o FU-A)
+func h264HasKeyframe(payload []byte) bool {
+	nalType := payload[0] & 0x1F
+	switch nalType {
+	case 5, 7:
+		return true
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if i >= len(payload) {
+				break
+			}
+			if t := payload[i] & 0x1F; t == 5 || t == 7 {
+				return true
+			}
+			i += size
+		}
+	case 28: // FU-A
+		if len(payload) > 1 && payload[1]&0x80 != 0 {
+			t := payload[1] & 0x1F
+			return t == 5 || t == 7
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package webrtc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLayersByBitrate(t *testing.T) {
+	type in struct {
+		rid     string
+		order   int
+		bitrate int
+	}
+	tests := []struct {
+		name   string
+		layers []in
+		want   []string
+	}{
+		{"sin medir: orden anunciado", []in{{"f", 2, 0}, {"q", 0, 0}, {"h", 1, 0}}, []string{"q", "h", "f"}},
+		{"medidas en parte: orden anunciado", []in{{"f", 2, 0}, {"q", 0, 150000}, {"h", 1, 0}}, []string{"q", "h", "f"}},
+		{"medidas", []in{{"f", 2, 1400000}, {"q", 0, 140000}, {"h", 1, 480000}}, []string{"q", "h", "f"}},
+		{"la medida manda sobre el anuncio", []in{{"a", 0, 900000}, {"b", 1, 100000}}, []string{"b", "a"}},
+		{"empate medido", []in{{"y", 1, 500}, {"x", 0, 500}}, []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &layerHub{layers: make(map[string]*layer)}
+			for _, l := range tt.layers {
+				h.layers[l.rid] = &layer{rid: l.rid, order: l.order, bitrate: l.bitrate}
+			}
+			var got []string
+			for _, l := range h.layersByBitrate() {
+				got = append(got, l.rid)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("layersByBitrate = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// El hub de un canal desaparece cuando se queda sin capas ni suscriptores
+func TestLayerHubLifecycle(t *testing.T) {
+	const code = "TEST-HUB"
+	if lookupLayerHub(code) != nil {
+		t.Fatal("hay un hub antes de que el publicador envíe capas")
+	}
+	h := addLayer(code, 1, "q", 0, 1111, vp8Codec, nil)
+	addLayer(code, 1, "f", 1, 2222, vp8Codec, nil)
+	if lookupLayerHub(code) != h {
+		t.Fatal("las capas del publicador no comparten hub")
+	}
+	sub := &layerSubscriber{}
+	if !h.subscribe(sub) {
+		t.Fatal("subscribe falló en el hub vigente")
+	}
+	removeLayerStream(code, 1)
+	if lookupLayerHub(code) != h {
+		t.Fatal("el hub se descartó con un viewer suscrito")
+	}
+	h.unsubscribe(sub)
+	if lookupLayerHub(code) != nil {
+		t.Fatal("el hub sigue registrado sin capas ni suscriptores")
+	}
+
+	// Un viewer que negoció con el hub descartado se suscribe de nuevo a él si
+	// el canal no tiene otro, pero no si el publicador ya creó uno nuevo
+	if !h.subscribe(sub) || lookupLayerHub(code) != h {
+		t.Fatal("el hub descartado no se recuperó al suscribirse")
+	}
+	h.unsubscribe(sub)
+	newer := addLayer(code, 2, "q", 0, 3333, vp8Codec, nil)
+	if h.subscribe(&layerSubscriber{}) {
+		t.Error("un viewer se suscribió a un hub sustituido")
+	}
+	removeLayerStream(code, 2)
+	if lookupLayerHub(code) != nil || !newer.idle() {
+		t.Error("el hub nuevo no se descartó al terminar su stream")
+	}
+}
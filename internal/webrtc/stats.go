@@ -0,0 +1,318 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// trackMeter mide lo que el interceptor de estadísticas no sabe de un track:
+// bitrate, frames por segundo y resolución (leída de los keyframes). También
+// calcula el jitter, porque el del interceptor no usa una referencia fija para
+// las llegadas y da valores sin sentido.
+type trackMeter struct {
+	ssrc      uint32
+	kind      string
+	rid       string
+	mimeType  string
+	clockRate uint32
+
+	mu            sync.Mutex
+	windowStart   time.Time
+	windowBytes   int
+	windowFrames  int
+	lastTS        uint32
+	started       bool
+	bitrate       int // bits/s durante la última ventana de un segundo
+	fps           float64
+	width         int
+	height        int
+	lastArrival   time.Time
+	lastArrivalTS uint32
+	jitter        float64 // en unidades del reloj RTP
+}
+
+// observe contabiliza un paquete recibido
+func (m *trackMeter) observe(pkt *rtp.Packet) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.windowStart.IsZero() {
+		m.windowStart = now
+	}
+	// Jitter entre llegadas (RFC 3550 6.4.1), con diferencias de timestamp para
+	// sobrevivir a la vuelta del contador RTP
+	if m.clockRate > 0 && !m.lastArrival.IsZero() {
+		d := now.Sub(m.lastArrival).Seconds()*float64(m.clockRate) - float64(int32(pkt.Timestamp-m.lastArrivalTS))
+		if d < 0 {
+			d = -d
+		}
+		m.jitter += (d - m.jitter) / 16
+	}
+	m.lastArrival, m.lastArrivalTS = now, pkt.Timestamp
+	m.windowBytes += len(pkt.Payload)
+	// Cada timestamp RTP nuevo es un frame; la resolución viaja al principio de los keyframes
+	if m.kind == "video" && (!m.started || pkt.Timestamp != m.lastTS) {
+		m.started = true
+		m.lastTS = pkt.Timestamp
+		m.windowFrames++
+		if w, h, ok := frameSize(m.mimeType, pkt.Payload); ok {
+			m.width, m.height = w, h
+		}
+	}
+	if elapsed := now.Sub(m.windowStart); elapsed >= time.Second {
+		m.bitrate = int(float64(m.windowBytes*8) / elapsed.Seconds())
+		m.fps = float64(m.windowFrames) / elapsed.Seconds()
+		m.windowStart, m.windowBytes, m.windowFrames = now, 0, 0
+	}
+}
+
+// publisherSession agrupa el PeerConnection de un publicador, el lector del
+// interceptor de estadísticas y los medidores de sus tracks
+type publisherSession struct {
+	code     string
+	streamID int
+	pc       *webrtc.PeerConnection
+
+	mu     sync.Mutex
+	getter stats.Getter
+	tracks []*trackMeter
+}
+
+// publisherSessions indexa las sesiones de los publicadores por canal y stream
+var publisherSessions = struct {
+	sync.Mutex
+	m map[string]map[int]*publisherSession
+}{m: make(map[string]map[int]*publisherSession)}
+
+// newPublisherSession crea la sesión y registra en registry el interceptor de
+// estadísticas, que entrega su lector al crearse el PeerConnection
+func newPublisherSession(code string, streamID int, registry *interceptor.Registry) (*publisherSession, error) {
+	s := &publisherSession{code: code, streamID: streamID}
+	factory, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	factory.OnNewPeerConnection(func(_ string, g stats.Getter) {
+		s.mu.Lock()
+		s.getter = g
+		s.mu.Unlock()
+	})
+	registry.Add(factory)
+	return s, nil
+}
+
+// register publica la sesión en /stats/session y /metrics
+func (s *publisherSession) register(pc *webrtc.PeerConnection) {
+	s.mu.Lock()
+	s.pc = pc
+	s.mu.Unlock()
+	publisherSessions.Lock()
+	defer publisherSessions.Unlock()
+	if publisherSessions.m[s.code] == nil {
+		publisherSessions.m[s.code] = make(map[int]*publisherSession)
+	}
+	publisherSessions.m[s.code][s.streamID] = s
+}
+
+// unregister retira la sesión al cerrarse el PeerConnection
+func (s *publisherSession) unregister() {
+	publisherSessions.Lock()
+	defer publisherSessions.Unlock()
+	if publisherSessions.m[s.code][s.streamID] == s {
+		delete(publisherSessions.m[s.code], s.streamID)
+		if len(publisherSessions.m[s.code]) == 0 {
+			delete(publisherSessions.m, s.code)
+		}
+	}
+}
+
+// newTrackMeter crea el medidor de un track y lo añade a la sesión de su publicador
+func newTrackMeter(code string, streamID int, track *webrtc.TrackRemote) *trackMeter {
+	m := &trackMeter{
+		ssrc:      uint32(track.SSRC()),
+		kind:      track.Kind().String(),
+		rid:       track.RID(),
+		mimeType:  track.Codec().MimeType,
+		clockRate: track.Codec().ClockRate,
+	}
+	publisherSessions.Lock()
+	s := publisherSessions.m[code][streamID]
+	publisherSessions.Unlock()
+	if s != nil {
+		s.mu.Lock()
+		s.tracks = append(s.tracks, m)
+		s.mu.Unlock()
+	}
+	return m
+}
+
+// lastPacket devuelve la llegada del último paquete de cualquiera de sus tracks
+// (cero si aún no ha llegado ninguno)
+func (s *publisherSession) lastPacket() time.Time {
+	s.mu.Lock()
+	meters := slices.Clone(s.tracks)
+	s.mu.Unlock()
+	var last time.Time
+	for _, m := range meters {
+		m.mu.Lock()
+		if m.lastArrival.After(last) {
+			last = m.lastArrival
+		}
+		m.mu.Unlock()
+	}
+	return last
+}
+
+// candidatePairStats describe el par de candidatos ICE en uso
+type candidatePairStats struct {
+	LocalType  string  `json:"localType"`
+	RemoteType string  `json:"remoteType"`
+	Protocol   string  `json:"protocol"`
+	RTTMs      float64 `json:"rttMs"`
+}
+
+// trackStats son las estadísticas de un track recibido del publicador
+type trackStats struct {
+	SSRC            uint32  `json:"ssrc"`
+	Kind            string  `json:"kind"`
+	RID             string  `json:"rid,omitempty"`
+	Codec           string  `json:"codec"`
+	BitrateKbps     float64 `json:"bitrateKbps"`
+	PacketsReceived uint64  `json:"packetsReceived"`
+	PacketsLost     int64   `json:"packetsLost"`
+	JitterMs        float64 `json:"jitterMs"`
+	NACKCount       uint32  `json:"nackCount"`
+	PLICount        uint32  `json:"pliCount"`
+	FIRCount        uint32  `json:"firCount"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	FPS             float64 `json:"fps,omitempty"`
+}
+
+// sessionStats son las estadísticas de un publicador que se sirven en /stats/session
+type sessionStats struct {
+	Code          string              `json:"code"`
+	StreamID      int                 `json:"streamId"`
+	State         string              `json:"state"`
+	CandidatePair *candidatePairStats `json:"candidatePair,omitempty"`
+	Tracks        []trackStats        `json:"tracks"`
+}
+
+// snapshot combina el interceptor de estadísticas, los medidores y el par ICE seleccionado
+func (s *publisherSession) snapshot() sessionStats {
+	s.mu.Lock()
+	pc, getter := s.pc, s.getter
+	meters := slices.Clone(s.tracks)
+	s.mu.Unlock()
+
+	out := sessionStats{Code: s.code, StreamID: s.streamID, Tracks: make([]trackStats, 0, len(meters))}
+	if pc != nil {
+		out.State = pc.ConnectionState().String()
+		out.CandidatePair = selectedCandidatePair(pc)
+	}
+	now := time.Now()
+	for _, m := range meters {
+		m.mu.Lock()
+		t := trackStats{SSRC: m.ssrc, Kind: m.kind, RID: m.rid, Codec: m.mimeType, Width: m.width, Height: m.height}
+		if m.clockRate > 0 {
+			t.JitterMs = m.jitter / float64(m.clockRate) * 1000
+		}
+		// Sin paquetes en la última ventana el track está parado
+		if now.Sub(m.windowStart) < 2*time.Second {
+			t.BitrateKbps = float64(m.bitrate) / 1000
+			t.FPS = m.fps
+		}
+		m.mu.Unlock()
+		if getter != nil {
+			if st := getter.Get(m.ssrc); st != nil {
+				in := st.InboundRTPStreamStats
+				t.PacketsReceived = in.PacketsReceived
+				t.PacketsLost = in.PacketsLost
+				t.NACKCount, t.PLICount, t.FIRCount = in.NACKCount, in.PLICount, in.FIRCount
+			}
+		}
+		out.Tracks = append(out.Tracks, t)
+	}
+	return out
+}
+
+// selectedCandidatePair busca el par nominado en las estadísticas del PeerConnection
+func selectedCandidatePair(pc *webrtc.PeerConnection) *candidatePairStats {
+	report := pc.GetStats()
+	for _, st := range report {
+		pair, ok := st.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+		out := &candidatePairStats{RTTMs: pair.CurrentRoundTripTime * 1000}
+		if local, ok := report[pair.LocalCandidateID].(webrtc.ICECandidateStats); ok {
+			out.LocalType = local.CandidateType.String()
+			out.Protocol = local.Protocol
+		}
+		if remote, ok := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats); ok {
+			out.RemoteType = remote.CandidateType.String()
+		}
+		return out
+	}
+	return nil
+}
+
+// sessionsSnapshot devuelve las estadísticas de los publicadores de un canal
+// (todos los canales si code está vacío), ordenadas por canal y stream
+func sessionsSnapshot(code string) []sessionStats {
+	publisherSessions.Lock()
+	var sessions []*publisherSession
+	for c, streams := range publisherSessions.m {
+		if code != "" && c != code {
+			continue
+		}
+		for _, s := range streams {
+			sessions = append(sessions, s)
+		}
+	}
+	publisherSessions.Unlock()
+	out := make([]sessionStats, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, s.snapshot())
+	}
+	slices.SortFunc(out, func(a, b sessionStats) int {
+		if a.Code != b.Code {
+			if a.Code < b.Code {
+				return -1
+			}
+			return 1
+		}
+		return a.StreamID - b.StreamID
+	})
+	return out
+}
+
+// sessionStatsHandler devuelve en JSON las estadísticas de los publicadores de
+// un canal: GET /stats/session?code=ABC (&stream=N para uno solo)
+func sessionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	sessions := sessionsSnapshot(code)
+	if v := r.URL.Query().Get("stream"); v != "" {
+		streamID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "stream inválido", http.StatusBadRequest)
+			return
+		}
+		sessions = slices.DeleteFunc(sessions, func(s sessionStats) bool { return s.StreamID != streamID })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
@@ -0,0 +1,457 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/pkg/relay"
+)
+
+// channelsBucket es el bucket de bbolt con las definiciones de canal por código
+var channelsBucket = []byte("channels")
+
+var errStoreDisabled = errors.New("la persistencia de canales no está activada (store.path)")
+
+// channelRecord es la definición persistente de un canal: lo que hace falta
+// para recrearlo tras un reinicio a la espera de su publicador. El servidor no
+// tiene overlays por canal, así que no hay nada de eso que guardar; la
+// grabación (DVR) es global y de cada canal sólo se guarda su detección de
+// movimiento.
+type channelRecord struct {
+	Code       string   `json:"code"`
+	Name       string   `json:"name,omitempty"`
+	Codecs     []string `json:"codecs,omitempty"` // tipos MIME por orden de preferencia
+	MaxKbps    int      `json:"maxKbps,omitempty"`
+	Transcoder string   `json:"transcoder,omitempty"`
+	Origin     string   `json:"origin,omitempty"` // canal recibido de otro servidor (edge)
+	RemoteCode string   `json:"remoteCode,omitempty"`
+	Share      string   `json:"share,omitempty"` // enlace compartido del origen; no se devuelve en la API
+	// Motion es la detección de movimiento del canal (nil = la de motion)
+	Motion *motionSettings `json:"motion,omitempty"`
+	// OperatorKeyHash es el SHA-256 de la clave de operador, que se entrega una
+	// sola vez al guardar el canal y hace falta para cambiarlo, borrarlo o ser
+	// su operador tras un reinicio. Nunca se devuelve en la API.
+	OperatorKeyHash string    `json:"operatorKeyHash,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+func (rec channelRecord) policy() relay.MediaPolicy {
+	return relay.MediaPolicy{
+		CodecPreference: append([]string(nil), rec.Codecs...),
+		MaxBitrateKbps:  rec.MaxKbps,
+		Transcoder:      rec.Transcoder,
+	}
+}
+
+func (rec *channelRecord) setPolicy(policy relay.MediaPolicy) {
+	rec.Codecs = policy.CodecPreference
+	rec.MaxKbps = policy.MaxBitrateKbps
+	rec.Transcoder = policy.Transcoder
+}
+
+// channelInfo es una definición guardada junto al estado actual del canal (/channels)
+type channelInfo struct {
+	channelRecord
+	State   string `json:"state"` // waiting (sin publicador) o live
+	Viewers int    `json:"viewers"`
+}
+
+// channelStore es la base de datos bbolt con las definiciones de canal (nil = sin persistencia)
+var channelStore = struct {
+	sync.Mutex
+	db *bolt.DB
+}{}
+
+// hashOperatorKey devuelve el hash que se guarda de una clave de operador
+func hashOperatorKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestOperatorKey devuelve la clave de operador de la petición (?key= o cabecera X-Operator-Key)
+func requestOperatorKey(r *http.Request) string {
+	if key := r.Header.Get("X-Operator-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+// checkOperatorKey comprueba key contra la clave de operador del canal guardado
+func checkOperatorKey(code, key string) bool {
+	if key == "" {
+		return false
+	}
+	rec, found, err := loadChannelRecord(code)
+	if err != nil || !found || rec.OperatorKeyHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashOperatorKey(key)), []byte(rec.OperatorKeyHash)) == 1
+}
+
+// openChannelStore abre (o crea) el fichero de definiciones de canal
+func openChannelStore(path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("store.path: %w", err)
+		}
+	}
+	// Con otra instancia usando el mismo fichero, Open esperaría indefinidamente al bloqueo
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("no se pudo abrir %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(channelsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("no se pudo preparar %s: %w", path, err)
+	}
+	channelStore.Lock()
+	channelStore.db = db
+	channelStore.Unlock()
+	return nil
+}
+
+// closeChannelStore cierra la base de datos al apagar
+func closeChannelStore() {
+	channelStore.Lock()
+	defer channelStore.Unlock()
+	if channelStore.db != nil {
+		if err := channelStore.db.Close(); err != nil {
+			logging.For("store").Warn("Error cerrando el store de canales", "err", err)
+		}
+		channelStore.db = nil
+	}
+}
+
+// storeDB devuelve la base de datos abierta o errStoreDisabled
+func storeDB() (*bolt.DB, error) {
+	channelStore.Lock()
+	defer channelStore.Unlock()
+	if channelStore.db == nil {
+		return nil, errStoreDisabled
+	}
+	return channelStore.db, nil
+}
+
+// saveChannelRecord guarda la definición del canal, conservando su fecha de creación
+func saveChannelRecord(rec channelRecord) error {
+	db, err := storeDB()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(channelsBucket)
+		now := time.Now()
+		rec.CreatedAt, rec.UpdatedAt = now, now
+		if old := b.Get([]byte(rec.Code)); old != nil {
+			var prev channelRecord
+			if json.Unmarshal(old, &prev) == nil && !prev.CreatedAt.IsZero() {
+				rec.CreatedAt = prev.CreatedAt
+			}
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(rec.Code), data)
+	})
+}
+
+// loadChannelRecord lee la definición guardada de un canal
+func loadChannelRecord(code string) (channelRecord, bool, error) {
+	var rec channelRecord
+	db, err := storeDB()
+	if err != nil {
+		return rec, false, err
+	}
+	found := false
+	err = db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(channelsBucket).Get([]byte(code))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, found, err
+}
+
+// deleteChannelRecord borra la definición de un canal; devuelve false si no estaba guardada
+func deleteChannelRecord(code string) (bool, error) {
+	db, err := storeDB()
+	if err != nil {
+		return false, err
+	}
+	found := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(channelsBucket)
+		if b.Get([]byte(code)) == nil {
+			return nil
+		}
+		found = true
+		return b.Delete([]byte(code))
+	})
+	return found, err
+}
+
+// channelRecords devuelve todas las definiciones guardadas ordenadas por código
+func channelRecords() ([]channelRecord, error) {
+	db, err := storeDB()
+	if err != nil {
+		return nil, err
+	}
+	var records []channelRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(channelsBucket).ForEach(func(k, v []byte) error {
+			var rec channelRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				logging.For("store").Warn("Definición de canal ilegible, se ignora", "channel", string(k), "err", err)
+				return nil
+			}
+			records = append(records, rec)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// restoreChannel recrea un canal guardado a la espera de su publicador (o
+// vuelve a tirar de su origen si es un edge)
+func restoreChannel(rec channelRecord) error {
+	if rec.Origin != "" {
+		err := startEdgePull(rec.Code, relay.RemoteSource{Origin: rec.Origin, Code: rec.RemoteCode, Share: rec.Share})
+		// Los canales de edge.channels ya se reciben desde initEdge
+		if err != nil && !errors.Is(err, errEdgeExists) {
+			return err
+		}
+	} else if err := connectionManager.CreateChannel(rec.Code); err != nil {
+		return err
+	}
+	channel, exists := connectionManager.ValidateChannel(rec.Code)
+	if !exists {
+		return fmt.Errorf("el canal %s ha desaparecido", rec.Code)
+	}
+	channel.SetPersistent(true)
+	channel.SetMediaPolicy(rec.policy())
+	if rec.Motion != nil {
+		setMotionSettings(rec.Code, *rec.Motion)
+	}
+	return nil
+}
+
+// initStore abre el store de canales, si está configurado, y recrea los canales guardados
+func initStore(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := openChannelStore(path); err != nil {
+		return err
+	}
+	records, err := channelRecords()
+	if err != nil {
+		return fmt.Errorf("no se pudieron leer los canales guardados: %w", err)
+	}
+	restored := 0
+	for _, rec := range records {
+		if err := restoreChannel(rec); err != nil {
+			logging.For("store").Warn("No se pudo restaurar el canal", "channel", rec.Code, "err", err)
+			continue
+		}
+		restored++
+	}
+	logging.For("store").Info("Canales restaurados", "path", path, "channels", restored, "stored", len(records))
+	return nil
+}
+
+// persistChannel guarda la definición actual de un canal existente (política
+// de medios, origen y detección de movimiento) con el nombre indicado, o el
+// que ya tuviera si es vacío. La primera vez genera la clave de operador y la
+// devuelve; después devuelve "".
+func persistChannel(code, name string) (string, error) {
+	channel, exists := connectionManager.ValidateChannel(code)
+	if !exists {
+		return "", fmt.Errorf("el canal %s no existe", code)
+	}
+	rec, _, err := loadChannelRecord(code)
+	if err != nil {
+		return "", err
+	}
+	var key string
+	if rec.OperatorKeyHash == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		key = hex.EncodeToString(b)
+		rec.OperatorKeyHash = hashOperatorKey(key)
+	}
+	rec.Code = code
+	if name != "" {
+		rec.Name = name
+	}
+	rec.setPolicy(channel.GetMediaPolicy())
+	rec.Origin, rec.RemoteCode, rec.Share = "", "", ""
+	if src := channel.GetRemoteSource(); src != nil {
+		rec.Origin, rec.RemoteCode, rec.Share = src.Origin, src.Code, src.Share
+	}
+	if d := lookupMotion(code); d != nil {
+		settings := d.currentSettings()
+		rec.Motion = &settings
+	}
+	if err := saveChannelRecord(rec); err != nil {
+		return "", err
+	}
+	channel.SetPersistent(true)
+	logging.For("store").Info("Definición de canal guardada", "channel", code, "name", rec.Name, "origin", rec.Origin)
+	return key, nil
+}
+
+// updateChannelRecord aplica fn a la definición guardada del canal, si la tiene
+func updateChannelRecord(code string, fn func(rec *channelRecord)) error {
+	rec, found, err := loadChannelRecord(code)
+	if err != nil || !found {
+		return err
+	}
+	fn(&rec)
+	return saveChannelRecord(rec)
+}
+
+// forgetChannel borra la definición guardada; el canal se elimina en cuanto
+// se quede sin viewers ni publicadores
+func forgetChannel(code string) (bool, error) {
+	found, err := deleteChannelRecord(code)
+	if err != nil || !found {
+		return found, err
+	}
+	if channel, exists := connectionManager.ValidateChannel(code); exists {
+		channel.SetPersistent(false)
+		go channel.ChannelNeedToBeRemoved()
+	}
+	logging.For("store").Info("Definición de canal borrada", "channel", code)
+	return true, nil
+}
+
+// savedChannel es la respuesta de POST /channels: la definición guardada y,
+// sólo la primera vez, la clave de operador
+type savedChannel struct {
+	channelRecord
+	OperatorKey string `json:"operatorKey,omitempty"`
+}
+
+// channelsHandler gestiona las definiciones de canal guardadas:
+// GET /channels[?code=], POST /channels?code=&name=&codecs=&maxKbps=&transcoder=
+// y DELETE /channels?code=. Cambiar o borrar una definición exige su clave de
+// operador (?key= o cabecera X-Operator-Key).
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := storeDB(); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	switch r.Method {
+	case http.MethodGet:
+		records, err := channelRecords()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		infos := make([]channelInfo, 0, len(records))
+		for _, rec := range records {
+			if code != "" && rec.Code != code {
+				continue
+			}
+			rec.OperatorKeyHash, rec.Share = "", ""
+			info := channelInfo{channelRecord: rec, State: "waiting"}
+			if channel, exists := connectionManager.ValidateChannel(rec.Code); exists {
+				if channel.GetActiveStreamID() != nil {
+					info.State = "live"
+				}
+				info.Viewers = len(channel.ListClients())
+			}
+			infos = append(infos, info)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	case http.MethodPost:
+		if code == "" {
+			http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+			return
+		}
+		policy, err := parseMediaPolicy(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, saved, err := loadChannelRecord(code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if saved && !checkOperatorKey(code, requestOperatorKey(r)) {
+			http.Error(w, "Clave de operador inválida", http.StatusForbidden)
+			return
+		}
+		created, err := connectionManager.EnsureChannel(code)
+		if err != nil {
+			writeRelayError(w, "No se pudo crear el canal: ", err)
+			return
+		}
+		// Un canal en uso sin definición guardada lo guarda su operador con /register?persist=1
+		if !saved && !created {
+			http.Error(w, "El canal ya existe; su operador puede guardarlo con /register?persist=1", http.StatusConflict)
+			return
+		}
+		channel, exists := connectionManager.ValidateChannel(code)
+		if !exists {
+			http.Error(w, "El canal ha desaparecido", http.StatusInternalServerError)
+			return
+		}
+		if policy.CodecPreference != nil || policy.MaxBitrateKbps > 0 || policy.Transcoder != "" {
+			channel.SetMediaPolicy(policy)
+		}
+		key, err := persistChannel(code, r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "No se pudo guardar el canal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rec, _, _ := loadChannelRecord(code)
+		rec.OperatorKeyHash, rec.Share = "", ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(savedChannel{channelRecord: rec, OperatorKey: key})
+	case http.MethodDelete:
+		if _, saved, err := loadChannelRecord(code); err == nil && saved && !checkOperatorKey(code, requestOperatorKey(r)) {
+			http.Error(w, "Clave de operador inválida", http.StatusForbidden)
+			return
+		}
+		found, err := forgetChannel(code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Canal no guardado", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
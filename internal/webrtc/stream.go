@@ -2,16 +2,14 @@ package webrtc
 
 import (
 	"context"
-	"errors"
-	"log"
-	"net"
 	"net/http"
-	"sync"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 
 	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
 )
 
 // getTimestamp devuelve el timestamp actual en segundos
@@ -19,75 +17,91 @@ func getTimestamp() int64 {
 	return time.Now().Unix()
 }
 
-// udpConn representa una conexión UDP para reenviar RTP
-type udpConn struct {
-	conn        *net.UDPConn
-	port        int
-	payloadType uint8
-}
-
-// HandleTrack reempaqueta y reenvía RTP a UDP según el tipo de track
-var ffmpegMJPEGOnce sync.Once
-
-func HandleTrack(track *webrtc.TrackRemote, udpConns map[string]*udpConn, code string, streamID int) {
+// HandleTrack reordena el RTP del track y lo reparte entre los viewers WebRTC y el transcodificador
+func HandleTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver, code string, streamID int, requestKeyframe func(ssrc uint32)) {
 	buf := make([]byte, 1500)
 	rtpPacket := &rtp.Packet{}
+	// Con simulcast llega un track por cada RID: todas las capas se ofrecen a los
+	// viewers WebRTC, pero sólo una alimenta el pipeline MJPEG para ahorrar CPU.
+	var hub *layerHub
+	feedMJPEG := true
 	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		hub = addLayer(code, streamID, track.RID(), layerOrder(receiver, track.RID()), uint32(track.SSRC()), track.Codec(), requestKeyframe)
+		feedMJPEG = track.RID() == mjpegLayer(receiver)
+		logging.For("webrtc").Info("Capa de vídeo", "channel", code, "stream_id", streamID, "rid", track.RID(), "ssrc", track.SSRC(), "mjpeg", feedMJPEG)
+	}
+	// Los frames depaquetizados se escriben en la entrada estándar del
+	// transcodificador del canal (ffmpeg, gst-launch o el falso en memoria).
+	var feed *transcoderFeed
+	if track.Kind() == webrtc.RTPCodecTypeVideo && feedMJPEG {
 		channel, exists := connectionManager.ValidateChannel(code)
-		if exists && !channel.IsFFmpegMJPEGActive() {
-			channel.SetFFmpegMJPEGActive(true)
-			ctx, cancel := context.WithCancel(context.Background())
-			channel.SetFFmpegMJPEGCancel(cancel)
-			go func() {
-				err := RunFFmpegToMJPEG(ctx, func(frame []byte) {
+		// Comprobar, reservar y marcar activo en un solo paso: si dos tracks
+		// llegan a la vez, sólo uno arranca el pipeline y ocupa su plaza
+		claimed, limited := false, false
+		if exists {
+			claimed, limited = channel.ClaimFFmpegMJPEG(acquirePipeline)
+		}
+		if limited {
+			logging.For("transcoder").Warn("Máximo de transcodificadores alcanzado, el canal no tendrá MJPEG", "channel", code, "stream_id", streamID, "max_pipelines", serverConfig.Limits.MaxPipelines)
+		} else if claimed {
+			f, err := newTranscoderFeed(code, track.Codec(), func() { requestKeyframe(uint32(track.SSRC())) })
+			if err != nil {
+				releasePipeline()
+				channel.SetFFmpegMJPEGActive(false)
+				logging.For("transcoder").Error("Error preparando la entrada del transcodificador", "channel", code, "stream_id", streamID, "err", err)
+			} else {
+				feed = f
+				defer feed.close()
+				ctx, cancel := context.WithCancel(context.Background())
+				channel.SetFFmpegMJPEGCancel(cancel)
+				backend := channelMediaPolicy(code).Transcoder
+				logging.For("webrtc").Info("Códec negociado", "channel", code, "stream_id", streamID, "codec", track.Codec().MimeType, "fmtp", track.Codec().SDPFmtpLine, "transcoder", backend)
+				supervisor := newPipelineSupervisor(code, streamID, backend, feed, func(frame []byte) {
 					activeID := channel.GetActiveStreamID()
 					if activeID != nil {
 						connectionManager.BroadcastToStream(code, *activeID, frame)
-					} else {
-						return
 					}
 				})
-				if err != nil {
-					// Only log critical error
-					log.Printf("[HandleTrack] Error al ejecutar RunFFmpegToMJPEG: %v", err)
-				}
-				channel.SetFFmpegMJPEGActive(false)
-				channel.SetFFmpegMJPEGCancel(nil)
-			}()
+				go func() {
+					defer releasePipeline()
+					defer cancel()
+					supervisor.run(ctx)
+					channel.SetFFmpegMJPEGActive(false)
+					channel.SetFFmpegMJPEGCancel(nil)
+				}()
+			}
 		}
 	}
-	conn, ok := udpConns[track.Kind().String()]
-	if !ok {
-		return
-	}
+	// El jitter buffer entrega los paquetes en orden: primero a los viewers
+	// WebRTC y después, si esta capa alimenta MJPEG, al transcodificador.
+	jb := newJitterBuffer(serverConfig.Jitter.Latency, func(pkt *rtp.Packet) {
+		if hub != nil {
+			hub.dispatch(streamID, track.RID(), pkt)
+		}
+		if feed != nil {
+			feed.push(pkt)
+		}
+	})
+	kind := track.Kind().String()
+	registerJitterBuffer(code, streamID, kind, track.RID(), jb)
+	defer unregisterJitterBuffer(code, streamID, kind, track.RID())
+	meter := newTrackMeter(code, streamID, track)
 	for {
 		n, _, readErr := track.Read(buf)
 		if readErr != nil {
-			log.Printf("[OnTrack] Error leyendo track: %v", readErr)
+			logging.For("webrtc").Info("Error leyendo track", "channel", code, "stream_id", streamID, "kind", kind, "rid", track.RID(), "err", readErr)
 			return
 		}
 		if err := rtpPacket.Unmarshal(buf[:n]); err != nil {
-			log.Printf("[OnTrack] Error unmarshal RTP: %v", err)
-			return
-		}
-		rtpPacket.PayloadType = conn.payloadType
-		n, err := rtpPacket.MarshalTo(buf)
-		if err != nil {
-			log.Printf("[OnTrack] Error marshal RTP: %v", err)
-			return
-		}
-		if _, writeErr := conn.conn.Write(buf[:n]); writeErr != nil {
-			var opError *net.OpError
-			if errors.As(writeErr, &opError) && opError.Err.Error() == "write: connection refused" {
-				continue
-			}
-			log.Printf("[OnTrack] Error escribiendo UDP: %v", writeErr)
+			logging.For("webrtc").Error("Error unmarshal RTP", "channel", code, "stream_id", streamID, "err", err)
 			return
 		}
+		meter.observe(rtpPacket)
+		jb.push(rtpPacket)
 	}
 }
 
 // streamHandler sirve el archivo static/stream.html
 func streamHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/stream.html")
+	serveAsset(w, r, "stream.html")
 }
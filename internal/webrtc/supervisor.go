@@ -0,0 +1,287 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/transcoder"
+)
+
+// stderrRing guarda las últimas líneas de stderr del transcodificador
+type stderrRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newStderrRing(size int) *stderrRing {
+	return &stderrRing{lines: make([]string, size)}
+}
+
+// add añade una línea, sobrescribiendo la más antigua si el buffer está lleno
+func (r *stderrRing) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.lines) == 0 {
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// tail devuelve como máximo las n últimas líneas en orden cronológico (n <= 0: todas)
+func (r *stderrRing) tail(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	if r.full {
+		out = append(out, r.lines[r.next:]...)
+	}
+	out = append(out, r.lines[:r.next]...)
+	if n > 0 && len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// pipelineStatus es el estado de un pipeline que se expone por la API
+type pipelineStatus struct {
+	Code        string    `json:"code"`
+	StreamID    int       `json:"streamId"`
+	Backend     string    `json:"backend"`
+	InputFormat string    `json:"inputFormat"`
+	Running     bool      `json:"running"`
+	Stalled     bool      `json:"stalled"`
+	Restarts    int       `json:"restarts"`
+	Frames      uint64    `json:"frames"`
+	LastFrameAt time.Time `json:"lastFrameAt"`
+	LastError   string    `json:"lastError,omitempty"`
+	Stderr      []string  `json:"stderr"`
+}
+
+// pipelineSupervisor mantiene vivo el transcodificador de un canal: lo relanza
+// con espera exponencial cuando termina con error o deja de producir JPEGs y
+// pide un keyframe al publicador en cada arranque.
+type pipelineSupervisor struct {
+	code     string
+	streamID int
+	backend  string
+	feed     *transcoderFeed
+	onFrame  func([]byte)
+	stderr   *stderrRing
+
+	mu     sync.Mutex
+	status pipelineStatus
+}
+
+// pipelines registra el supervisor en marcha de cada canal para consultar su
+// estado; la entrada se borra cuando el supervisor termina
+var pipelines = struct {
+	sync.Mutex
+	m map[string]*pipelineSupervisor
+}{m: make(map[string]*pipelineSupervisor)}
+
+// newPipelineSupervisor crea el supervisor del canal y lo registra, sustituyendo al anterior
+func newPipelineSupervisor(code string, streamID int, backend string, feed *transcoderFeed, onFrame func([]byte)) *pipelineSupervisor {
+	s := &pipelineSupervisor{
+		code:     code,
+		streamID: streamID,
+		backend:  backend,
+		feed:     feed,
+		onFrame:  onFrame,
+		stderr:   newStderrRing(serverConfig.Transcoder.StderrLines),
+		status:   pipelineStatus{Code: code, StreamID: streamID, Backend: backend},
+	}
+	pipelines.Lock()
+	pipelines.m[code] = s
+	pipelines.Unlock()
+	return s
+}
+
+// run ejecuta el transcodificador hasta que se cancela el contexto o termina el track
+func (s *pipelineSupervisor) run(ctx context.Context) {
+	defer s.unregister()
+	backoff := serverConfig.Transcoder.RestartBackoff
+	for attempt := 0; ; attempt++ {
+		stdin, inputFormat, err := s.feed.attach()
+		if errors.Is(err, errFeedClosed) {
+			return
+		}
+		if err != nil {
+			s.log().Error("Error preparando la entrada", "err", err)
+			return
+		}
+		if attempt > 0 && s.feed.requestKeyframe != nil {
+			s.feed.requestKeyframe()
+		}
+		s.update(func(st *pipelineStatus) {
+			st.InputFormat = inputFormat
+			st.Running = true
+			st.Stalled = false
+			st.Restarts = attempt
+		})
+		started := time.Now()
+		attemptCtx, cancelAttempt := context.WithCancel(ctx)
+		go s.watchStall(attemptCtx, cancelAttempt, started, serverConfig.Transcoder.StallTimeout)
+		err = s.runOnce(attemptCtx, transcoder.InputSpec{Format: inputFormat, MimeType: s.feed.codec.MimeType, Input: stdin})
+		cancelAttempt()
+		s.update(func(st *pipelineStatus) {
+			st.Running = false
+			if err != nil {
+				st.LastError = err.Error()
+			}
+		})
+		if ctx.Err() != nil || s.feed.isClosed() {
+			return
+		}
+		// Un pipeline que ha funcionado un buen rato vuelve a la espera mínima
+		if time.Since(started) > serverConfig.Transcoder.MaxRestartBackoff {
+			backoff = serverConfig.Transcoder.RestartBackoff
+		}
+		s.log().Warn("El transcodificador terminó, relanzando", "err", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > serverConfig.Transcoder.MaxRestartBackoff {
+			backoff = serverConfig.Transcoder.MaxRestartBackoff
+		}
+	}
+}
+
+// runOnce lanza una instancia del transcodificador y espera a que termine o a que se cancele ctx
+func (s *pipelineSupervisor) runOnce(ctx context.Context, spec transcoder.InputSpec) error {
+	t, err := transcoder.New(s.backend, serverConfig)
+	if err != nil {
+		spec.Input.Close()
+		return err
+	}
+	if err := t.Start(spec, s.frame, s.stderrLine); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, t.Stop)
+	defer stop()
+	return t.Wait()
+}
+
+// watchStall cancela el intento actual si no sale ningún JPEG durante timeout
+func (s *pipelineSupervisor) watchStall(ctx context.Context, cancel context.CancelFunc, started time.Time, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			last := s.status.LastFrameAt
+			s.mu.Unlock()
+			if last.Before(started) {
+				last = started
+			}
+			if now.Sub(last) > timeout {
+				s.log().Warn("Pipeline atascado, sin JPEGs", "since", now.Sub(last).Round(100*time.Millisecond))
+				s.update(func(st *pipelineStatus) { st.Stalled = true })
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// unregister quita el supervisor del registro si otro no lo ha sustituido ya
+func (s *pipelineSupervisor) unregister() {
+	pipelines.Lock()
+	defer pipelines.Unlock()
+	if pipelines.m[s.code] == s {
+		delete(pipelines.m, s.code)
+	}
+}
+
+func (s *pipelineSupervisor) frame(frame []byte) {
+	s.update(func(st *pipelineStatus) {
+		st.Frames++
+		st.LastFrameAt = time.Now()
+	})
+	s.onFrame(frame)
+}
+
+func (s *pipelineSupervisor) stderrLine(line string) {
+	s.stderr.add(line)
+	logging.For("transcoder").Debug(line, "channel", s.code, "stream_id", s.streamID, "backend", s.backend, "stream", "stderr")
+}
+
+// log devuelve el logger del pipeline con el canal, el stream y el backend
+func (s *pipelineSupervisor) log() *slog.Logger {
+	return logging.For("pipeline").With("channel", s.code, "stream_id", s.streamID, "backend", s.backend)
+}
+
+func (s *pipelineSupervisor) update(fn func(*pipelineStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+// snapshot devuelve el estado actual con las últimas n líneas de stderr
+func (s *pipelineSupervisor) snapshot(lines int) pipelineStatus {
+	s.mu.Lock()
+	st := s.status
+	s.mu.Unlock()
+	st.Stderr = s.stderr.tail(lines)
+	return st
+}
+
+// lagState es la situación del pipeline de un stream que usa el control de bitrate
+type lagState struct {
+	Queue   float64 // ocupación de la cola de entrada (0-1)
+	Dropped uint64  // paquetes descartados desde que arrancó el pipeline
+	Stalled bool
+}
+
+// pipelineLag devuelve el estado del pipeline del canal si lo alimenta streamID
+func pipelineLag(code string, streamID int) (lagState, bool) {
+	pipelines.Lock()
+	s, ok := pipelines.m[code]
+	pipelines.Unlock()
+	if !ok || s.streamID != streamID || s.feed.isClosed() {
+		return lagState{}, false
+	}
+	queue, dropped := s.feed.backlog()
+	s.mu.Lock()
+	stalled := s.status.Stalled
+	s.mu.Unlock()
+	return lagState{Queue: queue, Dropped: dropped, Stalled: stalled}, true
+}
+
+// pipelineHandler devuelve en JSON el estado del pipeline de un canal y las
+// últimas líneas de stderr (?lines=N limita cuántas)
+func pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Código de canal requerido", http.StatusBadRequest)
+		return
+	}
+	lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+	pipelines.Lock()
+	s, ok := pipelines.m[code]
+	pipelines.Unlock()
+	if !ok {
+		http.Error(w, "El canal no tiene pipeline", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.snapshot(lines))
+}
@@ -0,0 +1,174 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// waitUntil espera a que cond se cumpla o falla el test tras timeout
+func waitUntil(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("tiempo agotado esperando %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// vp8Keyframe es un paquete RTP con un keyframe VP8 completo (S=1, marker)
+func vp8Keyframe(seq uint16) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(seq) * 3000, Marker: true},
+		Payload: []byte{0x10, 0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01},
+	}
+}
+
+var vp8Codec = webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}}
+
+// El pipeline con el backend falso convierte el RTP del publicador en JPEGs
+// que llegan a los viewers MJPEG del canal
+func TestPipelineFakeBackend(t *testing.T) {
+	const code = "TEST-PIPE"
+	if err := connectionManager.CreateChannel(code); err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.RemoveChannel(code)
+	channel, _ := connectionManager.ValidateChannel(code)
+	viewer, err := channel.AddClient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.AttachStream(1); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := newTranscoderFeed(code, vp8Codec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newPipelineSupervisor(code, 1, "fake", feed, func(frame []byte) {
+		connectionManager.BroadcastToStream(code, 1, frame)
+	})
+	done := make(chan struct{})
+	go func() {
+		s.run(context.Background())
+		close(done)
+	}()
+	waitUntil(t, 2*time.Second, "el arranque del pipeline", func() bool { return s.snapshot(0).Running })
+	for seq := uint16(1); seq <= 5; seq++ {
+		feed.push(vp8Keyframe(seq))
+	}
+	select {
+	case frame := <-viewer.Chan:
+		if !bytes.HasPrefix(frame, []byte{0xFF, 0xD8}) {
+			t.Fatalf("el viewer recibió algo que no es un JPEG: %x", frame[:min(4, len(frame))])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("el viewer no recibió ningún JPEG")
+	}
+	waitUntil(t, 2*time.Second, "los 5 frames", func() bool { return s.snapshot(0).Frames == 5 })
+
+	// /pipeline expone el estado del supervisor
+	rec := httptest.NewRecorder()
+	pipelineHandler(rec, httptest.NewRequest("GET", "/pipeline?code="+code, nil))
+	var st pipelineStatus
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Backend != "fake" || st.InputFormat != "ivf" || st.Frames != 5 || len(st.Stderr) == 0 {
+		t.Errorf("estado inesperado: %+v", st)
+	}
+
+	// Al terminar el track el supervisor sale sin relanzar el transcodificador
+	feed.close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("el supervisor no terminó al cerrar la entrada")
+	}
+	if st := s.snapshot(0); st.Running || st.Restarts != 0 {
+		t.Errorf("estado final inesperado: %+v", st)
+	}
+	rec = httptest.NewRecorder()
+	pipelineHandler(rec, httptest.NewRequest("GET", "/pipeline?code="+code, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/pipeline tras terminar = %d, se esperaba 404", rec.Code)
+	}
+}
+
+// Un transcodificador que falla se relanza con espera exponencial hasta que
+// se cancela el contexto
+func TestPipelineRestartBackoff(t *testing.T) {
+	saved := serverConfig.Transcoder
+	defer func() { serverConfig.Transcoder = saved }()
+	serverConfig.Transcoder.RestartBackoff = 5 * time.Millisecond
+	serverConfig.Transcoder.MaxRestartBackoff = 20 * time.Millisecond
+
+	feed, err := newTranscoderFeed("TEST-RESTART", vp8Codec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.close()
+	keyframes := 0
+	feed.requestKeyframe = func() { keyframes++ }
+	s := newPipelineSupervisor("TEST-RESTART", 1, "inexistente", feed, func([]byte) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	waitUntil(t, 2*time.Second, "tres relanzamientos", func() bool { return s.snapshot(0).Restarts >= 3 })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("el supervisor no terminó al cancelar el contexto")
+	}
+	st := s.snapshot(0)
+	if !strings.Contains(st.LastError, "no soportado") {
+		t.Errorf("LastError = %q", st.LastError)
+	}
+	if keyframes < 3 {
+		t.Errorf("%d keyframes pedidos, se esperaba uno por relanzamiento", keyframes)
+	}
+}
+
+func TestStderrRing(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		lines []string
+		n     int
+		want  []string
+	}{
+		{"vacío", 3, nil, 0, nil},
+		{"sin llenar", 3, []string{"a", "b"}, 0, []string{"a", "b"}},
+		{"sobrescribe las más antiguas", 3, []string{"a", "b", "c", "d", "e"}, 0, []string{"c", "d", "e"}},
+		{"últimas n", 3, []string{"a", "b", "c", "d"}, 2, []string{"c", "d"}},
+		{"tamaño 0", 0, []string{"a"}, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStderrRing(tt.size)
+			for _, l := range tt.lines {
+				r.add(l)
+			}
+			got := r.tail(tt.n)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("tail(%d) = %v, se esperaba %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
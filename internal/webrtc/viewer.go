@@ -0,0 +1,282 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/rpacheco-blazquez/go-pion-stream/internal/logging"
+)
+
+// viewerSession es un viewer que recibe el vídeo del canal por WebRTC. Empieza en
+// la capa simulcast más baja y sube o baja según la estimación de ancho de banda
+// (REMB) o, en su defecto, según la pérdida reportada en los Receiver Reports.
+type viewerSession struct {
+	mu        sync.Mutex
+	code      string
+	clientID  int
+	hub       *layerHub
+	sub       *layerSubscriber
+	rewriter  packetRewriter
+	track     *webrtc.TrackLocalStaticRTP
+	estimate  int // bits/s según el último REMB (0 = desconocido)
+	lossHigh  int // Receiver Reports consecutivos con pérdida alta
+	goodSince time.Time
+}
+
+// viewerPeers registra los PeerConnections de los viewers WebRTC activos para cerrarlos al apagar
+var viewerPeers = struct {
+	sync.Mutex
+	m map[*webrtc.PeerConnection]struct{}
+}{m: make(map[*webrtc.PeerConnection]struct{})}
+
+// closeViewerPeers cierra los PeerConnections de todos los viewers WebRTC
+func closeViewerPeers() {
+	viewerPeers.Lock()
+	peers := make([]*webrtc.PeerConnection, 0, len(viewerPeers.m))
+	for pc := range viewerPeers.m {
+		peers = append(peers, pc)
+	}
+	viewerPeers.Unlock()
+	for _, pc := range peers {
+		_ = pc.Close()
+	}
+}
+
+// watchRTCUIHandler sirve el visor WebRTC HTML
+func watchRTCUIHandler(w http.ResponseWriter, r *http.Request) {
+	serveAsset(w, r, "watchrtc.html")
+}
+
+// handleWebRTCWatch crea la sesión WebRTC de un viewer ya registrado en el canal
+func handleWebRTCWatch(w http.ResponseWriter, r *http.Request, code string, clientID int) {
+	var offerMsg SDPMessage
+	if err := json.NewDecoder(r.Body).Decode(&offerMsg); err != nil {
+		http.Error(w, "SDP inválido", http.StatusBadRequest)
+		return
+	}
+	channel, exists := connectionManager.ValidateChannel(code)
+	if !exists {
+		http.Error(w, "Canal no encontrado", http.StatusNotFound)
+		return
+	}
+	if _, err := channel.GetClient(clientID); err != nil {
+		http.Error(w, "Cliente no registrado en el canal", http.StatusBadRequest)
+		return
+	}
+	hub := lookupLayerHub(code)
+	var layers []layer
+	if hub != nil {
+		layers = hub.layersByBitrate()
+	}
+	if len(layers) == 0 {
+		http.Error(w, "No hay publicador activo en el canal", http.StatusConflict)
+		return
+	}
+	answer, err := createViewerSession(offerMsg, code, clientID, hub, layers[0])
+	if err != nil {
+		logging.For("viewer").Error("Error creando sesión WebRTC", "channel", code, "client_id", clientID, "err", err)
+		http.Error(w, "Error interno WebRTC", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SDPMessage{
+		Type: answer.Type.String(),
+		SDP:  answer.SDP,
+	})
+}
+
+// createViewerSession prepara el PeerConnection de envío hacia el viewer
+func createViewerSession(offer SDPMessage, code string, clientID int, hub *layerHub, initial layer) (*webrtc.SessionDescription, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := registerCodecs(mediaEngine); err != nil {
+		return nil, err
+	}
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		return nil, err
+	}
+	settingEngine, err := newSettingEngine()
+	if err != nil {
+		return nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(interceptorRegistry), webrtc.WithSettingEngine(settingEngine))
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers()})
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(initial.codec.RTPCodecCapability, "video", "gopion-"+code)
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+	sender, err := peerConnection.AddTrack(track)
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+
+	v := &viewerSession{code: code, clientID: clientID, hub: hub, track: track, goodSince: time.Now()}
+	v.sub = &layerSubscriber{write: v.writeRTP}
+	done := make(chan struct{})
+	var closeOnce sync.Once
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			if !hub.subscribe(v.sub) {
+				logging.For("viewer").Info("El publicador reconectó durante la negociación, cerrando el viewer", "channel", code, "client_id", clientID)
+				_ = peerConnection.Close()
+				return
+			}
+			hub.switchLayer(v.sub, initial.rid)
+			logging.For("viewer").Info("Viewer WebRTC conectado", "channel", code, "client_id", clientID, "rid", initial.rid)
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed, webrtc.PeerConnectionStateDisconnected:
+			closeOnce.Do(func() {
+				viewerPeers.Lock()
+				delete(viewerPeers.m, peerConnection)
+				viewerPeers.Unlock()
+				hub.unsubscribe(v.sub)
+				close(done)
+				_ = peerConnection.Close()
+				logging.For("viewer").Info("Viewer WebRTC desconectado", "channel", code, "client_id", clientID)
+			})
+		}
+	})
+	viewerPeers.Lock()
+	viewerPeers.m[peerConnection] = struct{}{}
+	viewerPeers.Unlock()
+	go v.readRTCP(sender)
+	go v.adaptLoop(done)
+
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+	<-gatherComplete
+	return peerConnection.LocalDescription(), nil
+}
+
+// writeRTP reenvía al viewer un paquete de la capa suscrita
+func (v *viewerSession) writeRTP(pkt *rtp.Packet, l *layer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := *pkt
+	if !v.rewriter.rewrite(&out, l) {
+		return
+	}
+	if err := v.track.WriteRTP(&out); err != nil {
+		logging.For("viewer").Debug("Error enviando RTP", "channel", v.code, "client_id", v.clientID, "err", err)
+	}
+}
+
+// readRTCP procesa el feedback del viewer: REMB, Receiver Reports y peticiones de keyframe
+func (v *viewerSession) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, p := range packets {
+			switch pkt := p.(type) {
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				v.mu.Lock()
+				v.estimate = int(pkt.Bitrate)
+				v.mu.Unlock()
+			case *rtcp.ReceiverReport:
+				v.onReceiverReport(pkt)
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				v.hub.switchLayer(v.sub, v.hub.currentLayer(v.sub))
+			}
+		}
+	}
+}
+
+// onReceiverReport actualiza los contadores de pérdida con la fracción reportada
+func (v *viewerSession) onReceiverReport(rr *rtcp.ReceiverReport) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, report := range rr.Reports {
+		fraction := float64(report.FractionLost) / 256
+		switch {
+		case fraction > 0.10:
+			v.lossHigh++
+			v.goodSince = time.Now()
+		case fraction < 0.02:
+			v.lossHigh = 0
+		default:
+			v.lossHigh = 0
+			v.goodSince = time.Now()
+		}
+	}
+}
+
+// adaptLoop reevalúa cada segundo la capa adecuada para el viewer
+func (v *viewerSession) adaptLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			v.adapt()
+		}
+	}
+}
+
+// adapt elige la capa: con REMB, la mayor que cabe en la estimación; sin REMB,
+// baja tras pérdidas sostenidas y sube tras 8 s sin pérdidas.
+func (v *viewerSession) adapt() {
+	layers := v.hub.layersByBitrate()
+	if len(layers) == 0 {
+		return
+	}
+	current := v.hub.currentLayer(v.sub)
+	idx := -1
+	for i, l := range layers {
+		if l.rid == current {
+			idx = i
+		}
+	}
+	v.mu.Lock()
+	target := idx
+	switch {
+	case idx < 0:
+		target = 0
+	case v.estimate > 0:
+		target = 0
+		for i, l := range layers {
+			if float64(l.bitrate) <= float64(v.estimate)*0.9 {
+				target = i
+			}
+		}
+	case v.lossHigh >= 2 && idx > 0:
+		target = idx - 1
+		v.lossHigh = 0
+		v.goodSince = time.Now()
+	case time.Since(v.goodSince) > 8*time.Second && idx < len(layers)-1:
+		target = idx + 1
+		v.goodSince = time.Now()
+	}
+	v.mu.Unlock()
+	if target != idx {
+		logging.For("viewer").Info("Cambio de capa", "channel", v.code, "client_id", v.clientID, "from", current, "to", layers[target].rid)
+		v.hub.switchLayer(v.sub, layers[target].rid)
+	}
+}
@@ -2,7 +2,6 @@ package relay
 
 import (
 	"fmt"
-	"log"
 	"sync"
 )
 
@@ -17,6 +16,10 @@ type Channel struct {
 	FFmpegMJPEGActive bool
 	ffmpegMJPEGCancel func()             // función de cancelación del pipeline MJPEG
 	manager           *ConnectionManager // referencia al padre
+	mediaPolicy       MediaPolicy        // preferencia de códecs y bitrate máximo
+	chat              chatRoom           // historial y suscriptores del chat
+	remoteSource      *RemoteSource      // origen del que se tira del canal (nil = publicador local)
+	persistent        bool               // definición guardada: el canal se mantiene sin clients ni streams
 }
 
 // SetFFmpegMJPEGCancel guarda la función de cancelación del pipeline MJPEG
@@ -72,18 +75,41 @@ func (ch *Channel) SetFFmpegMJPEGActive(active bool) {
 	ch.FFmpegMJPEGActive = active
 }
 
-// AddClient adds a client to the channel.
+// ClaimFFmpegMJPEG marks the MJPEG pipeline active if it was not, so that
+// exactly one track starts it. acquire reserves a global pipeline slot and
+// runs under the channel lock; if it fails the pipeline stays inactive and
+// limited is true.
+func (ch *Channel) ClaimFFmpegMJPEG(acquire func() bool) (claimed, limited bool) {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	if ch.FFmpegMJPEGActive {
+		return false, false
+	}
+	if !acquire() {
+		return false, true
+	}
+	ch.FFmpegMJPEGActive = true
+	return true, false
+}
 
+// AddClient adds a client to the channel.
 func (ch *Channel) AddClient(clientID int) (*Client, error) {
-	if _, exists := ch.clientExist(clientID); exists {
-		return nil, fmt.Errorf("client with ID %d already exists", clientID)
+	maxViewers := 0
+	if ch.manager != nil {
+		maxViewers = ch.manager.maxViewers()
 	}
 	ch.Mutex.Lock()
 	defer ch.Mutex.Unlock()
+	if _, exists := ch.clientExist(clientID); exists {
+		return nil, fmt.Errorf("client with ID %d already exists", clientID)
+	}
+	if maxViewers > 0 && len(ch.Clients) >= maxViewers {
+		return nil, ErrViewerLimit
+	}
 	client := NewClient(clientID)
 	_ = client.Connect() // Inicializa el estado de conexión
 	ch.Clients[clientID] = client
-	log.Printf("[relay] Cliente conectado: clientID=%d canal=%s", clientID, ch.Code)
+	logger().Info("Cliente conectado", "client_id", clientID, "channel", ch.Code)
 	return client, nil
 }
 
@@ -97,7 +123,7 @@ func (ch *Channel) RemoveClient(clientID int) error {
 	}
 	_ = client.Disconnect() // Cierra Done
 	delete(ch.Clients, clientID)
-	log.Printf("[relay] Cliente desconectado: clientID=%d canal=%s", clientID, ch.Code)
+	logger().Info("Cliente desconectado", "client_id", clientID, "channel", ch.Code)
 	// Verificar si el canal debe eliminarse
 	go ch.ChannelNeedToBeRemoved()
 	return nil
@@ -121,13 +147,11 @@ func (ch *Channel) GetClient(clientID int) (*Client, error) {
 }
 
 // AddClient adds a client to a channel.
-func (cm *ConnectionManager) AddClient(channelCode string, clientID int) *Client {
+func (cm *ConnectionManager) AddClient(channelCode string, clientID int) (*Client, error) {
 	if channel, exists := cm.ValidateChannel(channelCode); exists {
-		if client, _ := channel.AddClient(clientID); client != nil {
-			return client
-		}
+		return channel.AddClient(clientID)
 	}
-	return nil
+	return nil, fmt.Errorf("channel with code %s does not exist", channelCode)
 }
 
 // RemoveClient removes a client from a channel.
@@ -135,7 +159,7 @@ func (cm *ConnectionManager) RemoveClient(channelCode string, clientID int) {
 	if channel, exists := cm.ValidateChannel(channelCode); exists {
 		err := channel.RemoveClient(clientID)
 		if err == nil {
-			log.Printf("[relay] Cliente desconectado (ConnectionManager): clientID=%d canal=%s", clientID, channelCode)
+			logger().Debug("Cliente desconectado (ConnectionManager)", "client_id", clientID, "channel", channelCode)
 		}
 	}
 }
@@ -160,12 +184,20 @@ func (ch *Channel) ListClients() []*Client {
 }
 
 // AttachStream associates a stream with the channel.
+// It returns ErrPublisherLimit if the channel already has the maximum number of streams.
 func (ch *Channel) AttachStream(streamID int) (*Stream, error) {
+	maxPublishers := 0
+	if ch.manager != nil {
+		maxPublishers = ch.manager.maxPublishers()
+	}
 	if _, exists := ch.streamExist(streamID); exists {
 		return nil, fmt.Errorf("stream with ID %d already exists in channel %s", streamID, ch.Code)
 	}
 	ch.Mutex.Lock()
 	defer ch.Mutex.Unlock()
+	if maxPublishers > 0 && len(ch.Streams) >= maxPublishers {
+		return nil, ErrPublisherLimit
+	}
 	initialFrame := generateStoppedStreamImage("Esperando video...")
 	stream := &Stream{
 		ID:      streamID,
@@ -176,7 +208,7 @@ func (ch *Channel) AttachStream(streamID int) (*Stream, error) {
 	if ch.ActiveStreamID == nil {
 		ch.SetActiveStreamID(streamID)
 	}
-	log.Printf("[relay] Stream creado: streamID=%d canal=%s", streamID, ch.Code)
+	logger().Info("Stream creado", "stream_id", streamID, "channel", ch.Code)
 	return stream, nil
 }
 
@@ -194,12 +226,29 @@ func (ch *Channel) RemoveStream(streamID int) error {
 	if ch.ActiveStreamID != nil && *ch.ActiveStreamID == streamID {
 		ch.ClearActiveStreamID()
 	}
-	log.Printf("[relay] Stream eliminado: streamID=%d canal=%s", streamID, ch.Code)
+	logger().Info("Stream eliminado", "stream_id", streamID, "channel", ch.Code)
 	// Verificar si el canal debe eliminarse
 	go ch.ChannelNeedToBeRemoved()
 	return nil
 }
 
+// DetachStream undoes AttachStream for a stream that never started (e.g. its
+// offer failed). Unlike RemoveStream it leaves the channel's MJPEG pipeline
+// alone, since that belongs to another publisher.
+func (ch *Channel) DetachStream(streamID int) {
+	ch.Mutex.Lock()
+	stream, exists := ch.streamExist(streamID)
+	if exists {
+		stream.RemovePeerConnection()
+		delete(ch.Streams, streamID)
+	}
+	ch.Mutex.Unlock()
+	if exists {
+		logger().Info("Stream descartado", "stream_id", streamID, "channel", ch.Code)
+		go ch.ChannelNeedToBeRemoved()
+	}
+}
+
 // GetStream retrieves a specific stream associated with the channel.
 func (ch *Channel) GetStream(streamID int) (*Stream, error) {
 	ch.Mutex.Lock()
@@ -247,14 +296,30 @@ func (cm *ConnectionManager) ListStreams(channelCode string) map[int]*Stream {
 	return nil
 }
 
+// SetPersistent marks the channel as a stored definition that is kept while
+// it waits for a publisher, even without clients or streams.
+func (ch *Channel) SetPersistent(persistent bool) {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	ch.persistent = persistent
+}
+
+// IsPersistent reports whether the channel definition is stored.
+func (ch *Channel) IsPersistent() bool {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	return ch.persistent
+}
+
 // ChannelNeedToBeRemoved verifica si el canal debe eliminarse (sin clients ni streams) y lo elimina orgánicamente.
 func (ch *Channel) ChannelNeedToBeRemoved() {
 	ch.Mutex.Lock()
 	clientsEmpty := len(ch.Clients) == 0
 	streamsEmpty := len(ch.Streams) == 0
+	keep := ch.remoteSource != nil || ch.persistent
 	ch.Mutex.Unlock()
-	if clientsEmpty && streamsEmpty && ch.manager != nil {
-		log.Printf("[relay] Canal eliminado orgánicamente: %s", ch.Code)
+	if clientsEmpty && streamsEmpty && !keep && ch.manager != nil {
+		logger().Info("Canal eliminado orgánicamente", "channel", ch.Code)
 		ch.manager.RemoveChannel(ch.Code)
 	}
 }
@@ -0,0 +1,170 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Errores devueltos al publicar en el chat de un canal.
+var (
+	ErrChatRateLimited = errors.New("too many chat messages, slow down")
+	ErrChatInvalid     = errors.New("invalid chat message")
+)
+
+// Roles de quien envía un mensaje de chat.
+const (
+	ChatRoleViewer    = "viewer"
+	ChatRolePublisher = "publisher"
+)
+
+// ChatLimits configures the chat of every channel.
+type ChatLimits struct {
+	History   int     // messages kept for late joiners
+	MaxLength int     // maximum characters per message
+	Rate      float64 // sustained messages per second per sender
+	Burst     int     // messages a sender may send in a burst
+}
+
+// DefaultChatLimits are used until SetChatLimits is called.
+var DefaultChatLimits = ChatLimits{History: 50, MaxLength: 500, Rate: 1, Burst: 5}
+
+// ChatMessage is a text message posted to a channel. Viewers are identified by
+// ClientID; the publisher by StreamID.
+type ChatMessage struct {
+	ID       int64     `json:"id"`
+	Role     string    `json:"role"`
+	ClientID int       `json:"client_id,omitempty"`
+	StreamID int       `json:"stream_id,omitempty"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+}
+
+// chatRoom guarda el historial, los suscriptores y el control de ritmo del
+// chat de un canal. El valor cero está listo para usarse.
+type chatRoom struct {
+	mu        sync.Mutex
+	nextID    int64
+	history   []ChatMessage
+	subs      map[chan ChatMessage]struct{}
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// tokenBucket limita el ritmo de mensajes de un participante
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow consume un token si hay disponible, tras recargar los acumulados desde la última vez
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetChatLimits configures history size, message length and rate limits of the chat.
+func (cm *ConnectionManager) SetChatLimits(limits ChatLimits) {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	cm.ChatLimits = limits
+}
+
+// chatLimits devuelve la configuración del chat.
+func (cm *ConnectionManager) chatLimits() ChatLimits {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	return cm.ChatLimits
+}
+
+// PostChat validates and rate-limits msg, stamps its ID and time, stores it in
+// the history and delivers it to every subscriber of the channel.
+func (ch *Channel) PostChat(msg ChatMessage) (ChatMessage, error) {
+	limits := DefaultChatLimits
+	if ch.manager != nil {
+		limits = ch.manager.chatLimits()
+	}
+	msg.Text = strings.TrimSpace(msg.Text)
+	if msg.Text == "" {
+		return msg, fmt.Errorf("%w: empty text", ErrChatInvalid)
+	}
+	if !utf8.ValidString(msg.Text) || utf8.RuneCountInString(msg.Text) > limits.MaxLength {
+		return msg, fmt.Errorf("%w: text longer than %d characters", ErrChatInvalid, limits.MaxLength)
+	}
+
+	room := &ch.chat
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	now := time.Now()
+	sender := fmt.Sprintf("%s/%d/%d", msg.Role, msg.ClientID, msg.StreamID)
+	if room.buckets == nil {
+		room.buckets = make(map[string]*tokenBucket)
+	}
+	// Los buckets llenos de nuevo equivalen a uno recién creado: se olvidan
+	// cada minuto para que los participantes que se fueron no se acumulen
+	if now.Sub(room.lastPrune) > time.Minute {
+		for key, b := range room.buckets {
+			if now.Sub(b.last).Seconds()*limits.Rate >= float64(limits.Burst) {
+				delete(room.buckets, key)
+			}
+		}
+		room.lastPrune = now
+	}
+	bucket, ok := room.buckets[sender]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limits.Burst), last: now}
+		room.buckets[sender] = bucket
+	}
+	if !bucket.allow(now, limits.Rate, limits.Burst) {
+		return msg, ErrChatRateLimited
+	}
+
+	room.nextID++
+	msg.ID = room.nextID
+	msg.Time = now
+	room.history = append(room.history, msg)
+	if extra := len(room.history) - limits.History; extra > 0 {
+		room.history = append(room.history[:0:0], room.history[extra:]...)
+	}
+	for sub := range room.subs {
+		select {
+		case sub <- msg:
+		default:
+			logger().Debug("Mensaje de chat descartado para un suscriptor lento", "channel", ch.Code, "id", msg.ID)
+		}
+	}
+	return msg, nil
+}
+
+// SubscribeChat returns the chat history and a channel with every message
+// posted afterwards. cancel must be called to stop the subscription; it
+// closes the returned channel.
+func (ch *Channel) SubscribeChat() (history []ChatMessage, messages <-chan ChatMessage, cancel func()) {
+	room := &ch.chat
+	sub := make(chan ChatMessage, 16)
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.subs == nil {
+		room.subs = make(map[chan ChatMessage]struct{})
+	}
+	room.subs[sub] = struct{}{}
+	history = append([]ChatMessage(nil), room.history...)
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			room.mu.Lock()
+			defer room.mu.Unlock()
+			delete(room.subs, sub)
+			close(sub)
+		})
+	}
+	return history, sub, cancel
+}
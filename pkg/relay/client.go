@@ -1,12 +1,21 @@
 package relay
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 )
 
+// Roles de un viewer en su canal: el operador (quien creó el canal) puede
+// controlar la cámara del publicador; el resto de viewers sólo mirar.
+const (
+	ClientRoleOperator = "operator"
+	ClientRoleViewer   = "viewer"
+)
+
 // Client represents a viewer connected to a channel.
 type Client struct {
 	ID        int
@@ -15,20 +24,56 @@ type Client struct {
 	IP        string                 // Dirección IP del cliente
 	Connected time.Time              // Timestamp de conexión
 	LastFrame time.Time              // Timestamp del último frame enviado
-	LastLog   time.Time              // Timestamp del último log.Printf de envío/descartado
+	LastLog   time.Time              // Timestamp del último log de envío/descartado
 	Mutex     sync.Mutex             // Para manejar concurrencia en campos adicionales
 	Metadata  map[string]interface{} // Información adicional (extensible)
+	Role      string                 // ClientRoleOperator o ClientRoleViewer
+	// Token identifica al cliente en las peticiones que actúan en su nombre
+	// (chat, órdenes de control...); el ID es público y fácil de adivinar
+	Token string
 }
 
 // NewClient creates and initializes a new Client.
 
 func NewClient(id int) *Client {
-	log.Printf("[relay] NewClient creado: clientID=%d", id)
+	logger().Debug("NewClient creado", "client_id", id)
 	return &Client{
-		ID:   id,
-		Chan: make(chan []byte, 1),
-		Done: make(chan struct{}),
+		ID:    id,
+		Chan:  make(chan []byte, 1),
+		Done:  make(chan struct{}),
+		Token: newClientToken(),
+	}
+}
+
+// newClientToken returns 128 random bits in hex.
+func newClientToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("relay: crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidToken reports whether token is the client's token, in constant time.
+func (c *Client) ValidToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
+
+// SetRole sets the role of the client in its channel.
+func (c *Client) SetRole(role string) {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	c.Role = role
+}
+
+// GetRole returns the role of the client, ClientRoleViewer if none was set.
+func (c *Client) GetRole() string {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if c.Role == "" {
+		return ClientRoleViewer
 	}
+	return c.Role
 }
 
 // Connect establishes the client's connection.
@@ -0,0 +1,24 @@
+package relay
+
+// MediaPolicy describes how a channel negotiates media with its publishers.
+type MediaPolicy struct {
+	CodecPreference []string // MIME types in order of preference, e.g. "video/H264"
+	MaxBitrateKbps  int      // upper bound requested to the publisher (0 = unlimited)
+	Transcoder      string   // MJPEG transcoder backend, e.g. "ffmpeg" (empty = server default)
+}
+
+// SetMediaPolicy sets the codec preference, bitrate and transcoder policy of the channel.
+func (ch *Channel) SetMediaPolicy(policy MediaPolicy) {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	ch.mediaPolicy = policy
+}
+
+// GetMediaPolicy returns a copy of the channel media policy.
+func (ch *Channel) GetMediaPolicy() MediaPolicy {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	policy := ch.mediaPolicy
+	policy.CodecPreference = append([]string(nil), policy.CodecPreference...)
+	return policy
+}
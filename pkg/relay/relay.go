@@ -1,37 +1,110 @@
 package relay
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"sync"
 )
 
+// Errores devueltos cuando se alcanza alguno de los límites configurados.
+var (
+	ErrChannelLimit   = errors.New("maximum number of channels reached")
+	ErrViewerLimit    = errors.New("maximum number of viewers per channel reached")
+	ErrPublisherLimit = errors.New("maximum number of publishers per channel reached")
+)
+
+// logger returns the relay logger. It is resolved on every call so that it
+// picks up the handler the application installs with slog.SetDefault.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "relay")
+}
+
 // ConnectionManager manages all channels, clients, and streams.
 type ConnectionManager struct {
 	Channels map[string]*Channel
 	Mutex    sync.Mutex
+	// Límites de recursos (0 = sin límite)
+	MaxChannels             int
+	MaxViewersPerChannel    int
+	MaxPublishersPerChannel int
+	// Historial, longitud y ritmo de los mensajes de chat
+	ChatLimits ChatLimits
+	// Recibe cada frame difundido (DVR, detección de movimiento...)
+	frameObserver FrameObserver
 }
 
+// FrameObserver receives every frame broadcast to a channel stream. It runs
+// synchronously on the goroutine calling BroadcastToStream, outside the
+// channel lock and after the frame has been offered to the clients. A slow
+// observer (e.g. one writing to disk) does not delay the current frame, but
+// it does delay the next broadcast of the stream.
+type FrameObserver func(channelCode string, streamID int, frame []byte)
+
 // NewConnectionManager creates and initializes a new ConnectionManager.
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
-		Channels: make(map[string]*Channel),
+		Channels:   make(map[string]*Channel),
+		ChatLimits: DefaultChatLimits,
 	}
 }
 
+// SetLimits configura los límites de canales, viewers y publicadores por canal (0 = sin límite).
+func (cm *ConnectionManager) SetLimits(maxChannels, maxViewersPerChannel, maxPublishersPerChannel int) {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	cm.MaxChannels = maxChannels
+	cm.MaxViewersPerChannel = maxViewersPerChannel
+	cm.MaxPublishersPerChannel = maxPublishersPerChannel
+}
+
+// SetFrameObserver installs fn to receive every frame passed to BroadcastToStream.
+func (cm *ConnectionManager) SetFrameObserver(fn FrameObserver) {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	cm.frameObserver = fn
+}
+
 // CreateChannel creates a new channel with the given code.
+// It returns ErrChannelLimit if the channel does not exist and the limit is reached.
+func (cm *ConnectionManager) CreateChannel(code string) error {
+	_, err := cm.EnsureChannel(code)
+	return err
+}
 
-func (cm *ConnectionManager) CreateChannel(code string) {
-	if _, exists := cm.ValidateChannel(code); !exists {
-		cm.Mutex.Lock()
-		cm.Channels[code] = &Channel{
-			Code:    code,
-			Clients: make(map[int]*Client),
-			Streams: make(map[int]*Stream),
-			manager: cm,
-		}
-		log.Printf("[relay] Canal creado: %s", code)
-		cm.Mutex.Unlock()
+// EnsureChannel creates the channel if it does not exist and reports whether
+// this call created it. Check and creation happen under the same lock, so of
+// several concurrent callers exactly one gets created == true.
+func (cm *ConnectionManager) EnsureChannel(code string) (created bool, err error) {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	if _, exists := cm.Channels[code]; exists {
+		return false, nil
+	}
+	if cm.MaxChannels > 0 && len(cm.Channels) >= cm.MaxChannels {
+		return false, ErrChannelLimit
+	}
+	cm.Channels[code] = &Channel{
+		Code:    code,
+		Clients: make(map[int]*Client),
+		Streams: make(map[int]*Stream),
+		manager: cm,
 	}
+	logger().Info("Canal creado", "channel", code)
+	return true, nil
+}
+
+// maxViewers devuelve el límite de viewers por canal.
+func (cm *ConnectionManager) maxViewers() int {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	return cm.MaxViewersPerChannel
+}
+
+// maxPublishers devuelve el límite de publicadores (streams) por canal.
+func (cm *ConnectionManager) maxPublishers() int {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+	return cm.MaxPublishersPerChannel
 }
 
 // RemoveChannel removes a channel and closes all associated clients.
@@ -49,10 +122,52 @@ func (cm *ConnectionManager) RemoveChannel(code string) {
 	}
 }
 
+// Shutdown stops every stream (canceling its MJPEG pipeline and closing its
+// PeerConnection) and disconnects every client after queueing an offline slate
+// with the given message as its last frame.
+func (cm *ConnectionManager) Shutdown(message string) {
+	slate := generateStoppedStreamImage(message)
+	for _, code := range cm.ListAllChannels() {
+		channel, exists := cm.ValidateChannel(code)
+		if !exists {
+			continue
+		}
+		channel.Mutex.Lock()
+		streams := make([]*Stream, 0, len(channel.Streams))
+		for _, stream := range channel.Streams {
+			streams = append(streams, stream)
+		}
+		channel.Mutex.Unlock()
+		for _, stream := range streams {
+			_ = stream.Stop()
+			if err := channel.RemoveStream(stream.ID); err != nil {
+				logger().Error("Error eliminando stream", "stream_id", stream.ID, "channel", code, "err", err)
+			}
+		}
+		clients := channel.ListClients()
+		for _, client := range clients {
+			// Sustituir cualquier frame pendiente por la imagen de fuera de servicio
+			select {
+			case <-client.Chan:
+			default:
+			}
+			if slate != nil {
+				select {
+				case client.Chan <- slate:
+				default:
+				}
+			}
+			_ = client.Disconnect()
+		}
+		logger().Info("Canal detenido", "channel", code, "streams", len(streams), "clients", len(clients))
+	}
+}
+
 func (cm *ConnectionManager) BroadcastToStream(channelCode string, streamID int, frame []byte) {
 	if channel, exists := cm.ValidateChannel(channelCode); exists {
 		channel.Mutex.Lock()
-		if stream, exists := channel.streamExist(streamID); exists {
+		stream, exists := channel.streamExist(streamID)
+		if exists {
 			stream.Data = frame
 			for _, client := range channel.Clients {
 				select {
@@ -62,6 +177,12 @@ func (cm *ConnectionManager) BroadcastToStream(channelCode string, streamID int,
 			}
 		}
 		channel.Mutex.Unlock()
+		cm.Mutex.Lock()
+		observer := cm.frameObserver
+		cm.Mutex.Unlock()
+		if exists && observer != nil {
+			observer(channelCode, streamID, frame)
+		}
 	}
 }
 
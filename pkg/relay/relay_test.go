@@ -0,0 +1,270 @@
+package relay
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name                              string
+		maxChannels, maxViewers, maxPubs  int
+		channels, viewers, publishers     int
+		wantChannel, wantViewer, wantPubs error
+	}{
+		{"unlimited", 0, 0, 0, 5, 5, 5, nil, nil, nil},
+		{"channel limit", 2, 0, 0, 3, 1, 1, ErrChannelLimit, nil, nil},
+		{"viewer limit", 0, 2, 0, 1, 3, 1, nil, ErrViewerLimit, nil},
+		{"publisher limit", 0, 0, 1, 1, 1, 2, nil, nil, ErrPublisherLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewConnectionManager()
+			cm.SetLimits(tt.maxChannels, tt.maxViewers, tt.maxPubs)
+			var err error
+			for i := 0; i < tt.channels; i++ {
+				err = cm.CreateChannel(string(rune('A' + i)))
+			}
+			if !errors.Is(err, tt.wantChannel) {
+				t.Fatalf("last CreateChannel = %v, want %v", err, tt.wantChannel)
+			}
+			ch, _ := cm.ValidateChannel("A")
+			for i := 0; i < tt.viewers; i++ {
+				_, err = ch.AddClient(i + 1)
+			}
+			if !errors.Is(err, tt.wantViewer) {
+				t.Fatalf("last AddClient = %v, want %v", err, tt.wantViewer)
+			}
+			for i := 0; i < tt.publishers; i++ {
+				_, err = ch.AttachStream(i + 1)
+			}
+			if !errors.Is(err, tt.wantPubs) {
+				t.Fatalf("last AttachStream = %v, want %v", err, tt.wantPubs)
+			}
+		})
+	}
+}
+
+func TestEnsureChannelSingleCreator(t *testing.T) {
+	cm := NewConnectionManager()
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := cm.EnsureChannel("ABC"); err == nil && ok {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := created.Load(); n != 1 {
+		t.Fatalf("%d callers created the channel, want exactly 1", n)
+	}
+}
+
+func TestBroadcastToStream(t *testing.T) {
+	cm := NewConnectionManager()
+	var observed atomic.Int32
+	cm.SetFrameObserver(func(code string, streamID int, frame []byte) {
+		if code == "ABC" && streamID == 7 {
+			observed.Add(1)
+		}
+	})
+	cm.CreateChannel("ABC")
+	ch, _ := cm.ValidateChannel("ABC")
+	viewer, err := ch.AddClient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.AttachStream(7); err != nil {
+		t.Fatal(err)
+	}
+	cm.BroadcastToStream("ABC", 7, []byte("frame-1"))
+	// The client buffer holds one frame: a slow viewer skips frames instead of blocking
+	cm.BroadcastToStream("ABC", 7, []byte("frame-2"))
+	cm.BroadcastToStream("ABC", 99, []byte("unknown stream"))
+	select {
+	case got := <-viewer.Chan:
+		if string(got) != "frame-1" {
+			t.Errorf("viewer got %q, want frame-1", got)
+		}
+	default:
+		t.Fatal("viewer got no frame")
+	}
+	if n := observed.Load(); n != 2 {
+		t.Errorf("observer saw %d frames, want 2", n)
+	}
+}
+
+func TestClaimFFmpegMJPEG(t *testing.T) {
+	tests := []struct {
+		name        string
+		active      bool
+		acquire     bool
+		wantClaimed bool
+		wantLimited bool
+		wantActive  bool
+	}{
+		{"free slot", false, true, true, false, true},
+		{"no slot", false, false, false, true, false},
+		{"already running", true, true, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Channel{Code: "ABC", FFmpegMJPEGActive: tt.active}
+			calls := 0
+			claimed, limited := ch.ClaimFFmpegMJPEG(func() bool { calls++; return tt.acquire })
+			if claimed != tt.wantClaimed || limited != tt.wantLimited {
+				t.Errorf("ClaimFFmpegMJPEG = (%v, %v), want (%v, %v)", claimed, limited, tt.wantClaimed, tt.wantLimited)
+			}
+			if tt.active && calls != 0 {
+				t.Error("acquire called for a channel whose pipeline is already running")
+			}
+			if ch.IsFFmpegMJPEGActive() != tt.wantActive {
+				t.Errorf("active = %v, want %v", ch.IsFFmpegMJPEGActive(), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestDetachStreamKeepsPipeline(t *testing.T) {
+	cm := NewConnectionManager()
+	cm.CreateChannel("ABC")
+	ch, _ := cm.ValidateChannel("ABC")
+	if _, err := ch.AddClient(1); err != nil {
+		t.Fatal(err)
+	}
+	ch.AttachStream(1)
+	canceled := false
+	ch.SetFFmpegMJPEGCancel(func() { canceled = true })
+	ch.AttachStream(2)
+	ch.DetachStream(2)
+	if _, err := ch.GetStream(2); err == nil {
+		t.Error("stream 2 still attached")
+	}
+	if canceled {
+		t.Error("DetachStream canceled the MJPEG pipeline of another publisher")
+	}
+	if err := ch.RemoveStream(1); err != nil {
+		t.Fatal(err)
+	}
+	if !canceled {
+		t.Error("RemoveStream did not cancel the MJPEG pipeline")
+	}
+}
+
+func TestChannelRemovedWhenEmpty(t *testing.T) {
+	tests := []struct {
+		name       string
+		persistent bool
+		remote     bool
+		wantKept   bool
+	}{
+		{"ephemeral", false, false, false},
+		{"persistent", true, false, true},
+		{"edge", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewConnectionManager()
+			cm.CreateChannel("ABC")
+			ch, _ := cm.ValidateChannel("ABC")
+			ch.SetPersistent(tt.persistent)
+			if tt.remote {
+				ch.SetRemoteSource(&RemoteSource{Origin: "https://origin:8080", Code: "ABC"})
+			}
+			ch.AddClient(1)
+			if err := ch.RemoveClient(1); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantKept {
+				time.Sleep(50 * time.Millisecond)
+				if _, exists := cm.ValidateChannel("ABC"); !exists {
+					t.Fatal("channel removed")
+				}
+				return
+			}
+			waitFor(t, func() bool {
+				_, exists := cm.ValidateChannel("ABC")
+				return !exists
+			})
+		})
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	a, b := NewClient(1), NewClient(2)
+	if a.Token == "" || a.Token == b.Token {
+		t.Fatalf("tokens %q and %q must be non-empty and distinct", a.Token, b.Token)
+	}
+	for _, tt := range []struct {
+		token string
+		want  bool
+	}{
+		{a.Token, true},
+		{b.Token, false},
+		{"", false},
+		{a.Token[:len(a.Token)-1], false},
+	} {
+		if got := a.ValidToken(tt.token); got != tt.want {
+			t.Errorf("ValidToken(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestChatBucketsPruned(t *testing.T) {
+	cm := NewConnectionManager()
+	cm.SetChatLimits(ChatLimits{History: 10, MaxLength: 100, Rate: 1, Burst: 2})
+	if err := cm.CreateChannel("ABC"); err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := cm.ValidateChannel("ABC")
+	post := func(clientID int) error {
+		_, err := ch.PostChat(ChatMessage{Role: ChatRoleViewer, ClientID: clientID, Text: "hi"})
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := post(1); err != nil {
+			t.Fatalf("post %d = %v, want nil", i, err)
+		}
+	}
+	if err := post(1); !errors.Is(err, ErrChatRateLimited) {
+		t.Fatalf("post over the burst = %v, want %v", err, ErrChatRateLimited)
+	}
+
+	// Pretend a minute has gone by since client 1 last posted and the last prune.
+	room := &ch.chat
+	room.mu.Lock()
+	room.buckets["viewer/1/0"].last = time.Now().Add(-time.Minute)
+	room.lastPrune = time.Now().Add(-2 * time.Minute)
+	room.mu.Unlock()
+
+	if err := post(2); err != nil {
+		t.Fatalf("post from client 2 = %v, want nil", err)
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if _, ok := room.buckets["viewer/1/0"]; ok {
+		t.Error("the refilled bucket of client 1 was not pruned")
+	}
+	if _, ok := room.buckets["viewer/2/0"]; !ok {
+		t.Error("the bucket of client 2 is missing")
+	}
+}
@@ -0,0 +1,29 @@
+package relay
+
+// RemoteSource describes the origin server a channel is pulled from. A channel
+// with a remote source is fed by the edge puller instead of a local publisher
+// and is kept while the puller runs, even without clients or streams.
+type RemoteSource struct {
+	Origin string // base URL of the origin instance, e.g. https://origin:8080
+	Code   string // channel code on the origin
+	Share  string // share token required by origins that restrict their viewers
+}
+
+// SetRemoteSource marks the channel as pulled from an origin (nil = local publisher).
+func (ch *Channel) SetRemoteSource(src *RemoteSource) {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	ch.remoteSource = src
+}
+
+// GetRemoteSource returns a copy of the channel remote source, or nil if the
+// channel is fed by a local publisher.
+func (ch *Channel) GetRemoteSource() *RemoteSource {
+	ch.Mutex.Lock()
+	defer ch.Mutex.Unlock()
+	if ch.remoteSource == nil {
+		return nil
+	}
+	src := *ch.remoteSource
+	return &src
+}
@@ -7,7 +7,6 @@ import (
 	"image/color"
 	"image/draw"
 	"image/jpeg"
-	"log"
 	"sync"
 	"time"
 
@@ -38,7 +37,7 @@ func (s *Stream) Start() error {
 		return fmt.Errorf("stream %d is already running", s.ID)
 	}
 	s.Running = true
-	log.Printf("[relay] Stream iniciado: streamID=%d", s.ID)
+	logger().Info("Stream iniciado", "stream_id", s.ID)
 	return nil
 }
 
@@ -50,7 +49,7 @@ func (s *Stream) Stop() error {
 		return fmt.Errorf("stream %d is not running", s.ID)
 	}
 	s.Running = false
-	log.Printf("[relay] Stream detenido: streamID=%d", s.ID)
+	logger().Info("Stream detenido", "stream_id", s.ID)
 	return nil
 }
 
@@ -211,7 +210,7 @@ func (ch *Channel) StopStream(streamID int) error {
 	}(clients, stoppedImage, ch)
 	if err := ch.RemoveStream(streamID); err != nil {
 		// Only log critical error
-		log.Printf("[Channel] Error removing stream %d: %v", streamID, err)
+		logger().Error("Error removing stream", "stream_id", streamID, "channel", ch.Code, "err", err)
 	}
 	return nil
 }
@@ -228,12 +227,12 @@ func generateStoppedStreamImage(message string) []byte {
 	// Load the font from embedded FS
 	fontBytes, err := liberationSansTTF.ReadFile("LiberationSans-Regular.ttf")
 	if err != nil {
-		log.Printf("[Error] Embedded LiberationSans-Regular.ttf not found in binary: %v", err)
+		logger().Error("Embedded LiberationSans-Regular.ttf not found in binary", "err", err)
 		return nil
 	}
 	font, err := truetype.Parse(fontBytes)
 	if err != nil {
-		log.Printf("[Error] Failed to parse embedded font: %v", err)
+		logger().Error("Failed to parse embedded font", "err", err)
 		return nil
 	}
 
@@ -257,14 +256,14 @@ func generateStoppedStreamImage(message string) []byte {
 	// Draw the text
 	_, err = c.DrawString(message, pt)
 	if err != nil {
-		log.Printf("[Error] Failed to draw text: %v", err)
+		logger().Error("Failed to draw text", "err", err)
 		return nil
 	}
 
 	// Encode the image to JPEG
 	buf := new(bytes.Buffer)
 	if err := jpeg.Encode(buf, img, nil); err != nil {
-		log.Printf("[Error] Failed to encode stopped stream image: %v", err)
+		logger().Error("Failed to encode stopped stream image", "err", err)
 		return nil
 	}
 
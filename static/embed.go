@@ -0,0 +1,10 @@
+// Package static contiene las páginas HTML y las plantillas de la interfaz
+// web, embebidas en el binario para que funcione sin ficheros externos.
+package static
+
+import "embed"
+
+// Files son los ficheros estáticos embebidos
+//
+//go:embed *.html *.tmpl
+var Files embed.FS